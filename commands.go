@@ -0,0 +1,160 @@
+/*
+File		: commands.go
+Description	: Subcommands of the API executable that are run from the command line instead of starting the server.
+Usage		: CardaliaAPI import-bulk [-lang en] <bulk file>
+		  CardaliaAPI send-digest
+		  CardaliaAPI find-cycles
+		  CardaliaAPI refresh-prices
+		  CardaliaAPI snapshot-prices
+*/
+
+package main
+
+import (
+	"CardaliaAPI/connections"
+	"CardaliaAPI/models"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+/*
+Function	: Run command
+Description	: Run the subcommand given in the command line arguments.
+Parameters 	: arguments (without the executable name)
+Return     	: true if a subcommand was run, false if the server has to start
+*/
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "import-bulk":
+		importBulk(args[1:])
+	case "send-digest":
+		sendDigest()
+	case "find-cycles":
+		findCycles()
+	case "refresh-prices":
+		refreshPrices()
+	case "snapshot-prices":
+		snapshotPrices()
+	default:
+		log.Fatalf("Unknown command %q", args[0])
+	}
+	return true
+}
+
+/*
+Function	: Import bulk
+Description	: Import a Scryfall bulk data file (default_cards or all_cards) from disk to the card catalog.
+Parameters 	: command arguments
+Return     	:
+*/
+func importBulk(args []string) {
+	flags := flag.NewFlagSet("import-bulk", flag.ExitOnError)
+	lang := flags.String("lang", "en", "only import the cards in this language (empty for all languages)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: import-bulk [-lang en] <bulk file>")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal("Cannot open bulk file: ", err)
+	}
+	defer file.Close()
+
+	models.ConnectDataBase()
+	imported, err := connections.ImportScryfallBulk(file, *lang)
+	if err != nil {
+		log.Fatalf("Import stopped after %d cards: %v", imported, err)
+	}
+	fmt.Println("Cards imported:", imported)
+}
+
+/*
+Function	: Send digest
+Description	: Send the daily digest of the email notifications to the users that chose it. Run once a day (cron).
+Parameters 	:
+Return     	:
+*/
+func sendDigest() {
+	models.ConnectDataBase()
+	connections.ConnectMailer()
+	sent, err := connections.SendDigestsDB(context.Background())
+	if err != nil {
+		log.Fatalf("Digest stopped after %d emails: %v", sent, err)
+	}
+	fmt.Println("Digests sent:", sent)
+}
+
+/*
+Function	: Find cycles
+Description	: Find rings of users that can trade their wanted cards and propose them as trade cycles. Run it
+periodically (cron).
+
+Parameters 	:
+Return     	:
+*/
+func findCycles() {
+	models.ConnectDataBase()
+	proposed, err := connections.FindTradeCyclesDB(context.Background())
+	if err != nil {
+		log.Fatalf("Matching stopped after %d cycles: %v", proposed, err)
+	}
+	fmt.Println("Trade cycles proposed:", proposed)
+	// Send the events of the new cycles to the webhooks before exiting
+	connections.WaitWebhooks()
+}
+
+/*
+Function	: Refresh prices
+Description	: Get from Scryfall the prices of all the card versions in the collections. Run it once a day (cron); the
+prices of the cards in a bulk file are stored by import-bulk.
+
+Parameters 	:
+Return     	:
+*/
+func refreshPrices() {
+	models.ConnectDataBase()
+	refreshed, err := connections.RefreshPricesDB(context.Background())
+	if err != nil {
+		log.Fatalf("Refresh stopped after %d cards: %v", refreshed, err)
+	}
+	fmt.Println("Card prices refreshed:", refreshed)
+}
+
+/*
+Function	: Snapshot prices
+Description	: Store the prices and the collection values of today, and send the price changes of the price alerts. Run
+it once a day (cron), after refresh-prices.
+
+Parameters 	:
+Return     	:
+*/
+func snapshotPrices() {
+	models.ConnectDataBase()
+	connections.ConnectCardProvider()
+	connections.ConnectMailer()
+	ctx := context.Background()
+	prices, err := connections.SnapshotPricesDB(ctx)
+	if err != nil {
+		log.Fatalf("Snapshot stopped after %d cards: %v", prices, err)
+	}
+	fmt.Println("Card prices stored:", prices)
+	collections, err := connections.SnapshotCollectionValuesDB(ctx)
+	if err != nil {
+		log.Fatalf("Snapshot stopped after %d collections: %v", collections, err)
+	}
+	fmt.Println("Collection values stored:", collections)
+	changes, err := connections.CheckPriceAlertsDB(ctx)
+	if err != nil {
+		log.Fatalf("Price alerts stopped after %d price changes: %v", changes, err)
+	}
+	fmt.Println("Price changes sent:", changes)
+	// Send the events of the price alerts to the webhooks before exiting
+	connections.WaitWebhooks()
+}
@@ -0,0 +1,37 @@
+/*
+File		: DBconnections_test.go
+Description	: Tests of the resolution of the cards of the collections.
+*/
+
+package connections
+
+import (
+	"context"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestResolveCardsUnknownVersion(t *testing.T) {
+	useFakeProvider(t)
+	const unknown = "00000000-0000-4000-8000-0000000000ff"
+	cardOwnerships := []models.CardOwnership{
+		{VersionID: boltLEA, OracleID: "bolt"},
+		{VersionID: unknown, OracleID: "lost"},
+		{VersionID: boltLEA, OracleID: "bolt"},
+	}
+	cards, err := resolveCards(context.Background(), cardOwnerships)
+	if err != nil {
+		t.Fatalf("an unknown version failed the whole list: %v", err)
+	}
+	if len(cards) != 2 {
+		t.Errorf("got %d cards, want 2", len(cards))
+	}
+	if card := cards[boltLEA]; card.Name != "Lightning Bolt" || card.Unresolved {
+		t.Errorf("known version: got %+v", card)
+	}
+	want := models.Card{ID: unknown, OracleID: "lost", Unresolved: true}
+	if card := cards[unknown]; card != want {
+		t.Errorf("unknown version: got %+v, want %+v", card, want)
+	}
+}
@@ -0,0 +1,317 @@
+/*
+File		: cardProvider.go
+Description	: File that defines where the card information comes from. All the card lookups of the API go through the
+CardProvider in Cards, that is chosen when the API starts (CARD_PROVIDER):
+  - scryfall	: the local catalog in front of the Scryfall API (default)
+  - catalog	: only the local catalog, without network (also used if SCRYFALL_OFFLINE=true)
+  - fake	: an in-memory list of cards loaded from a fixture file (CARD_FIXTURE)
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"CardaliaAPI/models"
+)
+
+// Default time a card from the catalog is considered valid
+const defaultCatalogTTLHours = 168
+
+// Source of all the card information
+type CardProvider interface {
+	// Get a card by its ID (version ID)
+	CardByID(ctx context.Context, id string) (models.Card, error)
+	// Get a list of cards by their IDs. The IDs that don't exist are not in the map.
+	CardsByID(ctx context.Context, ids []string) (map[string]models.Card, error)
+	// Get a list of cards by their set code and collector number. The cards that don't exist are not in the map.
+	CardsBySetNumber(ctx context.Context, keys []models.SetNumber) (map[models.SetNumber]models.Card, error)
+	// Get a card by its exact name
+	CardByName(ctx context.Context, name string) (models.Card, error)
+	// Get the names of the cards that match a partial name
+	Autocomplete(ctx context.Context, partial string) ([]string, error)
+	// Get all the paper versions of a card
+	Printings(ctx context.Context, name string) ([]models.CardVersion, error)
+}
+
+// Card provider used by the API
+var Cards CardProvider
+
+// Catalog in front of another provider. The cards recived from the upstream provider are stored in the catalog.
+type CachedProvider struct {
+	Catalog  CatalogProvider
+	Upstream ScryfallProvider
+}
+
+/*
+Function	: Connect card provider
+Description	: Choose the card provider of the API from the enviroment variables.
+Parameters 	:
+Return     	:
+*/
+func ConnectCardProvider() {
+	provider := os.Getenv("CARD_PROVIDER")
+	if offline, _ := strconv.ParseBool(os.Getenv("SCRYFALL_OFFLINE")); offline {
+		provider = "catalog"
+	}
+
+	switch provider {
+	case "", "scryfall":
+		Cards = CachedProvider{
+			Catalog:  CatalogProvider{TTL: catalogTTL()},
+			Upstream: NewScryfallProvider(),
+		}
+	case "catalog":
+		Cards = CatalogProvider{}
+	case "fake":
+		fake, err := NewFakeProvider(os.Getenv("CARD_FIXTURE"))
+		if err != nil {
+			log.Fatal("Cannot load card fixture: ", err)
+		}
+		Cards = fake
+	default:
+		log.Fatalf("Unknown card provider %q", provider)
+	}
+	fmt.Println("Card provider:", provider)
+}
+
+/*
+Function	: Card by ID
+Description	: Get a card from the catalog or, if it is missing or too old, from Scryfall. If Scryfall is down,
+an old card from the catalog is better than nothing.
+
+Self		: CachedProvider
+Parameters 	: context, cardID
+Return     	: Card, error
+*/
+func (p CachedProvider) CardByID(ctx context.Context, id string) (models.Card, error) {
+	card, err := p.Catalog.CardByID(ctx, id)
+	if err == nil {
+		return card, nil
+	}
+	newCard, err := p.Upstream.getCardByID(ctx, id)
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		if card, staleErr := p.stale().CardByID(ctx, id); staleErr == nil {
+			return card, nil
+		}
+	}
+	if err != nil {
+		return newCard.Card, err
+	}
+	p.Catalog.store(newCard)
+	return newCard.Card, nil
+}
+
+/*
+Function	: Cards by ID
+Description	: Get a list of cards from the catalog. The cards that are missing or too old are asked to Scryfall in
+batches. If Scryfall is down, the old cards from the catalog are used.
+
+Self		: CachedProvider
+Parameters 	: context, cardID list
+Return     	: cardID -> Card map, error
+*/
+func (p CachedProvider) CardsByID(ctx context.Context, ids []string) (map[string]models.Card, error) {
+	cards, err := p.Catalog.CardsByID(ctx, ids)
+	if err != nil {
+		cards = make(map[string]models.Card)
+	}
+	missing := missingIDs(ids, cards)
+	if len(missing) == 0 {
+		return cards, nil
+	}
+
+	newCards, err := p.Upstream.getCardsByID(ctx, missing)
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		staleCards, staleErr := p.stale().CardsByID(ctx, missing)
+		if staleErr == nil && len(missingIDs(missing, staleCards)) == 0 {
+			for id, card := range staleCards {
+				cards[id] = card
+			}
+			return cards, nil
+		}
+	}
+	if err != nil {
+		return cards, err
+	}
+	p.Catalog.store(newCards...)
+	for _, card := range newCards {
+		cards[card.ID] = card.Card
+	}
+	return cards, nil
+}
+
+/*
+Function	: Cards by set number
+Description	: Get a list of cards by their set code and collector number from the catalog. The cards that are missing
+or too old are asked to Scryfall in batches.
+
+Self		: CachedProvider
+Parameters 	: context, SetNumber list
+Return     	: SetNumber -> Card map, error
+*/
+func (p CachedProvider) CardsBySetNumber(ctx context.Context, keys []models.SetNumber) (map[models.SetNumber]models.Card, error) {
+	cards, err := p.Catalog.CardsBySetNumber(ctx, keys)
+	if err != nil {
+		cards = make(map[models.SetNumber]models.Card)
+	}
+	var missing []models.SetNumber
+	for _, key := range keys {
+		if _, ok := cards[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return cards, nil
+	}
+
+	newCards, err := p.Upstream.getCardsBySetNumber(ctx, missing)
+	if err != nil {
+		return cards, err
+	}
+	p.Catalog.store(newCards...)
+	for _, card := range newCards {
+		cards[setNumberOf(card.Card)] = card.Card
+	}
+	return cards, nil
+}
+
+/*
+Function	: Card by name
+Description	: Get a card by its exact name from the catalog or, if it is missing or too old, from Scryfall.
+If Scryfall is down, an old card from the catalog is better than nothing.
+
+Self		: CachedProvider
+Parameters 	: context, cardName
+Return     	: Card, error
+*/
+func (p CachedProvider) CardByName(ctx context.Context, name string) (models.Card, error) {
+	card, err := p.Catalog.CardByName(ctx, name)
+	if err == nil {
+		return card, nil
+	}
+	newCard, err := p.Upstream.getCardByName(ctx, name)
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		if card, staleErr := p.stale().CardByName(ctx, name); staleErr == nil {
+			return card, nil
+		}
+	}
+	if err != nil {
+		return newCard.Card, err
+	}
+	p.Catalog.store(newCard)
+	return newCard.Card, nil
+}
+
+/*
+Function	: Autocomplete
+Description	: Get the names that match a partial name. Scryfall knows more cards than the catalog, so it is always asked.
+Self		: CachedProvider
+Parameters 	: context, uncompleted cardName
+Return     	: cardName list, error
+*/
+func (p CachedProvider) Autocomplete(ctx context.Context, partial string) ([]string, error) {
+	return p.Upstream.Autocomplete(ctx, partial)
+}
+
+/*
+Function	: Printings
+Description	: Get all the paper versions of a card from the catalog or, if they were never stored, from Scryfall.
+Self		: CachedProvider
+Parameters 	: context, cardName
+Return     	: CardVersion list, error
+*/
+func (p CachedProvider) Printings(ctx context.Context, name string) ([]models.CardVersion, error) {
+	versions, err := p.Catalog.Printings(ctx, name)
+	if err == nil {
+		return versions, nil
+	}
+	cards, err := p.Upstream.searchPrintings(ctx, name)
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		if versions, staleErr := p.stale().Printings(ctx, name); staleErr == nil {
+			return versions, nil
+		}
+	}
+	if err != nil {
+		return versions, err
+	}
+	p.Catalog.storePrintings(name, cards)
+	return paperVersions(cards), nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Stale
+Description	: Get the catalog without expiration, used when Scryfall can't be reached.
+Self		: CachedProvider
+Parameters 	:
+Return     	: CatalogProvider
+Private
+*/
+func (p CachedProvider) stale() CatalogProvider {
+	return CatalogProvider{}
+}
+
+/*
+Function	: Catalog TTL
+Description	: Get the time a card from the catalog is valid before asking Scryfall again (CATALOG_TTL_HOURS).
+A value of 0 means the catalog never expires.
+
+Parameters 	:
+Return     	: Duration
+Private
+*/
+func catalogTTL() time.Duration {
+	return time.Hour * time.Duration(envInt("CATALOG_TTL_HOURS", defaultCatalogTTLHours))
+}
+
+/*
+Function	: Set number of
+Description	: Get the set code and collector number of a card, in the form used as map key.
+Parameters 	: Card
+Return     	: SetNumber
+Private
+*/
+func setNumberOf(card models.Card) models.SetNumber {
+	return models.SetNumber{Set: strings.ToLower(card.Set), CollectorNumber: card.CollectorNumber}
+}
+
+/*
+Function	: Missing IDs
+Description	: Get the IDs of a list that are not in a card map.
+Parameters 	: cardID list, cardID -> Card map
+Return     	: cardID list
+Private
+*/
+func missingIDs(ids []string, cards map[string]models.Card) []string {
+	var missing []string
+	for _, id := range ids {
+		if _, ok := cards[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+/*
+Function	: Paper versions
+Description	: Convert a list of Scryfall cards to the list of their paper versions.
+Parameters 	: scryfallCard list
+Return     	: CardVersion list
+Private
+*/
+func paperVersions(cards []scryfallCard) []models.CardVersion {
+	var cardVersionsList []models.CardVersion
+	for _, card := range cards {
+		cardVersionsList = append(cardVersionsList, card.toCardVersion())
+	}
+	return models.RemoveDigitalVersions(cardVersionsList)
+}
@@ -0,0 +1,205 @@
+/*
+File		: catalogProvider.go
+Description	: Card provider that only uses the local card catalog of the DB.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Maximum number of names returned by the catalog autocomplete
+const catalogAutocompleteLimit = 20
+
+// Returned when a card is not in the catalog (or it is too old). It is also a ErrCardNotFound.
+var ErrNotInCatalog = fmt.Errorf("%w in the catalog", ErrCardNotFound)
+
+// Card provider that reads the cards from the catalog. A TTL of 0 means the cards never expire.
+type CatalogProvider struct {
+	TTL time.Duration
+}
+
+/*
+Function	: Card by ID
+Description	: Get a card from the catalog by its ID.
+Self		: CatalogProvider
+Parameters 	: context, cardID
+Return     	: Card, error
+*/
+func (p CatalogProvider) CardByID(ctx context.Context, id string) (models.Card, error) {
+	cached, err := models.GetCatalogCardByID(ctx, id)
+	return p.found(cached, err)
+}
+
+/*
+Function	: Cards by ID
+Description	: Get a list of cards from the catalog. The cards that are missing or too old are not in the map.
+Self		: CatalogProvider
+Parameters 	: context, cardID list
+Return     	: cardID -> Card map, error
+*/
+func (p CatalogProvider) CardsByID(ctx context.Context, ids []string) (map[string]models.Card, error) {
+	cards := make(map[string]models.Card)
+	cached, err := models.GetCatalogCardsByID(ctx, ids)
+	if err != nil {
+		return cards, err
+	}
+	for _, card := range cached {
+		if card.IsFresh(p.TTL) {
+			cards[card.ID] = card.ToCard()
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: Cards by set number
+Description	: Get a list of cards from the catalog by their set code and collector number. The cards that are missing or
+too old are not in the map.
+
+Self		: CatalogProvider
+Parameters 	: context, SetNumber list
+Return     	: SetNumber -> Card map, error
+*/
+func (p CatalogProvider) CardsBySetNumber(ctx context.Context, keys []models.SetNumber) (map[models.SetNumber]models.Card, error) {
+	cards := make(map[models.SetNumber]models.Card)
+	cached, err := models.GetCatalogCardsBySetNumber(ctx, keys)
+	if err != nil {
+		return cards, err
+	}
+	for _, card := range cached {
+		if card.IsFresh(p.TTL) {
+			cards[setNumberOf(card.ToCard())] = card.ToCard()
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: Card by name
+Description	: Get a card from the catalog by its exact name.
+Self		: CatalogProvider
+Parameters 	: context, cardName
+Return     	: Card, error
+*/
+func (p CatalogProvider) CardByName(ctx context.Context, name string) (models.Card, error) {
+	cached, err := models.GetCatalogCardByName(ctx, name)
+	return p.found(cached, err)
+}
+
+/*
+Function	: Autocomplete
+Description	: Get the names of the catalog that start with a partial name.
+Self		: CatalogProvider
+Parameters 	: context, uncompleted cardName
+Return     	: cardName list, error
+*/
+func (p CatalogProvider) Autocomplete(ctx context.Context, partial string) ([]string, error) {
+	return models.SearchCatalogNames(ctx, partial, catalogAutocompleteLimit)
+}
+
+/*
+Function	: Printings
+Description	: Get all the paper versions of a card from the catalog.
+Self		: CatalogProvider
+Parameters 	: context, cardName
+Return     	: CardVersion list, error
+*/
+func (p CatalogProvider) Printings(ctx context.Context, name string) ([]models.CardVersion, error) {
+	var cardVersionsList []models.CardVersion
+	cached, found, err := models.GetCatalogPrintings(ctx, name, p.TTL)
+	if err != nil {
+		return cardVersionsList, err
+	}
+	if !found {
+		return cardVersionsList, ErrNotInCatalog
+	}
+	for _, card := range cached {
+		cardVersionsList = append(cardVersionsList, card.ToCardVersion())
+	}
+	return models.RemoveDigitalVersions(cardVersionsList), nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Found
+Description	: Convert the result of a catalog query to the result of the provider.
+Self		: CatalogProvider
+Parameters 	: CatalogCard, query error
+Return     	: Card, error (ErrNotInCatalog if the card is missing or too old)
+Private
+*/
+func (p CatalogProvider) found(cached models.CatalogCard, err error) (models.Card, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Card{}, ErrNotInCatalog
+	}
+	if err != nil {
+		return models.Card{}, err
+	}
+	if !cached.IsFresh(p.TTL) {
+		return models.Card{}, ErrNotInCatalog
+	}
+	return cached.ToCard(), nil
+}
+
+/*
+Function	: Store
+Description	: Store cards recived from Scryfall, and their prices, in the catalog. A failure here is not fatal for
+the request.
+
+Self		: CatalogProvider
+Parameters 	: scryfallCard list
+Return     	:
+Private
+*/
+func (p CatalogProvider) store(cards ...scryfallCard) {
+	var catalogCards []models.CatalogCard
+	var prices []models.CardPrice
+	for _, card := range cards {
+		if card.ID != "" {
+			catalogCards = append(catalogCards, card.toCatalogCard())
+			prices = append(prices, card.toCardPrice())
+		}
+	}
+	if err := models.SaveCatalogCards(catalogCards); err != nil {
+		fmt.Println("Error: ", err)
+	}
+	if err := models.SaveCardPrices(models.DB, prices); err != nil {
+		fmt.Println("Error: ", err)
+	}
+}
+
+/*
+Function	: Store printings
+Description	: Store all the versions of a card recived from Scryfall, and their prices, in the catalog.
+Self		: CatalogProvider
+Parameters 	: cardName, scryfallCard list
+Return     	:
+Private
+*/
+func (p CatalogProvider) storePrintings(name string, cards []scryfallCard) {
+	if len(cards) == 0 {
+		return
+	}
+	var catalogCards []models.CatalogCard
+	var prices []models.CardPrice
+	for _, card := range cards {
+		catalogCards = append(catalogCards, card.toCatalogCard())
+		prices = append(prices, card.toCardPrice())
+	}
+	if err := models.SaveCatalogPrintings(name, catalogCards); err != nil {
+		fmt.Println("Error: ", err)
+	}
+	if err := models.SaveCardPrices(models.DB, prices); err != nil {
+		fmt.Println("Error: ", err)
+	}
+}
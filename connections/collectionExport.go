@@ -0,0 +1,376 @@
+/*
+File		: collectionExport.go
+Description	: File that deals with the export of collections, as a backup or to move them to other collection managers.
+The collection is read from the DB in batches and every batch is written as soon as its cards are resolved, so big
+collections are streamed instead of built in memory. Nothing is written until the first batch is resolved, so most of
+the errors can still be sent as an error response. The CSV and text exports can be imported again with
+ImportCollection.
+*/
+
+package connections
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Number of cardOwnerships read from the DB and resolved at once
+const exportBatchSize = 500
+
+// Writes the cards of a collection in a format
+type collectionWriter interface {
+	writeCard(card models.Card) error
+	close() error
+}
+
+// Export format: file extension, content type and writer builder
+type ExportFormat struct {
+	Extension   string
+	ContentType string
+	newWriter   func(w io.Writer) (collectionWriter, error)
+}
+
+// Supported export formats
+var exportFormats = map[string]ExportFormat{
+	"csv":      {"csv", "text/csv", newCSVWriter(cardaliaCSVColumns)},
+	"moxfield": {"csv", "text/csv", newCSVWriter(moxfieldCSVColumns)},
+	"deckbox":  {"csv", "text/csv", newCSVWriter(deckboxCSVColumns)},
+	"json":     {"json", "application/json", newJSONWriter},
+	"txt":      {"txt", "text/plain", newTextWriter},
+}
+
+// Card of a JSON export
+type exportedCard struct {
+	Count           int    `json:"count"`
+	Name            string `json:"name"`
+	Set             string `json:"set"`
+	SetName         string `json:"set_name"`
+	CollectorNumber string `json:"collector_number"`
+	VersionID       string `json:"version_id"`
+	OracleID        string `json:"oracle_id"`
+	Extras          string `json:"extras"`
+	Condi           string `json:"condi"`
+}
+
+// Columns of a CSV export: header and the values of a card
+type csvColumns struct {
+	header []string
+	values func(card models.Card) []string
+}
+
+// CSV format of the API. Imported with format "csv".
+var cardaliaCSVColumns = csvColumns{
+	header: []string{"Count", "Name", "Set code", "Set name", "Collector number", "Foil", "Condition", "Scryfall ID"},
+	values: func(card models.Card) []string {
+		return []string{strconv.Itoa(card.Count), card.Name, card.Set, card.SetName, card.CollectorNumber,
+			foilValue(card.Extras), card.Condi, card.VersionID}
+	},
+}
+
+// Moxfield collection CSV
+var moxfieldCSVColumns = csvColumns{
+	header: []string{"Count", "Tradelist Count", "Name", "Edition", "Condition", "Language", "Foil", "Collector Number"},
+	values: func(card models.Card) []string {
+		return []string{strconv.Itoa(card.Count), "0", card.Name, card.Set, moxfieldConditions[card.Condi], "English",
+			foilValue(card.Extras), card.CollectorNumber}
+	},
+}
+
+// Deckbox inventory CSV
+var deckboxCSVColumns = csvColumns{
+	header: []string{"Count", "Tradelist Count", "Name", "Edition", "Edition Code", "Card Number", "Condition", "Language", "Foil"},
+	values: func(card models.Card) []string {
+		return []string{strconv.Itoa(card.Count), "0", card.Name, card.SetName, card.Set, card.CollectorNumber,
+			deckboxConditions[card.Condi], "English", foilValue(card.Extras)}
+	},
+}
+
+// Condition names of Moxfield for every grade of Conditions
+var moxfieldConditions = map[string]string{
+	"M": "Mint", "NM": "Near Mint", "EX": "Lightly Played", "GD": "Lightly Played", "LP": "Lightly Played",
+	"PL": "Moderately Played", "PO": "Damaged",
+}
+
+// Condition names of Deckbox for every grade of Conditions
+var deckboxConditions = map[string]string{
+	"M": "Mint", "NM": "Near Mint", "EX": "Good (Lightly Played)", "GD": "Good (Lightly Played)",
+	"LP": "Good (Lightly Played)", "PL": "Played", "PO": "Poor",
+}
+
+/*
+Function	: Get export format
+Description	: Get an export format by its name.
+Parameters 	: format (csv, json, txt, moxfield, deckbox)
+Return     	: ExportFormat, error (ErrUnknownFormat)
+*/
+func GetExportFormat(format string) (ExportFormat, error) {
+	format = strings.ToLower(format)
+	if format == TextFormat {
+		format = "txt"
+	}
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		return exportFormat, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	return exportFormat, nil
+}
+
+/*
+Function	: Export collection
+Description	: Write the collection of a user in an export format. The cards that can't be resolved are written with
+their version ID only, so the export is still a complete backup. The start function is called once, when the first
+batch of cards is resolved and just before anything is written (to send the headers of a response).
+
+Parameters 	: context, userID, ExportFormat, writer, start function
+Return     	: error
+*/
+func ExportCollection(ctx context.Context, userID uint, format ExportFormat, w io.Writer, start func()) error {
+	var writer collectionWriter
+	open := func() error {
+		start()
+		var err error
+		writer, err = format.newWriter(w)
+		return err
+	}
+
+	var cardOwnerships []models.CardOwnership
+	err := models.DB.WithContext(ctx).Where("user_id = ? AND count != ?", userID, 0).Order("card_id").
+		FindInBatches(&cardOwnerships, exportBatchSize, func(tx *gorm.DB, batch int) error {
+			cards, err := resolveExportCards(ctx, cardOwnerships)
+			if err != nil {
+				return err
+			}
+			if writer == nil {
+				if err := open(); err != nil {
+					return err
+				}
+			}
+			for _, card := range cards {
+				if err := writer.writeCard(card); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+	// Empty collection: only the header (or the empty array)
+	if writer == nil {
+		if err := open(); err != nil {
+			return err
+		}
+	}
+	return writer.close()
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Resolve export cards
+Description	: Resolve the cards of a batch of cardOwnerships to export them.
+Parameters 	: context, CardOwnership list
+Return     	: Card list (same order), error
+Private
+*/
+func resolveExportCards(ctx context.Context, cardOwnerships []models.CardOwnership) ([]models.Card, error) {
+	var versionIDs []string
+	for _, cardDB := range cardOwnerships {
+		versionIDs = append(versionIDs, cardDB.VersionID)
+	}
+	resolved, err := Cards.CardsByID(ctx, versionIDs)
+	if err != nil {
+		return nil, err
+	}
+	cards := make([]models.Card, 0, len(cardOwnerships))
+	for _, cardDB := range cardOwnerships {
+		card := resolved[cardDB.VersionID]
+		card.VersionID = cardDB.VersionID
+		card.OracleID = cardDB.OracleID
+		card.Count = int(cardDB.Count)
+		card.Extras = cardDB.Extras
+		card.Condi = cardDB.Condi
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+/*
+Function	: Foil value
+Description	: Value of the foil column of a CSV export.
+Parameters 	: extras
+Return     	: "foil" or ""
+Private
+*/
+func foilValue(extras string) string {
+	if models.IsFoil(extras) {
+		return models.ExtrasFoil
+	}
+	return ""
+}
+
+// CSV export writer
+type csvWriter struct {
+	writer  *csv.Writer
+	columns csvColumns
+}
+
+/*
+Function	: New CSV writer
+Description	: Get the builder of a CSV writer with some columns. The header is written when the writer is built.
+Parameters 	: csvColumns
+Return     	: collectionWriter builder
+Private
+*/
+func newCSVWriter(columns csvColumns) func(w io.Writer) (collectionWriter, error) {
+	return func(w io.Writer) (collectionWriter, error) {
+		writer := &csvWriter{writer: csv.NewWriter(w), columns: columns}
+		return writer, writer.writer.Write(columns.header)
+	}
+}
+
+/*
+Function	: Write card
+Description	: Write a card as a CSV row.
+Self		: csvWriter
+Parameters 	: Card
+Return     	: error
+Private
+*/
+func (w *csvWriter) writeCard(card models.Card) error {
+	return w.writer.Write(w.columns.values(card))
+}
+
+/*
+Function	: Close
+Description	: Send the buffered rows.
+Self		: csvWriter
+Parameters 	:
+Return     	: error
+Private
+*/
+func (w *csvWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// JSON export writer. Writes a JSON array one card at a time.
+type jsonWriter struct {
+	w     io.Writer
+	first bool
+}
+
+/*
+Function	: New JSON writer
+Description	: Build a JSON writer and open the array.
+Parameters 	: writer
+Return     	: collectionWriter, error
+Private
+*/
+func newJSONWriter(w io.Writer) (collectionWriter, error) {
+	_, err := io.WriteString(w, "[")
+	return &jsonWriter{w: w, first: true}, err
+}
+
+/*
+Function	: Write card
+Description	: Write a card as an element of the array.
+Self		: jsonWriter
+Parameters 	: Card
+Return     	: error
+Private
+*/
+func (w *jsonWriter) writeCard(card models.Card) error {
+	cardBytes, err := json.Marshal(exportedCard{
+		Count:           card.Count,
+		Name:            card.Name,
+		Set:             card.Set,
+		SetName:         card.SetName,
+		CollectorNumber: card.CollectorNumber,
+		VersionID:       card.VersionID,
+		OracleID:        card.OracleID,
+		Extras:          card.Extras,
+		Condi:           card.Condi,
+	})
+	if err != nil {
+		return err
+	}
+	if !w.first {
+		cardBytes = append([]byte(",\n"), cardBytes...)
+	}
+	w.first = false
+	_, err = w.w.Write(cardBytes)
+	return err
+}
+
+/*
+Function	: Close
+Description	: Close the array.
+Self		: jsonWriter
+Parameters 	:
+Return     	: error
+Private
+*/
+func (w *jsonWriter) close() error {
+	_, err := io.WriteString(w.w, "]\n")
+	return err
+}
+
+// Text export writer. Writes lines like "4 Lightning Bolt (M10) 146 *F*".
+type textWriter struct {
+	w io.Writer
+}
+
+/*
+Function	: New text writer
+Description	: Build a text writer.
+Parameters 	: writer
+Return     	: collectionWriter, error
+Private
+*/
+func newTextWriter(w io.Writer) (collectionWriter, error) {
+	return &textWriter{w: w}, nil
+}
+
+/*
+Function	: Write card
+Description	: Write a card as a line of a text list.
+Self		: textWriter
+Parameters 	: Card
+Return     	: error
+Private
+*/
+func (w *textWriter) writeCard(card models.Card) error {
+	if card.Name == "" {
+		// Not resolved: keep it as a comment, so the export is complete
+		_, err := fmt.Fprintf(w.w, "// %d %s\n", card.Count, card.VersionID)
+		return err
+	}
+	line := fmt.Sprintf("%d %s (%s) %s", card.Count, card.Name, strings.ToUpper(card.Set), card.CollectorNumber)
+	if models.IsFoil(card.Extras) {
+		line += " *F*"
+	}
+	_, err := fmt.Fprintln(w.w, line)
+	return err
+}
+
+/*
+Function	: Close
+Description	: Nothing to do, the lines are not buffered.
+Self		: textWriter
+Parameters 	:
+Return     	: error
+Private
+*/
+func (w *textWriter) close() error {
+	return nil
+}
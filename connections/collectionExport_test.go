@@ -0,0 +1,36 @@
+/*
+File		: collectionExport_test.go
+Description	: Tests of the exports of the collections.
+*/
+
+package connections
+
+import (
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestExportedConditionsImportBack(t *testing.T) {
+	tests := []struct {
+		format     string
+		conditions map[string]string
+	}{
+		{"moxfield", moxfieldConditions},
+		{"deckbox", deckboxConditions},
+	}
+	for _, test := range tests {
+		for _, condition := range models.Conditions {
+			name, ok := test.conditions[condition]
+			if !ok {
+				t.Errorf("%s: no name for %s", test.format, condition)
+				continue
+			}
+			// Imported back as the same grade or a worse one, never a better one
+			imported := models.NormalizeCondition(name)
+			if imported == "" || models.ConditionRank(imported) < models.ConditionRank(condition) {
+				t.Errorf("%s: %s is exported as %q and imported as %q", test.format, condition, name, imported)
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+/*
+File		: collectionImport.go
+Description	: File that deals with the import of collections exported by other collection managers (Moxfield, Deckbox,
+ManaBox, Delver Lens and TCGplayer). Every row is resolved to a card version and added to the user's collection.
+*/
+
+package connections
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Columns of a CSV format. Every field has the names the column can have in the header.
+type csvFormat struct {
+	count      []string
+	scryfallID []string
+	name       []string
+	setCode    []string
+	setName    []string
+	number     []string
+	foil       []string
+	condition  []string
+}
+
+// Supported CSV formats
+var csvFormats = map[string]csvFormat{
+	"csv": {
+		count:      []string{"Count"},
+		scryfallID: []string{"Scryfall ID"},
+		name:       []string{"Name"},
+		setCode:    []string{"Set code"},
+		setName:    []string{"Set name"},
+		number:     []string{"Collector number"},
+		foil:       []string{"Foil"},
+		condition:  []string{"Condition"},
+	},
+	"moxfield": {
+		count:     []string{"Count"},
+		name:      []string{"Name"},
+		setCode:   []string{"Edition"},
+		number:    []string{"Collector Number"},
+		foil:      []string{"Foil"},
+		condition: []string{"Condition"},
+	},
+	"deckbox": {
+		count:     []string{"Count"},
+		name:      []string{"Name"},
+		setCode:   []string{"Edition Code"},
+		setName:   []string{"Edition"},
+		number:    []string{"Card Number"},
+		foil:      []string{"Foil"},
+		condition: []string{"Condition"},
+	},
+	"manabox": {
+		count:      []string{"Quantity"},
+		scryfallID: []string{"Scryfall ID"},
+		name:       []string{"Name"},
+		setCode:    []string{"Set code"},
+		setName:    []string{"Set name"},
+		number:     []string{"Collector number"},
+		foil:       []string{"Foil"},
+		condition:  []string{"Condition"},
+	},
+	"delverlens": {
+		count:      []string{"Quantity", "QuantityX", "Count"},
+		scryfallID: []string{"Scryfall ID"},
+		name:       []string{"Name"},
+		setCode:    []string{"Edition code", "Set code"},
+		setName:    []string{"Edition", "Set"},
+		number:     []string{"Collector's number", "Collector number", "Number"},
+		foil:       []string{"Foil"},
+		condition:  []string{"Condition"},
+	},
+	"tcgplayer": {
+		count:     []string{"Quantity"},
+		name:      []string{"Simple Name", "Name"},
+		setCode:   []string{"Set Code"},
+		setName:   []string{"Set"},
+		number:    []string{"Card Number"},
+		foil:      []string{"Printing"},
+		condition: []string{"Condition"},
+	},
+}
+
+// Returned when the format of an import is not supported
+var ErrUnknownFormat = errors.New("unknown import format")
+
+// A row of an import before resolving its card
+type importRow struct {
+	line       int
+	raw        string
+	count      uint
+	scryfallID string
+	name       string
+	setCode    string
+	setName    string
+	number     string
+	extras     string
+	condi      string
+}
+
+/*
+Function	: Import collection CSV
+Description	: Add the cards of a CSV exported by another collection manager to the user's collection. The counts are
+added to the cards the user already has. The rows that can't be read or resolved are returned in the report.
+
+Parameters 	: context, userID, expected collection version (nil to skip the check), CSV reader,
+format (csv, moxfield, deckbox, manabox, delverlens, tcgplayer)
+
+Return     	: ImportReport, error
+*/
+func ImportCollectionCSV(ctx context.Context, userID uint, ifMatch *uint, r io.Reader, format string) (models.ImportReport, error) {
+	report := models.ImportReport{Unresolved: []models.ImportIssue{}}
+	columns, ok := csvFormats[strings.ToLower(format)]
+	if !ok {
+		return report, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	rows, err := parseCSVRows(r, columns, &report)
+	if err != nil {
+		return report, err
+	}
+	return report, importRows(ctx, userID, ifMatch, rows, &report)
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Parse CSV rows
+Description	: Read the rows of a CSV with the columns of a format. The rows with wrong values are added to the report.
+Parameters 	: CSV reader, csvFormat, ImportReport
+Return     	: importRow list, error (if the file is not a valid CSV)
+Private
+*/
+func parseCSVRows(r io.Reader, columns csvFormat, report *models.ImportReport) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	// Get the value of the first column of the list that is in the header
+	column := func(record []string, names []string) string {
+		for _, name := range names {
+			if i, ok := index[strings.ToLower(name)]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	var rows []importRow
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.Join(record, ",")
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		row := importRow{
+			line:       line,
+			raw:        raw,
+			scryfallID: strings.ToLower(column(record, columns.scryfallID)),
+			name:       column(record, columns.name),
+			setCode:    strings.ToLower(column(record, columns.setCode)),
+			setName:    column(record, columns.setName),
+			number:     column(record, columns.number),
+		}
+		row.count, err = parseCount(column(record, columns.count))
+		if err != nil {
+			report.AddIssue(line, raw, err.Error())
+			continue
+		}
+		foil := column(record, columns.foil)
+		condition := column(record, columns.condition)
+		if isFoilValue(foil) || isFoilValue(condition) {
+			row.extras = models.ExtrasFoil
+		}
+		row.condi, err = parseCondition(condition)
+		if err != nil {
+			report.AddIssue(line, raw, err.Error())
+			continue
+		}
+		if row.scryfallID == "" && row.name == "" && (row.setCode == "" || row.number == "") {
+			report.AddIssue(line, raw, "the row has no card name, Scryfall ID or set and collector number")
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+/*
+Function	: Import rows
+Description	: Resolve the card of every row and add all the resolved rows to the user's collection in one transaction.
+Rows with the same version, extras and condition are merged. The new version of the collection is set in the report.
+
+Parameters 	: context, userID, expected collection version (nil to skip the check), importRow list, ImportReport
+Return     	: error
+Private
+*/
+func importRows(ctx context.Context, userID uint, ifMatch *uint, rows []importRow, report *models.ImportReport) error {
+	cards, err := resolveRows(ctx, rows, report)
+	if err != nil {
+		return err
+	}
+
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if report.Version, err = bumpCollectionVersion(tx, userID, ifMatch); err != nil {
+			return err
+		}
+		for i, row := range rows {
+			card, ok := cards[i]
+			if !ok {
+				continue
+			}
+			cardOwnership := models.CardOwnership{
+				User_id:   userID,
+				VersionID: card.ID,
+				OracleID:  card.OracleID,
+				Count:     row.count,
+				Extras:    row.extras,
+				Condi:     row.condi,
+			}
+			merged, err := cardOwnership.AddCard(tx)
+			if err != nil {
+				return err
+			}
+			if merged {
+				report.Merged++
+			} else {
+				report.Imported++
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Function	: Resolve rows
+Description	: Get the card version of every row. The rows with a Scryfall ID or a set and collector number are resolved in
+batches; the others (or the ones not found) are searched by name and set. The rows not found are added to the report
+with the names of the cards they could be.
+
+Parameters 	: context, importRow list, ImportReport
+Return     	: row index -> Card map, error
+Private
+*/
+func resolveRows(ctx context.Context, rows []importRow, report *models.ImportReport) (map[int]models.Card, error) {
+	resolved := make(map[int]models.Card)
+
+	// Batch lookups
+	var ids []string
+	var keys []models.SetNumber
+	for _, row := range rows {
+		if row.scryfallID != "" {
+			ids = append(ids, row.scryfallID)
+		} else if row.setCode != "" && row.number != "" {
+			keys = append(keys, models.SetNumber{Set: row.setCode, CollectorNumber: row.number})
+		}
+	}
+	byID := map[string]models.Card{}
+	bySetNumber := map[models.SetNumber]models.Card{}
+	var err error
+	if len(ids) > 0 {
+		if byID, err = Cards.CardsByID(ctx, ids); err != nil {
+			return resolved, err
+		}
+	}
+	if len(keys) > 0 {
+		if bySetNumber, err = Cards.CardsBySetNumber(ctx, keys); err != nil {
+			return resolved, err
+		}
+	}
+
+	for i, row := range rows {
+		if card, ok := byID[row.scryfallID]; ok && row.scryfallID != "" {
+			resolved[i] = card
+			continue
+		}
+		if card, ok := bySetNumber[models.SetNumber{Set: row.setCode, CollectorNumber: row.number}]; ok {
+			resolved[i] = card
+			continue
+		}
+		if row.name == "" {
+			report.AddIssue(row.line, row.raw, "card not found")
+			continue
+		}
+		card, err := resolveByName(ctx, row.name, row.setCode, row.setName, row.number)
+		if errors.Is(err, ErrCardNotFound) {
+			report.AddIssue(row.line, row.raw, err.Error())
+			report.Unresolved[len(report.Unresolved)-1].Suggestions = suggestions(ctx, row.name)
+			continue
+		}
+		if err != nil {
+			return resolved, err
+		}
+		resolved[i] = card
+	}
+	return resolved, nil
+}
+
+/*
+Function	: Resolve by name
+Description	: Get a card by its name. If a set (code or name) is given, the version of that set is chosen, and if a
+collector number is also given, the version with that number.
+
+Parameters 	: context, cardName, set code, set name, collector number
+Return     	: Card, error (ErrCardNotFound if there is no such version)
+Private
+*/
+func resolveByName(ctx context.Context, name string, setCode string, setName string, number string) (models.Card, error) {
+	card, err := Cards.CardByName(ctx, name)
+	if err != nil || (setCode == "" && setName == "") {
+		return card, err
+	}
+	if matchesVersion(card.Set, card.SetName, card.CollectorNumber, setCode, setName, number) {
+		return card, nil
+	}
+
+	// Look for the version of the set
+	versions, err := Cards.Printings(ctx, card.Name)
+	if err != nil {
+		return card, err
+	}
+	for _, version := range versions {
+		if matchesVersion(version.Set, version.SetName, version.CollectorNumber, setCode, setName, number) {
+			return Cards.CardByID(ctx, version.Id)
+		}
+	}
+	return card, fmt.Errorf("%w: %s is not in set %s%s", ErrCardNotFound, card.Name, setCode, setName)
+}
+
+/*
+Function	: Matches version
+Description	: Check if a card version is from a set (by code or name) and, if given, has a collector number.
+Parameters 	: version set, version set name, version number, set code, set name, collector number
+Return     	: bool
+Private
+*/
+func matchesVersion(versionSet string, versionSetName string, versionNumber string, setCode string, setName string, number string) bool {
+	if setCode != "" && !strings.EqualFold(versionSet, setCode) {
+		return false
+	}
+	if setCode == "" && setName != "" && !strings.EqualFold(versionSetName, setName) {
+		return false
+	}
+	return number == "" || strings.EqualFold(versionNumber, number)
+}
+
+/*
+Function	: Parse count
+Description	: Read the number of copies of a row. An empty count is one copy. Counts like "4x" are accepted.
+Parameters 	: count
+Return     	: count, error
+Private
+*/
+func parseCount(value string) (uint, error) {
+	value = strings.Trim(strings.ToLower(value), "x ")
+	if value == "" {
+		return 1, nil
+	}
+	count, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || count == 0 {
+		return 0, fmt.Errorf("invalid count %q", value)
+	}
+	return uint(count), nil
+}
+
+/*
+Function	: Parse condition
+Description	: Read the condition of a row. An empty condition is Near Mint.
+Parameters 	: condition
+Return     	: condition, error
+Private
+*/
+func parseCondition(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "NM", nil
+	}
+	condi := models.NormalizeCondition(value)
+	if condi == "" {
+		return "", fmt.Errorf("unknown condition %q", value)
+	}
+	return condi, nil
+}
+
+/*
+Function	: Is foil value
+Description	: Check if the value of a foil (or printing) column marks the card as foil.
+Parameters 	: value
+Return     	: bool
+Private
+*/
+func isFoilValue(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "foil", "etched", "true", "yes", "1":
+		return true
+	}
+	return strings.HasSuffix(value, " foil")
+}
@@ -0,0 +1,50 @@
+/*
+File		: collectionImport_test.go
+Description	: Tests of the imports of CSV exports into the collections.
+*/
+
+package connections
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestImportCollectionCSVMerges(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	user, _ := createTestUser(t, "alice",
+		models.CardOwnership{VersionID: boltM10, OracleID: "bolt", Count: 2, Condi: "NM"},
+		models.CardOwnership{VersionID: solRing, OracleID: "ring", Count: 1, Condi: "NM"},
+	)
+	moxfield := `Count,Tradelist Count,Name,Edition,Condition,Language,Foil,Collector Number
+3,0,Lightning Bolt,m10,Near Mint,English,,146
+1,0,Lightning Bolt,m10,Near Mint,English,foil,146
+2,0,Sol Ring,c21,Lightly Played,English,,263
+1,0,Sol Ring,c21,LP,English,,263
+1,0,Black Lotus,lea,Near Mint,English,,232
+`
+	report, err := ImportCollectionCSV(context.Background(), user.User_id, nil, strings.NewReader(moxfield), "moxfield")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 || report.Merged != 2 || len(report.Unresolved) != 1 || report.Version != 1 {
+		t.Errorf("report = %+v, want 2 imported, 2 merged, 1 unresolved and version 1", report)
+	}
+	if len(report.Unresolved) == 1 && report.Unresolved[0].Line != 6 {
+		t.Errorf("unresolved line %d, want 6", report.Unresolved[0].Line)
+	}
+	want := map[string]uint{
+		boltM10 + "//NM":     5, // Added to the copies the user had
+		boltM10 + "/foil/NM": 1,
+		solRing + "//NM":     1,
+		solRing + "//LP":     3, // Both spellings of the condition in one card
+	}
+	if got := testCollection(t, user.User_id); !reflect.DeepEqual(got, want) {
+		t.Errorf("collection = %v, want %v", got, want)
+	}
+}
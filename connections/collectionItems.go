@@ -0,0 +1,314 @@
+/*
+File		: collectionItems.go
+Description	: File that deals with the edition of single cards of a collection, so the clients don't have to send the
+whole collection (SaveUserCollectionDB) to change one of them.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Returned when a cardOwnership doesn't exist or it is from another user. It is also a ErrCardNotFound.
+var ErrNotInCollection = fmt.Errorf("%w in the collection", ErrCardNotFound)
+
+/*
+Function	: Add collection item
+Description	: Add copies of a card version to the user's collection. If the user already has the card with the same
+extras and condition, the copies are added to it.
+
+Parameters 	: context, userID, expected collection version (nil to skip the check), CardOwnership {version_id, extras, condi, count}
+Return     	: CardOwnership, new collection version, error
+*/
+func AddCollectionItemDB(ctx context.Context, userID uint, ifMatch *uint, cardOwnership models.CardOwnership) (models.CardOwnership, uint, error) {
+	operations := []models.CollectionOperation{{
+		Op:        "add",
+		VersionID: cardOwnership.VersionID,
+		Extras:    cardOwnership.Extras,
+		Condi:     cardOwnership.Condi,
+		Count:     int(cardOwnership.Count),
+	}}
+	result, version, err := PatchCollectionDB(ctx, userID, ifMatch, operations)
+	if err != nil {
+		return cardOwnership, version, err
+	}
+	return result[0], version, nil
+}
+
+/*
+Function	: Modify collection item
+Description	: Change the count, extras or condition of a card of the user's collection. If the new extras and condition
+are the ones of another card of the user with the same version, both cards are merged.
+
+Parameters 	: context, userID, CardID, expected collection version (nil to skip the check), CardOwnershipUpdate
+Return     	: CardOwnership, new collection version, error
+*/
+func ModifyCollectionItemDB(ctx context.Context, userID uint, cardID uint, ifMatch *uint, update models.CardOwnershipUpdate) (models.CardOwnership, uint, error) {
+	var cardOwnership models.CardOwnership
+	var version uint
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if version, err = bumpCollectionVersion(tx, userID, ifMatch); err != nil {
+			return err
+		}
+		cardOwnership, err = modifyItem(tx, userID, cardID, update)
+		return err
+	})
+	return cardOwnership, version, err
+}
+
+/*
+Function	: Delete collection item
+Description	: Remove a card from the user's collection.
+Parameters 	: context, userID, CardID, expected collection version (nil to skip the check)
+Return     	: new collection version, error
+*/
+func DeleteCollectionItemDB(ctx context.Context, userID uint, cardID uint, ifMatch *uint) (uint, error) {
+	var version uint
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if version, err = bumpCollectionVersion(tx, userID, ifMatch); err != nil {
+			return err
+		}
+		_, err = removeItem(tx, userID, cardID)
+		return err
+	})
+	return version, err
+}
+
+/*
+Function	: Patch collection
+Description	: Apply a list of operations (add, remove, adjust) to the user's collection in a single transaction.
+If an operation fails, none of them is applied.
+
+Parameters 	: context, userID, expected collection version (nil to skip the check), CollectionOperation list
+Return     	: CardOwnership list (the card of every operation, in the same order), new collection version, error
+*/
+func PatchCollectionDB(ctx context.Context, userID uint, ifMatch *uint, operations []models.CollectionOperation) ([]models.CardOwnership, uint, error) {
+	result := make([]models.CardOwnership, len(operations))
+	var version uint
+
+	// Resolve the versions of the added cards before opening the transaction
+	var versionIDs []string
+	for _, operation := range operations {
+		if operation.Op == "add" && operation.VersionID != "" {
+			versionIDs = append(versionIDs, operation.VersionID)
+		}
+	}
+	versions := map[string]models.Card{}
+	if len(versionIDs) > 0 {
+		var err error
+		if versions, err = Cards.CardsByID(ctx, versionIDs); err != nil {
+			return result, version, err
+		}
+	}
+
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if version, err = bumpCollectionVersion(tx, userID, ifMatch); err != nil {
+			return err
+		}
+		for i, operation := range operations {
+			var err error
+			switch operation.Op {
+			case "add":
+				result[i], err = addItem(tx, userID, operation, versions)
+			case "remove":
+				result[i], err = removeItem(tx, userID, operation.CardID)
+			case "adjust":
+				result[i], err = adjustItem(tx, userID, operation.CardID, operation.Count)
+			default:
+				err = fmt.Errorf("unknown operation %q", operation.Op)
+			}
+			if err != nil {
+				return fmt.Errorf("operation %d (%s): %w", i, operation.Op, err)
+			}
+		}
+		return nil
+	})
+	return result, version, err
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Get user item
+Description	: Get a cardOwnership of a user, locking it until the end of the transaction.
+Parameters 	: DB transaction, userID, CardID
+Return     	: CardOwnership, error (ErrNotInCollection if the card is not of the user)
+Private
+*/
+func getUserItem(tx *gorm.DB, userID uint, cardID uint) (models.CardOwnership, error) {
+	var cardOwnership models.CardOwnership
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("card_id = ? AND user_id = ? AND count != ?", cardID, userID, 0).First(&cardOwnership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cardOwnership, fmt.Errorf("%w: %d", ErrNotInCollection, cardID)
+	}
+	return cardOwnership, err
+}
+
+/*
+Function	: Add item
+Description	: Add the copies of an add operation to the user's collection.
+Parameters 	: DB transaction, userID, CollectionOperation, versionID -> Card map of the added versions
+Return     	: CardOwnership, error
+Private
+*/
+func addItem(tx *gorm.DB, userID uint, operation models.CollectionOperation, versions map[string]models.Card) (models.CardOwnership, error) {
+	cardOwnership := models.CardOwnership{
+		User_id:   userID,
+		VersionID: operation.VersionID,
+		Extras:    operation.Extras,
+		Condi:     operation.Condi,
+	}
+	if operation.VersionID == "" {
+		return cardOwnership, errors.New("version_id is required")
+	}
+	if operation.Count <= 0 {
+		return cardOwnership, errors.New("count must be greater than 0")
+	}
+	card, ok := versions[operation.VersionID]
+	if !ok {
+		return cardOwnership, fmt.Errorf("%w: %s", ErrCardNotFound, operation.VersionID)
+	}
+	cardOwnership.OracleID = card.OracleID
+	cardOwnership.Count = uint(operation.Count)
+	_, err := cardOwnership.AddCard(tx)
+	return cardOwnership, err
+}
+
+/*
+Function	: Adjust item
+Description	: Add (or remove, if the change is negative) copies of a card of the user's collection. If no copies are
+left, the card is removed.
+
+Parameters 	: DB transaction, userID, CardID, change of the count
+Return     	: CardOwnership, error
+Private
+*/
+func adjustItem(tx *gorm.DB, userID uint, cardID uint, change int) (models.CardOwnership, error) {
+	cardOwnership, err := getUserItem(tx, userID, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	count := int(cardOwnership.Count) + change
+	if count < 0 {
+		return cardOwnership, fmt.Errorf("the user only has %d copies of card %d", cardOwnership.Count, cardID)
+	}
+	if count == 0 {
+		return removeItem(tx, userID, cardID)
+	}
+	cardOwnership.Count = uint(count)
+	reserved, err := reservedCopies(tx, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	if err := checkAvailable(cardOwnership, reserved); err != nil {
+		return cardOwnership, err
+	}
+	return cardOwnership, tx.Model(&cardOwnership).Update("count", cardOwnership.Count).Error
+}
+
+/*
+Function	: Modify item
+Description	: Change the count, extras or condition of a card of the user's collection, merging it with another card if
+they end up with the same version, extras and condition. The extras and condition of a card reserved by trades can't be
+changed, because the trades offer that card.
+
+Parameters 	: DB transaction, userID, CardID, CardOwnershipUpdate
+Return     	: CardOwnership, error
+Private
+*/
+func modifyItem(tx *gorm.DB, userID uint, cardID uint, update models.CardOwnershipUpdate) (models.CardOwnership, error) {
+	cardOwnership, err := getUserItem(tx, userID, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	if update.Count != nil {
+		if *update.Count == 0 {
+			return removeItem(tx, userID, cardID)
+		}
+		cardOwnership.Count = *update.Count
+	}
+	reserved, err := reservedCopies(tx, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	if err := checkAvailable(cardOwnership, reserved); err != nil {
+		return cardOwnership, err
+	}
+	if reserved > 0 && ((update.Extras != nil && *update.Extras != cardOwnership.Extras) ||
+		(update.Condi != nil && *update.Condi != cardOwnership.Condi)) {
+		return cardOwnership, fmt.Errorf("%w: card %d is reserved by trades", ErrNotAvailable, cardID)
+	}
+	if update.Extras != nil {
+		cardOwnership.Extras = *update.Extras
+	}
+	if update.Condi != nil {
+		cardOwnership.Condi = *update.Condi
+	}
+
+	// Merge with the card that already has the new extras and condition
+	var existingCard models.CardOwnership
+	err = tx.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ? AND card_id != ?",
+		userID, cardOwnership.VersionID, cardOwnership.Extras, cardOwnership.Condi, cardID).First(&existingCard).Error
+	if err == nil {
+		existingCard.Count += cardOwnership.Count
+		if err := tx.Save(&existingCard).Error; err != nil {
+			return existingCard, err
+		}
+		if _, err := removeItem(tx, userID, cardID); err != nil {
+			return existingCard, err
+		}
+		return existingCard, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return cardOwnership, err
+	}
+	return cardOwnership, tx.Save(&cardOwnership).Error
+}
+
+/*
+Function	: Remove item
+Description	: Remove a card from the user's collection. If the card is in a trade (or one of its revisions) it is kept
+with count 0 (like the traded cards), so the trade doesn't lose it. A card reserved by trades can't be removed.
+
+Parameters 	: DB transaction, userID, CardID
+Return     	: CardOwnership (with count 0), error
+Private
+*/
+func removeItem(tx *gorm.DB, userID uint, cardID uint) (models.CardOwnership, error) {
+	cardOwnership, err := getUserItem(tx, userID, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	reserved, err := reservedCopies(tx, cardID)
+	if err != nil {
+		return cardOwnership, err
+	}
+	cardOwnership.Count = 0
+	if err := checkAvailable(cardOwnership, reserved); err != nil {
+		return cardOwnership, err
+	}
+
+	var trades, revisions int64
+	if err := tx.Model(&models.TradeItem{}).Where("card_id = ?", cardID).Count(&trades).Error; err != nil {
+		return cardOwnership, err
+	}
+	if err := tx.Model(&models.TradeRevisionItem{}).Where("card_id = ?", cardID).Count(&revisions).Error; err != nil {
+		return cardOwnership, err
+	}
+	if trades > 0 || revisions > 0 {
+		return cardOwnership, tx.Model(&cardOwnership).Update("count", 0).Error
+	}
+	return cardOwnership, tx.Delete(&cardOwnership).Error
+}
@@ -0,0 +1,61 @@
+/*
+File		: collectionVersion.go
+Description	: File that deals with the version of the collections. Every write of a collection increases its version,
+and a write can ask to be done only if the collection is still in the version the client knows (If-Match), so two
+clients editing the same collection don't overwrite each other's changes.
+*/
+
+package connections
+
+import (
+	"errors"
+	"fmt"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Returned when a write expects a version of the collection that is not the current one
+var ErrStaleCollection = errors.New("the collection was changed by another request")
+
+// Error of a write with a stale version. Has the current version of the collection. It is also a ErrStaleCollection.
+type CollectionConflictError struct {
+	Current uint
+}
+
+func (e CollectionConflictError) Error() string {
+	return fmt.Sprintf("%v (current version %d)", ErrStaleCollection, e.Current)
+}
+
+func (e CollectionConflictError) Unwrap() error {
+	return ErrStaleCollection
+}
+
+// Data of the events of the collections
+type collectionEvent struct {
+	Version uint `json:"version"` // New version of the collection
+	Cards   int  `json:"cards"`   // Number of cards of the collection
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Bump collection version
+Description	: Check the version of the collection of a user and increase it. Must be the first thing done by a
+transaction that writes the collection, because it locks the collection until the transaction ends.
+
+Parameters 	: DB transaction, userID, expected version (nil to skip the check)
+Return     	: new version, error (CollectionConflictError if the version is not the expected one)
+Private
+*/
+func bumpCollectionVersion(tx *gorm.DB, userID uint, ifMatch *uint) (uint, error) {
+	current, err := models.LockCollectionVersion(tx, userID)
+	if err != nil {
+		return current, err
+	}
+	if ifMatch != nil && *ifMatch != current {
+		return current, CollectionConflictError{Current: current}
+	}
+	return current + 1, models.SetCollectionVersion(tx, userID, current+1)
+}
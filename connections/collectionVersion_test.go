@@ -0,0 +1,114 @@
+/*
+File		: collectionVersion_test.go
+Description	: Tests of the versions of the collections and the writes with a stale version (If-Match).
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+// Writes of a collection with an expected version
+var collectionWrites = []struct {
+	name  string
+	write func(ctx context.Context, userID, cardID uint, ifMatch *uint) error
+}{
+	{"add", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		_, _, err := AddCollectionItemDB(ctx, userID, ifMatch, models.CardOwnership{VersionID: boltM10, Condi: "NM", Count: 1})
+		return err
+	}},
+	{"modify", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		count := uint(7)
+		_, _, err := ModifyCollectionItemDB(ctx, userID, cardID, ifMatch, models.CardOwnershipUpdate{Count: &count})
+		return err
+	}},
+	{"delete", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		_, err := DeleteCollectionItemDB(ctx, userID, cardID, ifMatch)
+		return err
+	}},
+	{"patch", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		_, _, err := PatchCollectionDB(ctx, userID, ifMatch, []models.CollectionOperation{{Op: "adjust", CardID: cardID, Count: 1}})
+		return err
+	}},
+	{"save", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		list := models.CardOwnershipList{CardOwnerships: []models.CardOwnership{{VersionID: solRing, OracleID: "ring", Condi: "NM", Count: 1}}}
+		_, err := SaveUserCollectionDB(ctx, list, userID, ifMatch)
+		return err
+	}},
+	{"import CSV", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		csv := "Count,Tradelist Count,Name,Edition,Condition,Language,Foil,Collector Number\n" +
+			"1,0,Sol Ring,c21,Near Mint,English,,263\n"
+		_, err := ImportCollectionCSV(ctx, userID, ifMatch, strings.NewReader(csv), "moxfield")
+		return err
+	}},
+	{"import text", func(ctx context.Context, userID, cardID uint, ifMatch *uint) error {
+		_, err := ImportCollectionText(ctx, userID, ifMatch, strings.NewReader("1 Sol Ring (C21) 263\n"))
+		return err
+	}},
+}
+
+func TestStaleCollectionWrites(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	for i, test := range collectionWrites {
+		t.Run(test.name, func(t *testing.T) {
+			user, cardIDs := createTestUser(t, fmt.Sprintf("user%d", i),
+				models.CardOwnership{VersionID: boltM10, OracleID: "bolt", Count: 2, Condi: "NM"},
+			)
+			if err := models.SetCollectionVersion(models.DB, user.User_id, 3); err != nil {
+				t.Fatal(err)
+			}
+			before := testCollection(t, user.User_id)
+
+			stale := uint(2)
+			err := test.write(ctx, user.User_id, cardIDs[0], &stale)
+			var conflict CollectionConflictError
+			if !errors.Is(err, ErrStaleCollection) || !errors.As(err, &conflict) || conflict.Current != 3 {
+				t.Fatalf("write with version 2 = %v, want a conflict with the current version 3", err)
+			}
+			if got := testCollection(t, user.User_id); !reflect.DeepEqual(got, before) {
+				t.Errorf("collection after the stale write = %v, want it unchanged %v", got, before)
+			}
+
+			current := uint(3)
+			if err := test.write(ctx, user.User_id, cardIDs[0], &current); err != nil {
+				t.Fatalf("write with the current version: %v", err)
+			}
+			if version, err := models.GetCollectionVersion(ctx, user.User_id); err != nil || version != 4 {
+				t.Errorf("version after the write = %d (%v), want 4", version, err)
+			}
+		})
+	}
+}
+
+func TestCollectionWritesWithoutIfMatch(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	user, cardIDs := createTestUser(t, "alice",
+		models.CardOwnership{VersionID: boltM10, OracleID: "bolt", Count: 2, Condi: "NM"},
+	)
+	// Without If-Match the writes are never stale, but they still change the version
+	want := uint(0)
+	for _, test := range collectionWrites {
+		if test.name == "delete" || test.name == "save" {
+			continue // They remove the card of the next writes
+		}
+		if err := test.write(ctx, user.User_id, cardIDs[0], nil); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		want++
+		if version, err := models.GetCollectionVersion(ctx, user.User_id); err != nil || version != want {
+			t.Errorf("version after %s = %d (%v), want %d", test.name, version, err, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+File		: decklistImport.go
+Description	: File that deals with the import of plain text card lists, as exported by MTG Arena, MTGO and most deck
+builders ("4 Lightning Bolt (M10) 146 *F*"). Every line is resolved to a card version and added to the user's collection.
+*/
+
+package connections
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"CardaliaAPI/models"
+)
+
+// Format name of the plain text lists
+const TextFormat = "text"
+
+// Line of a text list: [SB:] [count[x]] name [(SET) [number]]
+var decklistLine = regexp.MustCompile(`^(?:SB:\s*)?(?:(\d+)x?\s+)?(.+?)(?:\s+\(([A-Za-z0-9]+)\)(?:\s+(\S+))?)?$`)
+
+// Foil markers of a text list (*F* foil, *E* etched)
+var decklistFoil = regexp.MustCompile(`(?i)\s*\*[FE]\*\s*`)
+
+// Section headers of the MTG Arena exports
+var decklistSections = map[string]bool{
+	"deck": true, "sideboard": true, "commander": true, "companion": true, "maybeboard": true, "about": true,
+}
+
+// Number of names suggested for a line that can't be resolved
+const maxSuggestions = 5
+
+/*
+Function	: Import collection
+Description	: Add the cards of an exported collection to the user's collection, choosing the parser of the format.
+Parameters 	: context, userID, expected collection version (nil to skip the check), reader, format (text or a CSV format)
+Return     	: ImportReport, error
+*/
+func ImportCollection(ctx context.Context, userID uint, ifMatch *uint, r io.Reader, format string) (models.ImportReport, error) {
+	if strings.EqualFold(format, TextFormat) {
+		return ImportCollectionText(ctx, userID, ifMatch, r)
+	}
+	return ImportCollectionCSV(ctx, userID, ifMatch, r, format)
+}
+
+/*
+Function	: Import collection text
+Description	: Add the cards of a plain text list to the user's collection. Empty lines, comments (//, #) and section
+headers are skipped. The lines that can't be resolved are returned in the report with the names they could be.
+
+Parameters 	: context, userID, expected collection version (nil to skip the check), text reader
+Return     	: ImportReport, error
+*/
+func ImportCollectionText(ctx context.Context, userID uint, ifMatch *uint, r io.Reader) (models.ImportReport, error) {
+	report := models.ImportReport{Unresolved: []models.ImportIssue{}}
+	rows, err := parseTextRows(r, &report)
+	if err != nil {
+		return report, err
+	}
+	return report, importRows(ctx, userID, ifMatch, rows, &report)
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Parse text rows
+Description	: Read the lines of a text list. The lines with wrong values are added to the report.
+Parameters 	: text reader, ImportReport
+Return     	: importRow list, error
+Private
+*/
+func parseTextRows(r io.Reader, report *models.ImportReport) ([]importRow, error) {
+	var rows []importRow
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			raw = strings.TrimPrefix(raw, "\ufeff")
+		}
+		if raw == "" || strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "#") ||
+			decklistSections[strings.ToLower(strings.TrimSuffix(raw, ":"))] {
+			continue
+		}
+
+		row := importRow{line: line, raw: raw, count: 1, condi: "NM"}
+		text := raw
+		if decklistFoil.MatchString(text) {
+			row.extras = models.ExtrasFoil
+			text = strings.TrimSpace(decklistFoil.ReplaceAllString(text, " "))
+		}
+		match := decklistLine.FindStringSubmatch(text)
+		if match[1] != "" {
+			count, err := strconv.ParseUint(match[1], 10, 32)
+			if err != nil || count == 0 {
+				report.AddIssue(line, raw, "invalid count "+strconv.Quote(match[1]))
+				continue
+			}
+			row.count = uint(count)
+		}
+		row.name = strings.TrimSpace(match[2])
+		row.setCode = strings.ToLower(match[3])
+		row.number = match[4]
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+/*
+Function	: Suggestions
+Description	: Get the names of the cards that could be the one of a line that can't be resolved. Suggestions are only a
+help, so if they can't be found the list is empty.
+
+Parameters 	: context, cardName
+Return     	: cardName list
+Private
+*/
+func suggestions(ctx context.Context, name string) []string {
+	names, err := Cards.Autocomplete(ctx, name)
+	if err != nil || len(names) == 0 {
+		// Try again with the first word, for typos at the end of the name
+		words := strings.Fields(name)
+		if len(words) < 2 {
+			return nil
+		}
+		if names, err = Cards.Autocomplete(ctx, words[0]); err != nil {
+			return nil
+		}
+	}
+	if len(names) > maxSuggestions {
+		names = names[:maxSuggestions]
+	}
+	return names
+}
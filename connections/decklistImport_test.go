@@ -0,0 +1,115 @@
+/*
+File		: decklistImport_test.go
+Description	: Tests of the parser of the plain text card lists and of the suggestions for the lines that can't be
+resolved, and of their import into the collections.
+*/
+
+package connections
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestParseTextRows(t *testing.T) {
+	tests := []struct {
+		line string
+		want importRow
+	}{
+		{"4 Lightning Bolt", importRow{count: 4, name: "Lightning Bolt"}},
+		{"4x Lightning Bolt", importRow{count: 4, name: "Lightning Bolt"}},
+		{"Lightning Bolt", importRow{count: 1, name: "Lightning Bolt"}},
+		{"1 Lightning Bolt (M10) 146", importRow{count: 1, name: "Lightning Bolt", setCode: "m10", number: "146"}},
+		{"2 Counterspell (MH2)", importRow{count: 2, name: "Counterspell", setCode: "mh2"}},
+		{"1 Lightning Bolt (2XM) 129 *F*", importRow{count: 1, name: "Lightning Bolt", setCode: "2xm", number: "129", extras: models.ExtrasFoil}},
+		{"1 Sol Ring *e*", importRow{count: 1, name: "Sol Ring", extras: models.ExtrasFoil}},
+		{"SB: 3 Swords to Plowshares", importRow{count: 3, name: "Swords to Plowshares"}},
+		{"1 Fire // Ice (MH2) 290", importRow{count: 1, name: "Fire // Ice", setCode: "mh2", number: "290"}},
+	}
+	for _, test := range tests {
+		var report models.ImportReport
+		rows, err := parseTextRows(strings.NewReader(test.line), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Errorf("%q: got %d rows, issues %+v", test.line, len(rows), report.Unresolved)
+			continue
+		}
+		test.want.line, test.want.raw, test.want.condi = 1, test.line, "NM"
+		if rows[0] != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.line, rows[0], test.want)
+		}
+	}
+}
+
+func TestParseTextRowsSkipsAndIssues(t *testing.T) {
+	list := "\ufeffDeck\n// comment\n# comment\n\n4 Lightning Bolt\nSideboard:\n0 Counterspell\n99999999999 Sol Ring\n"
+	var report models.ImportReport
+	rows, err := parseTextRows(strings.NewReader(list), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].name != "Lightning Bolt" || rows[0].line != 5 {
+		t.Errorf("rows = %+v", rows)
+	}
+	var lines []int
+	for _, issue := range report.Unresolved {
+		lines = append(lines, issue.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{7, 8}) {
+		t.Errorf("lines with issues = %v, want [7 8]", lines)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	useFakeProvider(t)
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Lightning", []string{"Lightning Bolt"}},
+		{"Llanowar Elfs", []string{"Llanowar Elves"}}, // Typo: found with the first word
+		{"Nothing Like This", nil},
+		{"Zzz", nil},
+	}
+	for _, test := range tests {
+		got := suggestions(context.Background(), test.name)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("suggestions(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestImportCollectionTextMerges(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	user, _ := createTestUser(t, "alice",
+		models.CardOwnership{VersionID: bolt2XM, OracleID: "bolt", Count: 1, Condi: "NM"},
+	)
+	decklist := `4 Lightning Bolt (2XM) 129
+2 Lightning Bolt
+1 Counterspell (MH2) 267 *F*
+`
+	report, err := ImportCollectionText(context.Background(), user.User_id, nil, strings.NewReader(decklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || report.Merged != 2 || len(report.Unresolved) != 0 {
+		t.Errorf("report = %+v, want 1 imported and 2 merged", report)
+	}
+	want := map[string]uint{
+		bolt2XM + "//NM":            7, // Without set, the last version
+		counterspellMH + "/foil/NM": 1,
+	}
+	if got := testCollection(t, user.User_id); !reflect.DeepEqual(got, want) {
+		t.Errorf("collection = %v, want %v", got, want)
+	}
+}
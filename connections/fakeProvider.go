@@ -0,0 +1,177 @@
+/*
+File		: fakeProvider.go
+Description	: Card provider that keeps a list of cards in memory. The cards are loaded from a fixture file with the same
+format as the Scryfall bulk files, so collections and trades can be used without Scryfall and without a catalog.
+*/
+
+package connections
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+
+	"CardaliaAPI/models"
+)
+
+// Maximum number of names returned by the fake autocomplete
+const fakeAutocompleteLimit = 20
+
+// Card provider with the cards in memory
+type FakeProvider struct {
+	byID   map[string]scryfallCard
+	byName map[string][]scryfallCard // Lower case name -> versions ordered by release date
+	names  []string                  // All the card names ordered
+}
+
+/*
+Function	: New fake provider
+Description	: Build a fake provider with the cards of a fixture file (a JSON list of Scryfall cards).
+Parameters 	: fixture path
+Return     	: FakeProvider, error
+*/
+func NewFakeProvider(path string) (FakeProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FakeProvider{}, err
+	}
+	defer file.Close()
+
+	var cards []scryfallCard
+	err = decodeCardList(file, func(card bulkCard) error {
+		cards = append(cards, card.scryfallCard)
+		return nil
+	})
+	if err != nil {
+		return FakeProvider{}, err
+	}
+	return newFakeProvider(cards), nil
+}
+
+/*
+Function	: New fake provider with cards
+Description	: Build a fake provider with a list of cards.
+Parameters 	: scryfallCard list
+Return     	: FakeProvider
+Private
+*/
+func newFakeProvider(cards []scryfallCard) FakeProvider {
+	p := FakeProvider{
+		byID:   make(map[string]scryfallCard),
+		byName: make(map[string][]scryfallCard),
+	}
+	for _, card := range cards {
+		key := strings.ToLower(card.Name)
+		if _, ok := p.byName[key]; !ok {
+			p.names = append(p.names, card.Name)
+		}
+		p.byID[card.ID] = card
+		p.byName[key] = append(p.byName[key], card)
+	}
+	for _, versions := range p.byName {
+		sort.SliceStable(versions, func(i, j int) bool { return versions[i].ReleasedAt < versions[j].ReleasedAt })
+	}
+	sort.Strings(p.names)
+	return p
+}
+
+/*
+Function	: Card by ID
+Description	: Get a card by its ID.
+Self		: FakeProvider
+Parameters 	: context, cardID
+Return     	: Card, error
+*/
+func (p FakeProvider) CardByID(ctx context.Context, id string) (models.Card, error) {
+	card, ok := p.byID[id]
+	if !ok {
+		return models.Card{}, ErrNotInCatalog
+	}
+	return card.Card, nil
+}
+
+/*
+Function	: Cards by ID
+Description	: Get a list of cards by their IDs. The IDs that don't exist are not in the map.
+Self		: FakeProvider
+Parameters 	: context, cardID list
+Return     	: cardID -> Card map, error
+*/
+func (p FakeProvider) CardsByID(ctx context.Context, ids []string) (map[string]models.Card, error) {
+	cards := make(map[string]models.Card)
+	for _, id := range ids {
+		if card, ok := p.byID[id]; ok {
+			cards[id] = card.Card
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: Cards by set number
+Description	: Get a list of cards by their set code and collector number. The cards that don't exist are not in the map.
+Self		: FakeProvider
+Parameters 	: context, SetNumber list
+Return     	: SetNumber -> Card map, error
+*/
+func (p FakeProvider) CardsBySetNumber(ctx context.Context, keys []models.SetNumber) (map[models.SetNumber]models.Card, error) {
+	wanted := make(map[models.SetNumber]bool)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	cards := make(map[models.SetNumber]models.Card)
+	for _, card := range p.byID {
+		if key := setNumberOf(card.Card); wanted[key] {
+			cards[key] = card.Card
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: Card by name
+Description	: Get a card by its exact name (case insensitive). Returns the last released version.
+Self		: FakeProvider
+Parameters 	: context, cardName
+Return     	: Card, error
+*/
+func (p FakeProvider) CardByName(ctx context.Context, name string) (models.Card, error) {
+	versions := p.byName[strings.ToLower(name)]
+	if len(versions) == 0 {
+		return models.Card{}, ErrNotInCatalog
+	}
+	return versions[len(versions)-1].Card, nil
+}
+
+/*
+Function	: Autocomplete
+Description	: Get the card names that start with a partial name (case insensitive).
+Self		: FakeProvider
+Parameters 	: context, uncompleted cardName
+Return     	: cardName list, error
+*/
+func (p FakeProvider) Autocomplete(ctx context.Context, partial string) ([]string, error) {
+	names := []string{}
+	partial = strings.ToLower(partial)
+	for _, name := range p.names {
+		if strings.HasPrefix(strings.ToLower(name), partial) {
+			names = append(names, name)
+		}
+		if len(names) == fakeAutocompleteLimit {
+			break
+		}
+	}
+	return names, nil
+}
+
+/*
+Function	: Printings
+Description	: Get all the paper versions of a card.
+Self		: FakeProvider
+Parameters 	: context, cardName
+Return     	: CardVersion list, error
+*/
+func (p FakeProvider) Printings(ctx context.Context, name string) ([]models.CardVersion, error) {
+	return paperVersions(p.byName[strings.ToLower(name)]), nil
+}
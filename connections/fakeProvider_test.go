@@ -0,0 +1,99 @@
+/*
+File		: fakeProvider_test.go
+Description	: Tests of the fake card provider, and the helper that uses it as the card provider of the tests.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Fixture with the cards of the tests
+const fixtureCards = "../fixtures/cards.json"
+
+// Version IDs of the fixture
+const (
+	boltLEA        = "00000000-0000-4000-8000-000000000001"
+	boltM10        = "00000000-0000-4000-8000-000000000002"
+	bolt2XM        = "00000000-0000-4000-8000-000000000003"
+	counterspellMH = "00000000-0000-4000-8000-000000000005"
+	solRing        = "00000000-0000-4000-8000-000000000007"
+)
+
+/*
+Function	: Use fake provider
+Description	: Use a FakeProvider with the fixture as the card provider until the end of a test.
+Parameters 	: test
+Return     	: FakeProvider
+*/
+func useFakeProvider(t *testing.T) FakeProvider {
+	t.Helper()
+	provider, err := NewFakeProvider(fixtureCards)
+	if err != nil {
+		t.Fatalf("loading %s: %v", fixtureCards, err)
+	}
+	previous := Cards
+	Cards = provider
+	t.Cleanup(func() { Cards = previous })
+	return provider
+}
+
+func TestFakeProviderCardByName(t *testing.T) {
+	provider := useFakeProvider(t)
+	tests := []struct {
+		name    string
+		wantID  string
+		wantErr error
+	}{
+		{"Lightning Bolt", bolt2XM, nil}, // The last released version
+		{"lightning bolt", bolt2XM, nil},
+		{"Sol Ring", solRing, nil},
+		{"Black Lotus", "", ErrCardNotFound},
+	}
+	for _, test := range tests {
+		card, err := provider.CardByName(context.Background(), test.name)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("CardByName(%q) error = %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		if card.ID != test.wantID {
+			t.Errorf("CardByName(%q) = %s, want %s", test.name, card.ID, test.wantID)
+		}
+	}
+}
+
+func TestFakeProviderAutocomplete(t *testing.T) {
+	provider := useFakeProvider(t)
+	tests := []struct {
+		partial string
+		want    []string
+	}{
+		{"l", []string{"Lightning Bolt", "Llanowar Elves"}},
+		{"SOL", []string{"Sol Ring"}},
+		{"zzz", []string{}},
+	}
+	for _, test := range tests {
+		names, err := provider.Autocomplete(context.Background(), test.partial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("Autocomplete(%q) = %v, want %v", test.partial, names, test.want)
+		}
+	}
+}
+
+func TestFakeProviderCardsByID(t *testing.T) {
+	provider := useFakeProvider(t)
+	cards, err := provider.CardsByID(context.Background(), []string{boltLEA, "missing", solRing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 || cards[boltLEA].Set != "lea" || cards[solRing].Name != "Sol Ring" {
+		t.Errorf("CardsByID = %+v", cards)
+	}
+}
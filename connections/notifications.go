@@ -0,0 +1,235 @@
+/*
+File		: notifications.go
+Description	: File that deals with the email notifications of the users: trade proposals, acceptances, completions,
+messages and price alerts. Every user chooses the notifications he wants, and can get them in a daily digest instead of one email per
+notification. The emails are sent with Mail (see utils/mailer).
+*/
+
+package connections
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+	"CardaliaAPI/utils/mailer"
+
+	"gorm.io/gorm"
+)
+
+// Mailer of the API
+var Mail mailer.Mailer = mailer.NoMailer{}
+
+/*
+Function	: Connect mailer
+Description	: Choose the Mailer of the API with the environment (see mailer.NewFromEnv).
+Parameters 	:
+Return     	:
+*/
+func ConnectMailer() {
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatal("Cannot start the mailer: ", err)
+	}
+	Mail = mail
+}
+
+/*
+Function	: Get notification preferences
+Description	: Get the email notifications that the user wants.
+Parameters 	: context, userID
+Return     	: NotificationPreferences, error
+*/
+func GetNotificationPreferencesDB(ctx context.Context, userID uint) (models.NotificationPreferences, error) {
+	return models.GetNotificationPreferences(models.DB.WithContext(ctx), userID)
+}
+
+/*
+Function	: Save notification preferences
+Description	: Save the email notifications that the user wants.
+Parameters 	: context, userID, NotificationPreferences
+Return     	: NotificationPreferences, error
+*/
+func SaveNotificationPreferencesDB(ctx context.Context, userID uint, preferences models.NotificationPreferences) (models.NotificationPreferences, error) {
+	preferences.UserID = userID
+	return preferences, models.DB.WithContext(ctx).Save(&preferences).Error
+}
+
+/*
+Function	: Send digests
+Description	: Send to every user with pending notifications one email with all of them. Run once a day.
+Parameters 	: context
+Return     	: number of emails sent, error
+*/
+func SendDigestsDB(ctx context.Context) (int, error) {
+	var userIDs []uint
+	if err := models.DB.WithContext(ctx).Model(&models.PendingNotification{}).Distinct("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, userID := range userIDs {
+		err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var pending []models.PendingNotification
+			if err := tx.Where("user_id = ?", userID).Order("notification_id").Find(&pending).Error; err != nil {
+				return err
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			var user models.User
+			if err := tx.First(&user, userID).Error; err != nil {
+				return err
+			}
+			data := mailer.TemplateData{Username: user.Username}
+			for _, notification := range pending {
+				data.Items = append(data.Items, notification.Summary)
+			}
+			mail, err := mailer.Render("digest", user.Email, data)
+			if err != nil {
+				return err
+			}
+			// The notifications are only deleted if the email is sent
+			if err := tx.Delete(&pending).Error; err != nil {
+				return err
+			}
+			return Mail.Send(mail)
+		})
+		if err != nil {
+			return sent, fmt.Errorf("digest of user %d: %w", userID, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Notify
+Description	: Send the email of an event of a trade to some of its users, if they want it. The users with the daily digest
+get it in the next digest. It runs in the background, the errors are logged.
+
+Parameters 	: event type, Trade, body of the message (message events), userID list
+Return     	:
+Private
+*/
+func notify(eventType string, trade models.Trade, message string, userIDs ...uint) {
+	if !mailer.HasTemplate(eventType) || len(userIDs) == 0 {
+		return
+	}
+	go func() {
+		for _, userID := range userIDs {
+			if err := notifyUser(eventType, trade, message, userID); err != nil {
+				log.Println("notifications:", err)
+			}
+		}
+	}()
+}
+
+/*
+Function	: Notify user
+Description	: Send the email of an event of a trade to one of its users, or keep it for his digest.
+Parameters 	: event type, Trade, body of the message, userID
+Return     	: error
+Private
+*/
+func notifyUser(eventType string, trade models.Trade, message string, userID uint) error {
+	preferences, err := models.GetNotificationPreferences(models.DB, userID)
+	if err != nil {
+		return err
+	}
+	if !wantsNotification(preferences, eventType) {
+		return nil
+	}
+	var user, other models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	if err := models.DB.First(&other, trade.OtherUser(userID)).Error; err != nil {
+		return err
+	}
+	mail, err := mailer.Render(eventType, user.Email, mailer.TemplateData{
+		Username: user.Username,
+		Other:    other.Username,
+		TradeID:  trade.TradeID,
+		State:    trade.State,
+		Message:  message,
+	})
+	if err != nil {
+		return err
+	}
+	if preferences.Digest {
+		return models.DB.Create(&models.PendingNotification{UserID: userID, Summary: mail.Subject}).Error
+	}
+	return Mail.Send(mail)
+}
+
+/*
+Function	: Notify price changes
+Description	: Send one email to a user with the price changes of his price alerts, if he wants it. The users with the
+daily digest get every change in the next digest.
+
+Parameters 	: userID, PriceChange list
+Return     	: error
+Private
+*/
+func notifyPriceChanges(userID uint, changes []models.PriceChange) error {
+	preferences, err := models.GetNotificationPreferences(models.DB, userID)
+	if err != nil {
+		return err
+	}
+	if !wantsNotification(preferences, events.PriceAlert) {
+		return nil
+	}
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	data := mailer.TemplateData{Username: user.Username}
+	for _, change := range changes {
+		foil := ""
+		if change.Foil {
+			foil = " (foil)"
+		}
+		data.Items = append(data.Items, fmt.Sprintf("%s [%s]%s: %.2f -> %.2f %s (%+.1f%%)", change.Name,
+			strings.ToUpper(change.Set), foil, change.OldPrice, change.NewPrice, strings.ToUpper(change.Currency), change.Change))
+	}
+	if preferences.Digest {
+		pending := make([]models.PendingNotification, 0, len(data.Items))
+		for _, item := range data.Items {
+			pending = append(pending, models.PendingNotification{UserID: userID, Summary: item})
+		}
+		return models.DB.Create(&pending).Error
+	}
+	mail, err := mailer.Render(events.PriceAlert, user.Email, data)
+	if err != nil {
+		return err
+	}
+	return Mail.Send(mail)
+}
+
+/*
+Function	: Wants notification
+Description	: Check if the preferences of a user allow the email of an event type.
+Parameters 	: NotificationPreferences, event type
+Return     	: bool
+Private
+*/
+func wantsNotification(preferences models.NotificationPreferences, eventType string) bool {
+	switch eventType {
+	case events.TradeCreated, events.TradeRevised:
+		return preferences.Proposals
+	case events.TradeAccepted:
+		return preferences.Acceptances
+	case events.TradeCompleted:
+		return preferences.Completions
+	case events.MessageCreated:
+		return preferences.Messages
+	case events.PriceAlert:
+		return preferences.PriceAlerts
+	}
+	return false
+}
@@ -0,0 +1,421 @@
+/*
+File		: priceHistory.go
+Description	: File that deals with the history of the prices: the daily snapshots of the prices and of the value of the
+collections, and the price alerts of the users, that are checked after every snapshot and sent as events, webhooks and
+emails.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Returned when a price alert doesn't exist or it is from another user
+var ErrPriceAlertNotFound = errors.New("price alert not found")
+
+// Default days of a price alert
+const defaultAlertDays = 7
+
+// Days before the compared day of a price alert where an older snapshot is still used (days without snapshot)
+const snapshotGap = 7
+
+// Card version checked by a price alert
+type alertCard struct {
+	versionID string
+	foil      bool
+}
+
+/*
+Function	: Get price history
+Description	: Get the daily prices of a card version between two days.
+Parameters 	: context, versionID, first day, last day
+Return     	: PriceSnapshot list (oldest first), error
+*/
+func GetPriceHistoryDB(ctx context.Context, versionID string, from time.Time, to time.Time) ([]models.PriceSnapshot, error) {
+	history := []models.PriceSnapshot{}
+	err := models.DB.WithContext(ctx).Where("version_id = ? AND date BETWEEN ? AND ?", versionID, models.Day(from), models.Day(to)).
+		Order("date").Find(&history).Error
+	return history, err
+}
+
+/*
+Function	: Get collection value history
+Description	: Get the daily value of the collection of the user between two days, in a currency.
+Parameters 	: context, userID, currency (usd, eur or tix), first day, last day
+Return     	: ValuePoint list (oldest first), error
+*/
+func GetCollectionValueHistoryDB(ctx context.Context, userID uint, currency string, from time.Time, to time.Time) ([]models.ValuePoint, error) {
+	history := []models.ValuePoint{}
+	if !isCurrency(currency) {
+		return history, fmt.Errorf("unknown currency %q", currency)
+	}
+	var snapshots []models.CollectionValueSnapshot
+	err := models.DB.WithContext(ctx).Where("user_id = ? AND date BETWEEN ? AND ?", userID, models.Day(from), models.Day(to)).
+		Order("date").Find(&snapshots).Error
+	if err != nil {
+		return history, err
+	}
+	for _, snapshot := range snapshots {
+		history = append(history, models.ValuePoint{Date: snapshot.Date, Value: snapshot.Value(currency)})
+	}
+	return history, nil
+}
+
+/*
+Function	: Snapshot prices
+Description	: Copy the last known prices of all the card versions to the snapshots of today. Run once a day, after
+refresh-prices or import-bulk.
+
+Parameters 	: context
+Return     	: number of card versions, error
+*/
+func SnapshotPricesDB(ctx context.Context) (int, error) {
+	today := models.Day(time.Now())
+	saved := 0
+	var prices []models.CardPrice
+	err := models.DB.WithContext(ctx).FindInBatches(&prices, 500, func(tx *gorm.DB, batch int) error {
+		if err := models.SavePriceSnapshots(models.DB.WithContext(ctx), prices, today); err != nil {
+			return err
+		}
+		saved += len(prices)
+		return nil
+	}).Error
+	return saved, err
+}
+
+/*
+Function	: Snapshot collection values
+Description	: Store the value of the collection of every user today, in all the currencies, with the last known
+prices. Run once a day, after SnapshotPricesDB.
+
+Parameters 	: context
+Return     	: number of collections, error
+*/
+func SnapshotCollectionValuesDB(ctx context.Context) (int, error) {
+	var userIDs []uint
+	err := models.DB.WithContext(ctx).Model(&models.CardOwnership{}).Distinct("user_id").Where("count != ?", 0).
+		Order("user_id").Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return 0, err
+	}
+	today := models.Day(time.Now())
+	saved := 0
+	for _, userID := range userIDs {
+		var cardOwnerships []models.CardOwnership
+		if err := models.DB.WithContext(ctx).Where("user_id = ? AND count != ?", userID, 0).Find(&cardOwnerships).Error; err != nil {
+			return saved, err
+		}
+		var versionIDs []string
+		for _, cardDB := range cardOwnerships {
+			versionIDs = append(versionIDs, cardDB.VersionID)
+		}
+		prices, err := models.GetCardPrices(models.DB.WithContext(ctx), versionIDs)
+		if err != nil {
+			return saved, err
+		}
+		snapshot := models.CollectionValueSnapshot{
+			UserID: userID,
+			Date:   today,
+			USD:    collectionTotal(cardOwnerships, prices, models.CurrencyUSD),
+			EUR:    collectionTotal(cardOwnerships, prices, models.CurrencyEUR),
+			Tix:    collectionTotal(cardOwnerships, prices, models.CurrencyTix),
+		}
+		if err := models.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&snapshot).Error; err != nil {
+			return saved, err
+		}
+		saved++
+	}
+	return saved, nil
+}
+
+/*
+Function	: Check price alerts
+Description	: Compare the prices of today with the prices of the days of every price alert, and send the price changes
+that trigger them to their users (event, webhooks and email). A card doesn't trigger the same alert again during its
+days; the changes are only marked as sent when the email (or the notifications of the digest) is done, so the changes
+that could not be sent are sent again in the next run. The event and webhooks are sent after that, so they are sent
+once. Run once a day, after SnapshotPricesDB.
+
+Parameters 	: context
+Return     	: number of price changes sent, error
+*/
+func CheckPriceAlertsDB(ctx context.Context) (int, error) {
+	var alerts []models.PriceAlert
+	if err := models.DB.WithContext(ctx).Order("user_id, alert_id").Find(&alerts).Error; err != nil {
+		return 0, err
+	}
+	today := models.Day(time.Now())
+	var userIDs []uint
+	userChanges := map[uint][]models.PriceChange{}
+	for _, alert := range alerts {
+		changes, err := checkPriceAlert(ctx, alert, today)
+		if err != nil {
+			return 0, fmt.Errorf("price alert %d: %w", alert.AlertID, err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if _, ok := userChanges[alert.UserID]; !ok {
+			userIDs = append(userIDs, alert.UserID)
+		}
+		userChanges[alert.UserID] = append(userChanges[alert.UserID], changes...)
+	}
+
+	sent := 0
+	for _, userID := range userIDs {
+		changes := userChanges[userID]
+		nameCards(ctx, changes)
+		if err := notifyPriceChanges(userID, changes); err != nil {
+			log.Println("notifications:", err)
+			continue
+		}
+		if err := saveAlertHits(ctx, changes); err != nil {
+			return sent, err
+		}
+		// Only published once they are marked as sent, so the next run doesn't publish them again
+		publishEvent(events.PriceAlert, changes, userID)
+		sent += len(changes)
+	}
+	return sent, nil
+}
+
+/*
+Function	: Get price alerts
+Description	: Get the price alerts of the user.
+Parameters 	: context, userID
+Return     	: PriceAlert list, error
+*/
+func GetPriceAlertsDB(ctx context.Context, userID uint) ([]models.PriceAlert, error) {
+	alerts := []models.PriceAlert{}
+	err := models.DB.WithContext(ctx).Where("user_id = ?", userID).Order("alert_id").Find(&alerts).Error
+	return alerts, err
+}
+
+/*
+Function	: Add price alert
+Description	: Add a price alert for a card version or, without version, for all the cards of the collection of the
+user. The currency is usd and the days are 7 if they are not sent.
+
+Parameters 	: context, userID, PriceAlertRequest
+Return     	: PriceAlert, error
+*/
+func AddPriceAlertDB(ctx context.Context, userID uint, request models.PriceAlertRequest) (models.PriceAlert, error) {
+	alert := models.PriceAlert{
+		UserID:    userID,
+		VersionID: request.VersionID,
+		Foil:      request.Foil,
+		Currency:  request.Currency,
+		Direction: request.Direction,
+		Percent:   request.Percent,
+		Days:      request.Days,
+	}
+	if alert.Currency == "" {
+		alert.Currency = models.CurrencyUSD
+	}
+	if alert.Days == 0 {
+		alert.Days = defaultAlertDays
+	}
+	if alert.VersionID == "" && alert.Foil {
+		return alert, errors.New("foil is only for the price alerts of a card version")
+	}
+	if alert.Direction == models.AlertDrop && alert.Percent >= 100 {
+		return alert, errors.New("a price cannot drop 100% or more")
+	}
+	if alert.VersionID != "" {
+		// The card must exist
+		if _, err := Cards.CardByID(ctx, alert.VersionID); err != nil {
+			return alert, err
+		}
+	}
+	return alert, models.DB.WithContext(ctx).Create(&alert).Error
+}
+
+/*
+Function	: Delete price alert
+Description	: Remove a price alert of the user.
+Parameters 	: context, userID, AlertID
+Return     	: error (ErrPriceAlertNotFound if it doesn't exist or it is from another user)
+*/
+func DeletePriceAlertDB(ctx context.Context, userID uint, alertID uint) error {
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("alert_id = ? AND user_id = ?", alertID, userID).Delete(&models.PriceAlert{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %d", ErrPriceAlertNotFound, alertID)
+		}
+		return tx.Where("alert_id = ?", alertID).Delete(&models.PriceAlertHit{}).Error
+	})
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Collection total
+Description	: Value a collection in a currency with some prices. The foil cards use the foil prices, and the cards
+without price are not counted.
+
+Parameters 	: CardOwnership list, VersionID -> CardPrice map, currency
+Return     	: value
+Private
+*/
+func collectionTotal(cardOwnerships []models.CardOwnership, prices map[string]models.CardPrice, currency string) float64 {
+	total := 0.0
+	for _, cardDB := range cardOwnerships {
+		price, ok := prices[cardDB.VersionID]
+		if !ok {
+			continue
+		}
+		if value := price.Price(currency, models.IsFoil(cardDB.Extras)); value != nil {
+			total += models.RoundPrice(*value * float64(cardDB.Count))
+		}
+	}
+	return models.RoundPrice(total)
+}
+
+/*
+Function	: Check price alert
+Description	: Find the cards of a price alert whose price changed enough since the days of the alert and that didn't
+trigger it during those days.
+
+Parameters 	: context, PriceAlert, today
+Return     	: PriceChange list (without card names), error
+Private
+*/
+func checkPriceAlert(ctx context.Context, alert models.PriceAlert, today time.Time) ([]models.PriceChange, error) {
+	changes := []models.PriceChange{}
+	cards, err := alertCards(ctx, alert)
+	if err != nil || len(cards) == 0 {
+		return changes, err
+	}
+	var versionIDs []string
+	for _, card := range cards {
+		versionIDs = append(versionIDs, card.versionID)
+	}
+	now, err := models.GetPriceSnapshots(models.DB.WithContext(ctx), versionIDs, today, today)
+	if err != nil {
+		return changes, err
+	}
+	compared := today.AddDate(0, 0, -alert.Days)
+	before, err := models.GetPriceSnapshots(models.DB.WithContext(ctx), versionIDs, compared.AddDate(0, 0, -snapshotGap), compared)
+	if err != nil {
+		return changes, err
+	}
+	var hits []models.PriceAlertHit
+	if err := models.DB.WithContext(ctx).Where("alert_id = ? AND triggered_at > ?", alert.AlertID, compared).Find(&hits).Error; err != nil {
+		return changes, err
+	}
+	quiet := map[alertCard]bool{}
+	for _, hit := range hits {
+		quiet[alertCard{versionID: hit.VersionID, foil: hit.Foil}] = true
+	}
+
+	for _, card := range cards {
+		if quiet[card] {
+			continue
+		}
+		newPrice := now[card.versionID].Price(alert.Currency, card.foil)
+		oldPrice := before[card.versionID].Price(alert.Currency, card.foil)
+		if newPrice == nil || oldPrice == nil {
+			continue
+		}
+		triggered, change := alert.Triggered(*oldPrice, *newPrice)
+		if !triggered {
+			continue
+		}
+		changes = append(changes, models.PriceChange{AlertID: alert.AlertID, VersionID: card.versionID, Foil: card.foil,
+			Currency: alert.Currency, OldPrice: *oldPrice, NewPrice: *newPrice, Change: change})
+	}
+	return changes, nil
+}
+
+/*
+Function	: Save alert hits
+Description	: Mark the cards of some sent price changes, so they don't trigger their alerts again during their days.
+Parameters 	: context, PriceChange list
+Return     	: error
+Private
+*/
+func saveAlertHits(ctx context.Context, changes []models.PriceChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	now := time.Now()
+	hits := make([]models.PriceAlertHit, 0, len(changes))
+	for _, change := range changes {
+		hits = append(hits, models.PriceAlertHit{AlertID: change.AlertID, VersionID: change.VersionID, Foil: change.Foil,
+			OldPrice: change.OldPrice, NewPrice: change.NewPrice, TriggeredAt: now})
+	}
+	return models.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&hits).Error
+}
+
+/*
+Function	: Alert cards
+Description	: Get the cards checked by a price alert: its card version, or every card of the collection of the user
+(foil or not, as they are in the collection).
+
+Parameters 	: context, PriceAlert
+Return     	: alertCard list (without duplicates), error
+Private
+*/
+func alertCards(ctx context.Context, alert models.PriceAlert) ([]alertCard, error) {
+	if alert.VersionID != "" {
+		return []alertCard{{versionID: alert.VersionID, foil: alert.Foil}}, nil
+	}
+	var cardOwnerships []models.CardOwnership
+	err := models.DB.WithContext(ctx).Where("user_id = ? AND count != ?", alert.UserID, 0).Order("card_id").Find(&cardOwnerships).Error
+	if err != nil {
+		return nil, err
+	}
+	var cards []alertCard
+	seen := map[alertCard]bool{}
+	for _, cardDB := range cardOwnerships {
+		card := alertCard{versionID: cardDB.VersionID, foil: models.IsFoil(cardDB.Extras)}
+		if !seen[card] {
+			seen[card] = true
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: Name cards
+Description	: Fill the names and sets of the cards of some price changes with the card provider. The cards that are not
+found, or all of them if the provider fails, keep their version ID as name: the changes are still sent.
+
+Parameters 	: context, PriceChange list
+Return     	:
+Private
+*/
+func nameCards(ctx context.Context, changes []models.PriceChange) {
+	var versionIDs []string
+	for _, change := range changes {
+		versionIDs = append(versionIDs, change.VersionID)
+	}
+	cards, err := Cards.CardsByID(ctx, versionIDs)
+	if err != nil {
+		log.Println("price alerts:", err)
+	}
+	for i := range changes {
+		card, ok := cards[changes[i].VersionID]
+		if !ok {
+			changes[i].Name = changes[i].VersionID
+			continue
+		}
+		changes[i].Name = card.Name
+		changes[i].Set = card.Set
+	}
+}
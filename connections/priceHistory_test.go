@@ -0,0 +1,46 @@
+/*
+File		: priceHistory_test.go
+Description	: Tests of the value of a collection from the prices of its cards.
+*/
+
+package connections
+
+import (
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestCollectionTotal(t *testing.T) {
+	price := func(value float64) *float64 { return &value }
+	prices := map[string]models.CardPrice{
+		boltLEA:        {VersionID: boltLEA, USD: price(450.5), EUR: price(399.99)},
+		boltM10:        {VersionID: boltM10, USD: price(1.15), USDFoil: price(7.33), Tix: price(0.03)},
+		counterspellMH: {VersionID: counterspellMH, EUR: price(0.333)},
+	}
+	collection := []models.CardOwnership{
+		{VersionID: boltLEA, Count: 1},
+		{VersionID: boltM10, Count: 3},
+		{VersionID: boltM10, Count: 2, Extras: models.ExtrasFoil},
+		{VersionID: counterspellMH, Count: 3},
+		{VersionID: solRing, Count: 1}, // No price
+	}
+	tests := []struct {
+		currency string
+		cards    []models.CardOwnership
+		want     float64
+	}{
+		{models.CurrencyUSD, collection, 450.5 + 3.45 + 14.66},
+		{models.CurrencyEUR, collection, 399.99 + 1},
+		{models.CurrencyTix, collection, 0.15},
+		{models.CurrencyUSD, collection[4:], 0},
+		{models.CurrencyUSD, nil, 0},
+		{"GBP", collection, 0},
+	}
+	for _, test := range tests {
+		if got := collectionTotal(test.cards, prices, test.currency); got != models.RoundPrice(test.want) {
+			t.Errorf("collectionTotal(%d cards, %s) = %v, want %v", len(test.cards), test.currency, got,
+				models.RoundPrice(test.want))
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+File		: prices.go
+Description	: File that deals with the prices of the cards: refreshing them from Scryfall and using them to value the
+collections. The prices are also stored when the cards are read from Scryfall or imported from a bulk file.
+*/
+
+package connections
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"CardaliaAPI/models"
+)
+
+// Card versions asked to Scryfall in each step of RefreshPricesDB
+const priceRefreshBatch = 10 * scryfallCollectionLimit
+
+/*
+Function	: Refresh prices
+Description	: Get from Scryfall the prices of all the card versions in the collections and store them (the catalog
+cards are also refreshed).
+
+Parameters 	: context
+Return     	: number of card versions refreshed, error
+*/
+func RefreshPricesDB(ctx context.Context) (int, error) {
+	var versionIDs []string
+	err := models.DB.WithContext(ctx).Model(&models.CardOwnership{}).Distinct("version_id").Where("count != ?", 0).
+		Order("version_id").Pluck("version_id", &versionIDs).Error
+	if err != nil {
+		return 0, err
+	}
+	scryfall := NewScryfallProvider()
+	refreshed := 0
+	for start := 0; start < len(versionIDs); start += priceRefreshBatch {
+		end := start + priceRefreshBatch
+		if end > len(versionIDs) {
+			end = len(versionIDs)
+		}
+		cards, err := scryfall.getCardsByID(ctx, versionIDs[start:end])
+		if err != nil {
+			return refreshed, err
+		}
+		catalogCards := make([]models.CatalogCard, 0, len(cards))
+		prices := make([]models.CardPrice, 0, len(cards))
+		for _, card := range cards {
+			catalogCards = append(catalogCards, card.toCatalogCard())
+			prices = append(prices, card.toCardPrice())
+		}
+		if err := models.SaveCatalogCards(catalogCards); err != nil {
+			return refreshed, err
+		}
+		if err := models.SaveCardPrices(models.DB.WithContext(ctx), prices); err != nil {
+			return refreshed, err
+		}
+		refreshed += len(prices)
+	}
+	return refreshed, nil
+}
+
+/*
+Function	: Get collection value
+Description	: Value the collection of the user in a currency, with the last known prices. The foil cards (see
+models.IsFoil) are valued with the foil prices. The value is broken down by card, by set and by foil or not.
+
+Parameters 	: context, userID, currency (usd, eur or tix)
+Return     	: CollectionValue, error
+*/
+func GetCollectionValueDB(ctx context.Context, userID uint, currency string) (models.CollectionValue, error) {
+	value := models.CollectionValue{Currency: currency, Sets: []models.SetValue{}, Cards: []models.CardValue{}}
+	if !isCurrency(currency) {
+		return value, fmt.Errorf("unknown currency %q", currency)
+	}
+	var cardOwnerships []models.CardOwnership
+	if err := models.DB.WithContext(ctx).Where("user_id = ? AND count != ?", userID, 0).Find(&cardOwnerships).Error; err != nil {
+		return value, err
+	}
+	cards, err := buildCards(ctx, cardOwnerships)
+	if err != nil {
+		return value, err
+	}
+	var versionIDs []string
+	for _, cardDB := range cardOwnerships {
+		versionIDs = append(versionIDs, cardDB.VersionID)
+	}
+	prices, err := models.GetCardPrices(models.DB.WithContext(ctx), versionIDs)
+	if err != nil {
+		return value, err
+	}
+
+	sets := map[string]*models.SetValue{}
+	var setCodes []string
+	for i, cardDB := range cardOwnerships {
+		cardValue := models.CardValue{Card: cards[i], Foil: models.IsFoil(cardDB.Extras)}
+		if price, ok := prices[cardDB.VersionID]; ok {
+			cardValue.Price = price.Price(currency, cardValue.Foil)
+		}
+		set, ok := sets[cards[i].Set]
+		if !ok {
+			set = &models.SetValue{Set: cards[i].Set, SetName: cards[i].SetName}
+			sets[cards[i].Set] = set
+			setCodes = append(setCodes, cards[i].Set)
+		}
+		set.Count += int(cardDB.Count)
+		if cardValue.Price == nil {
+			value.Unpriced += int(cardDB.Count)
+		} else {
+			cardValue.Value = models.RoundPrice(*cardValue.Price * float64(cardDB.Count))
+			set.Value += cardValue.Value
+			value.Total += cardValue.Value
+			if cardValue.Foil {
+				value.Foil += cardValue.Value
+			} else {
+				value.NonFoil += cardValue.Value
+			}
+		}
+		value.Cards = append(value.Cards, cardValue)
+	}
+	value.Total = models.RoundPrice(value.Total)
+	value.Foil = models.RoundPrice(value.Foil)
+	value.NonFoil = models.RoundPrice(value.NonFoil)
+	for _, code := range setCodes {
+		sets[code].Value = models.RoundPrice(sets[code].Value)
+		value.Sets = append(value.Sets, *sets[code])
+	}
+
+	sort.SliceStable(value.Cards, func(i, j int) bool {
+		return value.Cards[i].Value > value.Cards[j].Value
+	})
+	sort.SliceStable(value.Sets, func(i, j int) bool {
+		if value.Sets[i].Value != value.Sets[j].Value {
+			return value.Sets[i].Value > value.Sets[j].Value
+		}
+		return value.Sets[i].Set < value.Sets[j].Set
+	})
+	return value, nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Is currency
+Description	: Check if there are prices in a currency.
+Parameters 	: currency
+Return     	: bool
+Private
+*/
+func isCurrency(currency string) bool {
+	for _, c := range models.Currencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+/*
+File		: reservations.go
+Description	: File that deals with the copies of the cards that are reserved by trades. The copies offered in a
+negotiated trade are reserved until the trade is accepted (and they are removed from the collection) or closed. A card
+can't be offered in more trades than its copies, and the reserved copies can't be removed from the collection.
+*/
+
+package connections
+
+import (
+	"errors"
+	"fmt"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Returned when there are not enough copies of a card that are not reserved by trades
+var ErrNotAvailable = errors.New("not enough copies available")
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Check available
+Description	: Check that a cardOwnership has at least the copies that are reserved.
+Parameters 	: CardOwnership (with the new count), reserved copies
+Return     	: error (ErrNotAvailable)
+Private
+*/
+func checkAvailable(cardOwnership models.CardOwnership, reserved uint) error {
+	if cardOwnership.Count < reserved {
+		return fmt.Errorf("%w: %d copies of card %d are reserved by trades", ErrNotAvailable, reserved, cardOwnership.CardID)
+	}
+	return nil
+}
+
+/*
+Function	: Reserved copies
+Description	: Get the copies of a cardOwnership that are reserved by the negotiated trades.
+Parameters 	: DB transaction, CardID
+Return     	: reserved copies, error
+Private
+*/
+func reservedCopies(tx *gorm.DB, cardID uint) (uint, error) {
+	reserved, err := models.GetReservedCounts(tx, []uint{cardID}, 0)
+	return reserved[cardID], err
+}
+
+/*
+Function	: Check trade items available
+Description	: Check that the cards of a trade have enough copies that are not reserved by other trades.
+Parameters 	: DB transaction, TradeID, TradeItem list, CardID -> locked CardOwnership map
+Return     	: error (ErrNotAvailable)
+Private
+*/
+func checkTradeItemsAvailable(tx *gorm.DB, tradeID uint, items []models.TradeItem, cards map[uint]*models.CardOwnership) error {
+	cardIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		cardIDs = append(cardIDs, item.CardID)
+	}
+	reserved, err := models.GetReservedCounts(tx, cardIDs, tradeID)
+	if err != nil {
+		return err
+	}
+	selected := make(map[uint]uint)
+	for _, item := range items {
+		cardOwnership := cards[item.CardID]
+		if cardOwnership == nil {
+			return fmt.Errorf("%w: traded card", ErrNotInCollection)
+		}
+		available := int(cardOwnership.Count) - int(reserved[item.CardID])
+		if available < 0 {
+			available = 0
+		}
+		selected[item.CardID] += item.CardSelect
+		if int(selected[item.CardID]) > available {
+			return fmt.Errorf("%w: %d copies of card %d can be traded", ErrNotAvailable, available, item.CardID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+File		: reservations_test.go
+Description	: Tests of the copies of the cards reserved by the negotiated trades.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestCheckAvailable(t *testing.T) {
+	tests := []struct {
+		count    uint
+		reserved uint
+		ok       bool
+	}{
+		{3, 0, true},
+		{3, 2, true},
+		{3, 3, true}, // All the copies can be reserved
+		{2, 3, false},
+		{0, 1, false},
+	}
+	for _, test := range tests {
+		err := checkAvailable(models.CardOwnership{CardID: 1, Count: test.count}, test.reserved)
+		if test.ok && err != nil {
+			t.Errorf("%d copies, %d reserved: %v", test.count, test.reserved, err)
+		}
+		if !test.ok && !errors.Is(err, ErrNotAvailable) {
+			t.Errorf("%d copies, %d reserved: %v, want ErrNotAvailable", test.count, test.reserved, err)
+		}
+	}
+}
+
+func TestReservations(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	alice, _, tradeID := createTestTrade(t) // Reserves 1 of the 2 bolts of alice
+	carol, _ := createTestUser(t, "carol")
+	offerBolt := func() error {
+		_, err := NewTradeDB(ctx, alice.User_id, models.HoleTrade{
+			Username:     carol.Username,
+			WhatYouTrade: []models.CardSelect{{Card: models.Card{VersionID: boltM10, Condi: "NM"}, Select: 1}},
+		})
+		return err
+	}
+	reservedBolts := func() (int, int) {
+		t.Helper()
+		collection, err := GetCollectionByUserIdDB(ctx, alice.User_id)
+		if err != nil || len(collection) != 1 {
+			t.Fatalf("collection = %+v (%v), want the bolts", collection, err)
+		}
+		return collection[0].Reserved, collection[0].Available
+	}
+
+	if err := offerBolt(); err != nil {
+		t.Fatalf("offering the second bolt: %v", err)
+	}
+	if reserved, available := reservedBolts(); reserved != 2 || available != 0 {
+		t.Errorf("bolts reserved %d, available %d; want 2 and 0", reserved, available)
+	}
+	if err := offerBolt(); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("offering a third bolt: %v, want ErrNotAvailable", err)
+	}
+
+	// The reserved copies can't be removed from the collection
+	cardID, err := models.GetCardIDByParams(models.DB, alice.User_id, boltM10, "", "NM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := uint(1)
+	if _, _, err := ModifyCollectionItemDB(ctx, alice.User_id, cardID, nil, models.CardOwnershipUpdate{Count: &count}); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("keeping 1 bolt: %v, want ErrNotAvailable", err)
+	}
+	if _, err := DeleteCollectionItemDB(ctx, alice.User_id, cardID, nil); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("deleting the bolts: %v, want ErrNotAvailable", err)
+	}
+
+	// The cancelled trades don't reserve their cards
+	if err := CancelTradeDB(ctx, alice.User_id, tradeID); err != nil {
+		t.Fatal(err)
+	}
+	if reserved, available := reservedBolts(); reserved != 1 || available != 1 {
+		t.Errorf("bolts reserved %d, available %d after the cancel; want 1 and 1", reserved, available)
+	}
+	if err := offerBolt(); err != nil {
+		t.Errorf("offering the bolt of the cancelled trade: %v", err)
+	}
+}
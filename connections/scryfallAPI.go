@@ -6,98 +6,404 @@ Description	: File that deals with all the comunication with the Scryfall API.
 package connections
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 
 	"CardaliaAPI/models"
 )
 
-/*
-Function	: Get card by name Scryfall
-Description	: Given a card name with no spaces and commas, the function uses the ScryFall api to return a
-card with all its information.
+// Default Scryfall API address
+const scryfallBaseURL = "https://api.scryfall.com"
 
-Parameters 	: cardName
-Return     	: Card, error
+// Maximum number of identifiers of a /cards/collection request
+const scryfallCollectionLimit = 75
+
+// Card provider that asks the Scryfall API for every card
+type ScryfallProvider struct {
+	BaseURL string
+	client  *scryfallClient
+}
+
+// Card object as returned by Scryfall. It has the extra fields needed to fill the catalog and the prices.
+type scryfallCard struct {
+	models.Card
+	Games      []string       `json:"games"`
+	ReleasedAt string         `json:"released_at"`
+	Prices     scryfallPrices `json:"prices"`
+}
+
+// Prices of a Scryfall card, as decimal strings (null if unknown)
+type scryfallPrices struct {
+	USD     *string `json:"usd"`
+	USDFoil *string `json:"usd_foil"`
+	EUR     *string `json:"eur"`
+	EURFoil *string `json:"eur_foil"`
+	Tix     *string `json:"tix"`
+}
+
+// Card identifier of a /cards/collection request (an ID or a set code with a collector number)
+type scryfallIdentifier struct {
+	ID              string `json:"id,omitempty"`
+	Set             string `json:"set,omitempty"`
+	CollectorNumber string `json:"collector_number,omitempty"`
+}
+
+// Body of a /cards/collection request
+type scryfallCollectionRequest struct {
+	Identifiers []scryfallIdentifier `json:"identifiers"`
+}
+
+// List of Scryfall cards as returned by the search endpoint
+type scryfallCardList struct {
+	Cards    []scryfallCard `json:"data"`
+	HasMore  bool           `json:"has_more"`
+	NextPage string         `json:"next_page"`
+}
+
+/*
+Function	: New Scryfall provider
+Description	: Build a Scryfall provider with its own rate limited client. The API address can be changed with SCRYFALL_URL.
+Parameters 	:
+Return     	: ScryfallProvider
 */
-func GetCardByNameScryfall(cardName string) (models.Card, error) {
-	resp, err := http.Get("https://api.scryfall.com/cards/named?exact=" + models.CleanCardName(cardName))
-	var newCard models.Card
-	if err != nil {
-		return newCard, err
+func NewScryfallProvider() ScryfallProvider {
+	baseURL := os.Getenv("SCRYFALL_URL")
+	if baseURL == "" {
+		baseURL = scryfallBaseURL
 	}
-	defer resp.Body.Close()
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	return ScryfallProvider{BaseURL: baseURL, client: newScryfallClient()}
+}
 
-	// Convert response body to Card struct
-	json.Unmarshal(bodyBytes, &newCard)
-	return newCard, nil
+/*
+Function	: Card by name
+Description	: Given a card name, the function uses the ScryFall api to return a card with all its information.
+Self		: ScryfallProvider
+Parameters 	: context, cardName
+Return     	: Card, error
+*/
+func (p ScryfallProvider) CardByName(ctx context.Context, cardName string) (models.Card, error) {
+	newCard, err := p.getCardByName(ctx, cardName)
+	return newCard.Card, err
 }
 
 /*
-Function	: Get card by ID Scryfall
+Function	: Card by ID
 Description	: Given a cardID, the function uses the ScryFall api to return a card with all its information.
-Parameters 	: cardID
+Self		: ScryfallProvider
+Parameters 	: context, cardID
 Return     	: Card, error
 */
-func GetCardByIDScryfall(ID string) (models.Card, error) {
-	resp, err := http.Get("https://api.scryfall.com/cards/" + ID)
-	var newCard models.Card
+func (p ScryfallProvider) CardByID(ctx context.Context, ID string) (models.Card, error) {
+	newCard, err := p.getCardByID(ctx, ID)
+	return newCard.Card, err
+}
+
+/*
+Function	: Cards by ID
+Description	: Given a list of cardIDs, the function uses the ScryFall api to return all the cards with a request
+for every 75 cards. The IDs that Scryfall doesn't know are not in the map.
+
+Self		: ScryfallProvider
+Parameters 	: context, cardID list
+Return     	: cardID -> Card map, error
+*/
+func (p ScryfallProvider) CardsByID(ctx context.Context, ids []string) (map[string]models.Card, error) {
+	cards := make(map[string]models.Card)
+	newCards, err := p.getCardsByID(ctx, ids)
 	if err != nil {
-		return newCard, err
+		return cards, err
 	}
-	defer resp.Body.Close()
-	bodyBytes, _ := io.ReadAll(resp.Body)
-
-	// Convert response body to Card struct
-	json.Unmarshal(bodyBytes, &newCard)
-	return newCard, nil
+	for _, card := range newCards {
+		cards[card.ID] = card.Card
+	}
+	return cards, nil
 }
 
 /*
-Function	: Get card by Uncompleted Scryfall
-Description	: Given a partial card name, the function uses the ScryFall api to return a card with all its information.
-This API consult can't generate error, only empty lists.
+Function	: Cards by set number
+Description	: Given a list of set codes and collector numbers, the function uses the ScryFall api to return all the cards
+with a request for every 75 cards. The cards that Scryfall doesn't know are not in the map.
 
-Parameters 	: uncompleted cardName
-Return     	: cardName list
+Self		: ScryfallProvider
+Parameters 	: context, SetNumber list
+Return     	: SetNumber -> Card map, error
 */
-func GetCardUncompletedScryfall(un_cardname string) []string {
-	resp, err := http.Get("https://api.scryfall.com/cards/autocomplete?q=" + un_cardname)
+func (p ScryfallProvider) CardsBySetNumber(ctx context.Context, keys []models.SetNumber) (map[models.SetNumber]models.Card, error) {
+	cards := make(map[models.SetNumber]models.Card)
+	newCards, err := p.getCardsBySetNumber(ctx, keys)
 	if err != nil {
-		fmt.Println("Error: ", err)
+		return cards, err
+	}
+	for _, card := range newCards {
+		cards[setNumberOf(card.Card)] = card.Card
 	}
-	defer resp.Body.Close()
+	return cards, nil
+}
+
+/*
+Function	: Autocomplete
+Description	: Given a partial card name, the function uses the ScryFall api to return the names of the cards that match.
+A name with no matches is not an error, only an empty list.
 
+Self		: ScryfallProvider
+Parameters 	: context, uncompleted cardName
+Return     	: cardName list, error
+*/
+func (p ScryfallProvider) Autocomplete(ctx context.Context, un_cardname string) ([]string, error) {
 	var cardlist models.StringCardList
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		json.Unmarshal(bodyBytes, &cardlist)
-	}
-	return cardlist.Cards
+	err := p.client.getJSON(ctx, p.BaseURL+"/cards/autocomplete?q="+url.QueryEscape(un_cardname), &cardlist)
+	return cardlist.Cards, err
 }
 
 /*
-Function	: Get card version Scryfall
+Function	: Printings
 Description	: Given a cardName, the function uses the ScryFall api to return all the paper versions of a card.
-Parameters 	: cardName
+Self		: ScryfallProvider
+Parameters 	: context, cardName
 Return     	: CardVersion list, error
 */
-func GetCardVersionsScryfall(cardname string) ([]models.CardVersion, error) {
-	var cardVersionsList []models.CardVersion
-	resp, err := http.Get("https://api.scryfall.com/cards/search?order=released&q=%21%22" + cardname + "%22+include%3Aextras&unique=prints")
+func (p ScryfallProvider) Printings(ctx context.Context, cardname string) ([]models.CardVersion, error) {
+	cards, err := p.searchPrintings(ctx, cardname)
+	if err != nil {
+		return nil, err
+	}
+	return paperVersions(cards), nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Get card by name
+Description	: Get a Scryfall card by its exact name.
+Self		: ScryfallProvider
+Parameters 	: context, cardName
+Return     	: scryfallCard, error
+Private
+*/
+func (p ScryfallProvider) getCardByName(ctx context.Context, cardName string) (scryfallCard, error) {
+	return p.getCard(ctx, "/cards/named?exact="+url.QueryEscape(models.CleanCardName(cardName)))
+}
+
+/*
+Function	: Get card by ID
+Description	: Get a Scryfall card by its ID.
+Self		: ScryfallProvider
+Parameters 	: context, cardID
+Return     	: scryfallCard, error
+Private
+*/
+func (p ScryfallProvider) getCardByID(ctx context.Context, ID string) (scryfallCard, error) {
+	return p.getCard(ctx, "/cards/"+url.PathEscape(ID))
+}
+
+/*
+Function	: Get cards by ID
+Description	: Get a list of Scryfall cards by their IDs with the /cards/collection endpoint.
+Self		: ScryfallProvider
+Parameters 	: context, cardID list
+Return     	: scryfallCard list, error
+Private
+*/
+func (p ScryfallProvider) getCardsByID(ctx context.Context, ids []string) ([]scryfallCard, error) {
+	identifiers := make([]scryfallIdentifier, 0, len(ids))
+	for _, id := range ids {
+		identifiers = append(identifiers, scryfallIdentifier{ID: id})
+	}
+	return p.getCollection(ctx, identifiers)
+}
+
+/*
+Function	: Get cards by set number
+Description	: Get a list of Scryfall cards by their set code and collector number with the /cards/collection endpoint.
+Self		: ScryfallProvider
+Parameters 	: context, SetNumber list
+Return     	: scryfallCard list, error
+Private
+*/
+func (p ScryfallProvider) getCardsBySetNumber(ctx context.Context, keys []models.SetNumber) ([]scryfallCard, error) {
+	identifiers := make([]scryfallIdentifier, 0, len(keys))
+	for _, key := range keys {
+		identifiers = append(identifiers, scryfallIdentifier{Set: key.Set, CollectorNumber: key.CollectorNumber})
+	}
+	return p.getCollection(ctx, identifiers)
+}
+
+/*
+Function	: Get collection
+Description	: Get a list of Scryfall cards with the /cards/collection endpoint, in groups of 75 identifiers.
+The groups are requested by the worker pool.
+
+Self		: ScryfallProvider
+Parameters 	: context, identifier list
+Return     	: scryfallCard list, error
+Private
+*/
+func (p ScryfallProvider) getCollection(ctx context.Context, identifiers []scryfallIdentifier) ([]scryfallCard, error) {
+	var batches [][]scryfallIdentifier
+	for start := 0; start < len(identifiers); start += scryfallCollectionLimit {
+		end := start + scryfallCollectionLimit
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		batches = append(batches, identifiers[start:end])
+	}
+
+	// Ask for all the batches concurrently
+	results := make([][]scryfallCard, len(batches))
+	err := runPool(ctx, len(batches), func(ctx context.Context, i int) error {
+		batch, err := p.getCollectionBatch(ctx, batches[i])
+		results[i] = batch
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []scryfallCard
+	for _, batch := range results {
+		cards = append(cards, batch...)
+	}
+	return cards, nil
+}
+
+/*
+Function	: Get collection batch
+Description	: Get up to 75 Scryfall cards with a single /cards/collection request.
+Self		: ScryfallProvider
+Parameters 	: context, identifier list
+Return     	: scryfallCard list, error
+Private
+*/
+func (p ScryfallProvider) getCollectionBatch(ctx context.Context, identifiers []scryfallIdentifier) ([]scryfallCard, error) {
+	body := scryfallCollectionRequest{Identifiers: identifiers}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var cardList scryfallCardList
+	err = p.client.doJSON(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/cards/collection", bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}, &cardList)
+	return cardList.Cards, err
+}
+
+/*
+Function	: Get card
+Description	: Get a single card from a Scryfall endpoint.
+Self		: ScryfallProvider
+Parameters 	: context, endpoint path
+Return     	: scryfallCard, error
+Private
+*/
+func (p ScryfallProvider) getCard(ctx context.Context, path string) (scryfallCard, error) {
+	var newCard scryfallCard
+	err := p.client.getJSON(ctx, p.BaseURL+path, &newCard)
+	return newCard, err
+}
+
+/*
+Function	: Search printings
+Description	: Get all the versions of a card (paper and digital) ordered by release date, following all the result pages.
+Self		: ScryfallProvider
+Parameters 	: context, cardName
+Return     	: scryfallCard list, error
+Private
+*/
+func (p ScryfallProvider) searchPrintings(ctx context.Context, cardname string) ([]scryfallCard, error) {
+	query := url.QueryEscape(fmt.Sprintf("!\"%s\" include:extras", cardname))
+	nextPage := p.BaseURL + "/cards/search?order=released&q=" + query + "&unique=prints"
+
+	var cards []scryfallCard
+	for nextPage != "" {
+		var page scryfallCardList
+		if err := p.client.getJSON(ctx, nextPage, &page); err != nil {
+			return nil, err
+		}
+		cards = append(cards, page.Cards...)
+		nextPage = ""
+		if page.HasMore {
+			nextPage = page.NextPage
+		}
+	}
+	return cards, nil
+}
+
+/*
+Function	: To catalog card
+Description	: Convert a Scryfall card to a catalog card.
+Self		: scryfallCard
+Parameters 	:
+Return     	: CatalogCard
+Private
+*/
+func (card scryfallCard) toCatalogCard() models.CatalogCard {
+	return models.NewCatalogCard(card.Card, card.Games, card.ReleasedAt)
+}
+
+/*
+Function	: To card price
+Description	: Get the prices of a Scryfall card. The prices that can't be read are unknown.
+Self		: scryfallCard
+Parameters 	:
+Return     	: CardPrice
+Private
+*/
+func (card scryfallCard) toCardPrice() models.CardPrice {
+	return models.CardPrice{
+		VersionID: card.ID,
+		USD:       parsePrice(card.Prices.USD),
+		USDFoil:   parsePrice(card.Prices.USDFoil),
+		EUR:       parsePrice(card.Prices.EUR),
+		EURFoil:   parsePrice(card.Prices.EURFoil),
+		Tix:       parsePrice(card.Prices.Tix),
+	}
+}
+
+/*
+Function	: Parse price
+Description	: Read a Scryfall price.
+Parameters 	: price (decimal string or nil)
+Return     	: price (nil if unknown)
+Private
+*/
+func parsePrice(price *string) *float64 {
+	if price == nil {
+		return nil
+	}
+	value, err := strconv.ParseFloat(*price, 64)
 	if err != nil {
-		return cardVersionsList, err
+		return nil
 	}
-	defer resp.Body.Close()
+	return &value
+}
 
-	var cardVersionsListRESP models.CardVersionsList
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		json.Unmarshal(bodyBytes, &cardVersionsListRESP)
+/*
+Function	: To card version
+Description	: Convert a Scryfall card to a card version.
+Self		: scryfallCard
+Parameters 	:
+Return     	: CardVersion
+Private
+*/
+func (card scryfallCard) toCardVersion() models.CardVersion {
+	return models.CardVersion{
+		Id:              card.ID,
+		Games:           card.Games,
+		Set:             card.Set,
+		SetName:         card.SetName,
+		CollectorNumber: card.CollectorNumber,
+		ImageURL:        card.ImageURL,
 	}
-	cardVersionsList = models.RemoveDigitalVersions(cardVersionsListRESP.Cards)
-	return cardVersionsList, nil
 }
@@ -0,0 +1,123 @@
+/*
+File		: scryfallBulk.go
+Description	: File that deals with the Scryfall bulk data files (default_cards, all_cards). The file is read as a stream
+and stored in the local card catalog, so the API can work without asking Scryfall. The prices of the cards are also
+stored.
+*/
+
+package connections
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"CardaliaAPI/models"
+)
+
+// Number of cards stored in the catalog in each DB insert
+const bulkBatchSize = 500
+
+// Card object of a bulk file. Only the fields needed to filter the cards are added to the scryfallCard.
+type bulkCard struct {
+	scryfallCard
+	Lang string `json:"lang"`
+}
+
+/*
+Function	: Import Scryfall bulk
+Description	: Read a Scryfall bulk data file (a JSON list of cards) card by card and upsert every card, and its
+prices, in the catalog.
+All the printings of the imported card names are marked as complete. If lang is not empty, only the cards
+in that language are imported (all_cards has a card object for every language).
+
+Parameters 	: bulk file reader, lang
+Return     	: number of cards imported, error
+*/
+func ImportScryfallBulk(r io.Reader, lang string) (int, error) {
+	imported := 0
+	seen := make(map[string]bool)
+	names := []string{}
+	batch := make([]models.CatalogCard, 0, bulkBatchSize)
+	prices := make([]models.CardPrice, 0, bulkBatchSize)
+	err := decodeCardList(r, func(card bulkCard) error {
+		if lang != "" && card.Lang != lang {
+			return nil
+		}
+		batch = append(batch, card.toCatalogCard())
+		prices = append(prices, card.toCardPrice())
+		if !seen[card.Name] {
+			seen[card.Name] = true
+			names = append(names, card.Name)
+		}
+		// Store the batch when it is full
+		if len(batch) == bulkBatchSize {
+			if err := models.SaveCatalogCards(batch); err != nil {
+				return err
+			}
+			if err := models.SaveCardPrices(models.DB, prices); err != nil {
+				return err
+			}
+			imported += len(batch)
+			batch = batch[:0]
+			prices = prices[:0]
+		}
+		return nil
+	})
+	if err != nil {
+		return imported, err
+	}
+	if err := models.SaveCatalogCards(batch); err != nil {
+		return imported, err
+	}
+	if err := models.SaveCardPrices(models.DB, prices); err != nil {
+		return imported, err
+	}
+	imported += len(batch)
+
+	// Every printing of the imported names is now in the catalog
+	return imported, models.MarkCatalogPrintings(names)
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Decode card list
+Description	: Read a JSON list of Scryfall cards one card at a time, so the whole file is never in memory.
+The cards without ID are skipped.
+
+Parameters 	: file reader, function called for every card
+Return     	: error
+Private
+*/
+func decodeCardList(r io.Reader, onCard func(bulkCard) error) error {
+	decoder := json.NewDecoder(r)
+
+	// The file is a JSON list
+	tok, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("card file must be a JSON list of cards")
+	}
+
+	read := 0
+	for decoder.More() {
+		var card bulkCard
+		read++
+		if err := decoder.Decode(&card); err != nil {
+			return fmt.Errorf("card %d: %w", read, err)
+		}
+		if card.ID == "" {
+			continue
+		}
+		if err := onCard(card); err != nil {
+			return err
+		}
+	}
+
+	// Closing bracket of the list
+	_, err = decoder.Token()
+	return err
+}
@@ -0,0 +1,311 @@
+/*
+File		: scryfallClient.go
+Description	: HTTP client used for all the requests to Scryfall. Scryfall asks its clients to stay under 10 requests per
+second, so every request waits for a token of a shared token bucket. Requests that fail because Scryfall is busy or
+down (429, 5xx, network errors) are retried with exponential backoff.
+*/
+
+package connections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default client configuration
+const (
+	defaultScryfallRate       = 8 // Requests per second
+	defaultScryfallBurst      = 4
+	defaultScryfallTimeout    = 10 * time.Second
+	defaultScryfallMaxRetries = 3
+	scryfallBaseBackoff       = 250 * time.Millisecond
+	scryfallMaxBackoff        = 5 * time.Second
+)
+
+// Errors of the card lookups. The routes map them to HTTP status codes.
+var (
+	ErrCardNotFound        = errors.New("card not found")
+	ErrUpstreamUnavailable = errors.New("card service unavailable")
+)
+
+// Client for the Scryfall API
+type scryfallClient struct {
+	http       *http.Client
+	limiter    *tokenBucket
+	maxRetries int
+}
+
+// Error object returned by Scryfall
+type scryfallError struct {
+	Status  int    `json:"status"`
+	Details string `json:"details"`
+}
+
+// Rate limiter. Tokens are added at a constant rate up to the burst size, and every request takes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+/*
+Function	: New Scryfall client
+Description	: Build a Scryfall client from the enviroment variables SCRYFALL_RATE_LIMIT (requests per second),
+SCRYFALL_TIMEOUT_SECONDS and SCRYFALL_MAX_RETRIES.
+
+Parameters 	:
+Return     	: scryfallClient
+Private
+*/
+func newScryfallClient() *scryfallClient {
+	rate := envInt("SCRYFALL_RATE_LIMIT", defaultScryfallRate)
+	burst := defaultScryfallBurst
+	if rate < burst {
+		burst = rate
+	}
+	timeout := defaultScryfallTimeout
+	if seconds := envInt("SCRYFALL_TIMEOUT_SECONDS", 0); seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	return &scryfallClient{
+		http:       &http.Client{Timeout: timeout},
+		limiter:    newTokenBucket(float64(rate), float64(burst)),
+		maxRetries: envInt("SCRYFALL_MAX_RETRIES", defaultScryfallMaxRetries),
+	}
+}
+
+/*
+Function	: Get JSON
+Description	: Send a GET request to Scryfall and decode the JSON response.
+Self		: scryfallClient
+Parameters 	: context, url, object to fill
+Return     	: error (ErrCardNotFound, ErrUpstreamUnavailable or a Scryfall error)
+Private
+*/
+func (c *scryfallClient) getJSON(ctx context.Context, url string, out interface{}) error {
+	return c.doJSON(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}, out)
+}
+
+/*
+Function	: Do JSON
+Description	: Send a request to Scryfall, retrying it while Scryfall is busy or down, and decode the JSON response.
+A new request is built for every attempt, so the body can be sent again. The waits stop if the context is cancelled.
+
+Self		: scryfallClient
+Parameters 	: context, request builder, object to fill
+Return     	: error (ErrCardNotFound, ErrUpstreamUnavailable, a Scryfall error or the context error)
+Private
+*/
+func (c *scryfallClient) doJSON(ctx context.Context, newRequest func() (*http.Request, error), out interface{}) error {
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(attempt, lastErr)); err != nil {
+				return err
+			}
+		}
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := c.http.Do(req)
+		if ctx.Err() != nil {
+			// The request was cancelled, don't retry it
+			return ctx.Err()
+		}
+		if err != nil {
+			// Network error or timeout
+			lastErr = err
+			continue
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			if err := json.Unmarshal(bodyBytes, out); err != nil {
+				return fmt.Errorf("%w: invalid response: %v", ErrUpstreamUnavailable, err)
+			}
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrCardNotFound
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = retryAfterError{status: resp.StatusCode, wait: retryAfter(resp)}
+			continue
+		default:
+			var scryErr scryfallError
+			json.Unmarshal(bodyBytes, &scryErr)
+			if scryErr.Details == "" {
+				scryErr.Details = resp.Status
+			}
+			return fmt.Errorf("scryfall: %s", scryErr.Details)
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, lastErr)
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+// Error of a response that can be retried. Keeps the time Scryfall asked to wait.
+type retryAfterError struct {
+	status int
+	wait   time.Duration
+}
+
+func (e retryAfterError) Error() string {
+	return fmt.Sprintf("scryfall responded %d %s", e.status, http.StatusText(e.status))
+}
+
+/*
+Function	: Backoff
+Description	: Time to wait before a retry. Doubles on every attempt, unless Scryfall asked for a specific time.
+Parameters 	: attempt number, error of the last attempt
+Return     	: Duration
+Private
+*/
+func backoff(attempt int, lastErr error) time.Duration {
+	var retryErr retryAfterError
+	if errors.As(lastErr, &retryErr) && retryErr.wait > 0 {
+		return retryErr.wait
+	}
+	wait := time.Duration(float64(scryfallBaseBackoff) * math.Pow(2, float64(attempt-1)))
+	if wait > scryfallMaxBackoff {
+		wait = scryfallMaxBackoff
+	}
+	return wait
+}
+
+/*
+Function	: Retry after
+Description	: Read the Retry-After header (in seconds) of a response.
+Parameters 	: response
+Return     	: Duration (0 if missing)
+Private
+*/
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > scryfallMaxBackoff {
+		wait = scryfallMaxBackoff
+	}
+	return wait
+}
+
+/*
+Function	: New token bucket
+Description	: Build a full token bucket.
+Parameters 	: tokens per second, burst size
+Return     	: tokenBucket
+Private
+*/
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if rate <= 0 {
+		rate = defaultScryfallRate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+/*
+Function	: Wait
+Description	: Block until a token is available and take it, or until the context is cancelled.
+Self		: tokenBucket
+Parameters 	: context
+Return     	: error (the context error)
+Private
+*/
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := b.take()
+		if delay == 0 {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+Function	: Take
+Description	: Take a token if there is one.
+Self		: tokenBucket
+Parameters 	:
+Return     	: 0 if a token was taken, the time until the next token otherwise
+Private
+*/
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Refill the bucket with the tokens generated since the last call
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+/*
+Function	: Sleep
+Description	: Wait for a time, or until the context is cancelled.
+Parameters 	: context, time to wait
+Return     	: error (the context error)
+Private
+*/
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+Function	: Env int
+Description	: Read an integer from an enviroment variable.
+Parameters 	: variable name, default value
+Return     	: value
+Private
+*/
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,165 @@
+/*
+File		: scryfallClient_test.go
+Description	: Tests of the rate limiter and the retries of the Scryfall client.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	bucket := newTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if delay := bucket.take(); delay != 0 {
+			t.Fatalf("take %d of the burst: waited %v", i+1, delay)
+		}
+	}
+	delay := bucket.take()
+	if delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("empty bucket: got %v, want (0, 100ms]", delay)
+	}
+
+	// Refilled at the rate, never above the burst
+	bucket.last = time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		if delay := bucket.take(); delay != 0 {
+			t.Fatalf("take %d after the refill: waited %v", i+1, delay)
+		}
+	}
+	if delay := bucket.take(); delay == 0 {
+		t.Errorf("the bucket was refilled above its burst")
+	}
+}
+
+func TestNewTokenBucket(t *testing.T) {
+	tests := []struct {
+		rate, burst         float64
+		wantRate, wantBurst float64
+	}{
+		{8, 4, 8, 4},
+		{0, 4, defaultScryfallRate, 4},
+		{-1, 0, defaultScryfallRate, 1},
+		{2, 0.5, 2, 1},
+	}
+	for _, test := range tests {
+		bucket := newTokenBucket(test.rate, test.burst)
+		if bucket.rate != test.wantRate || bucket.burst != test.wantBurst || bucket.tokens != test.wantBurst {
+			t.Errorf("newTokenBucket(%v, %v) = rate %v burst %v tokens %v, want rate %v burst %v", test.rate, test.burst,
+				bucket.rate, bucket.burst, bucket.tokens, test.wantRate, test.wantBurst)
+		}
+	}
+}
+
+func TestTokenBucketWaitCancelled(t *testing.T) {
+	bucket := newTokenBucket(0.001, 1)
+	bucket.take()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bucket.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		lastErr error
+		want    time.Duration
+	}{
+		{1, nil, 250 * time.Millisecond},
+		{2, errors.New("timeout"), 500 * time.Millisecond},
+		{3, nil, time.Second},
+		{5, nil, 4 * time.Second},
+		{6, nil, scryfallMaxBackoff},
+		{20, nil, scryfallMaxBackoff},
+		{1, retryAfterError{status: 429, wait: 3 * time.Second}, 3 * time.Second},
+		{4, retryAfterError{status: 503}, 2 * time.Second},
+		{1, fmt.Errorf("wrapped: %w", retryAfterError{status: 429, wait: time.Second}), time.Second},
+	}
+	for _, test := range tests {
+		if got := backoff(test.attempt, test.lastErr); got != test.want {
+			t.Errorf("backoff(%d, %v) = %v, want %v", test.attempt, test.lastErr, got, test.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"2", 2 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"60", scryfallMaxBackoff},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+		if got := retryAfter(resp); got != test.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestDoJSONRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int // Status of every request, the last one is repeated
+		maxRetries int
+		wantErr    string
+		wantCalls  int
+	}{
+		{"ok", []int{200}, 2, "", 1},
+		{"busy then ok", []int{429, 200}, 2, "", 2},
+		{"down then ok", []int{503, 502, 200}, 2, "", 3},
+		{"always down", []int{500}, 1, "card service unavailable: scryfall responded 500 Internal Server Error", 2},
+		{"not found", []int{404}, 2, ErrCardNotFound.Error(), 1},
+		{"bad request", []int{400}, 2, "scryfall: failed", 1},
+	}
+	for _, test := range tests {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := test.statuses[len(test.statuses)-1]
+			if calls < len(test.statuses) {
+				status = test.statuses[calls]
+			}
+			calls++
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				fmt.Fprint(w, `{"name": "Lightning Bolt"}`)
+			} else {
+				fmt.Fprintf(w, `{"status": %d, "details": "failed"}`, status)
+			}
+		}))
+		client := &scryfallClient{http: srv.Client(), limiter: newTokenBucket(1000, 10), maxRetries: test.maxRetries}
+
+		var out struct{ Name string }
+		err := client.getJSON(context.Background(), srv.URL, &out)
+		srv.Close()
+
+		switch {
+		case err != nil && err.Error() != test.wantErr:
+			t.Errorf("%s: got error %q, want %q", test.name, err, test.wantErr)
+		case err == nil && test.wantErr != "":
+			t.Errorf("%s: got no error, want %q", test.name, test.wantErr)
+		case err == nil && out.Name != "Lightning Bolt":
+			t.Errorf("%s: got %+v", test.name, out)
+		}
+		if calls != test.wantCalls {
+			t.Errorf("%s: %d requests, want %d", test.name, calls, test.wantCalls)
+		}
+	}
+}
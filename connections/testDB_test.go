@@ -0,0 +1,141 @@
+/*
+File		: testDB_test.go
+Description	: Helper of the tests that need a database. They run against the MySQL database of TEST_DB_URL (a DSN like
+"user:password@tcp(localhost:3306)/cardalia_test?parseTime=True&loc=Local"), and are skipped if it is not set. Every
+test starts with empty tables, so never point it to a database with real data.
+*/
+
+package connections
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Schema of the database. The tests create their tables with it, so they run on the same tables as the API.
+const schemaFile = "../create-cardalia-db-heroku.sql"
+
+/*
+Function	: Use test DB
+Description	: Use the database of TEST_DB_URL, with new empty tables, as models.DB. The DB is closed at the end of the
+test. The test is skipped if TEST_DB_URL is not set.
+
+Parameters 	: test
+Return     	:
+*/
+func useTestDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	db, err := gorm.Open(mysql.Open(url), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connecting to the test DB: %v", err)
+	}
+	if err := createTestTables(db); err != nil {
+		t.Fatalf("creating the test tables: %v", err)
+	}
+	models.DB = db
+	t.Cleanup(func() {
+		// The webhooks and emails of the events are sent in the background with models.DB. The webhooks are waited
+		// for, and the emails still running fail with the closed DB (models.DB is not set back to nil).
+		WaitWebhooks()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+/*
+Function	: Create test user
+Description	: Create a user with a collection in the test DB.
+Parameters 	: test, username, CardOwnership list (their User_id is set)
+Return     	: User, CardID list (in the order of the CardOwnerships)
+*/
+func createTestUser(t *testing.T, username string, cardOwnerships ...models.CardOwnership) (models.User, []uint) {
+	t.Helper()
+	user := models.User{Username: username, Email: username + "@example.com", Password: "-"}
+	if err := models.DB.Create(&user).Error; err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	var cardIDs []uint
+	for _, cardDB := range cardOwnerships {
+		cardDB.User_id = user.User_id
+		if err := models.DB.Create(&cardDB).Error; err != nil {
+			t.Fatalf("creating a card of %s: %v", username, err)
+		}
+		cardIDs = append(cardIDs, cardDB.CardID)
+	}
+	return user, cardIDs
+}
+
+/*
+Function	: Create test tables
+Description	: Drop all the tables of the test DB and create them again with the schema file. The statements that
+create or select the database of the schema are skipped, the tables are created in the test DB.
+
+Parameters 	: DB
+Return     	: error
+Private
+*/
+func createTestTables(db *gorm.DB) error {
+	schema, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return err
+	}
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := conn.Migrator().DropTable(table); err != nil {
+				return err
+			}
+		}
+		if err := conn.Exec("SET FOREIGN_KEY_CHECKS = 1").Error; err != nil {
+			return err
+		}
+		for _, statement := range strings.Split(string(schema), ";") {
+			statement = strings.TrimSpace(statement)
+			if !strings.HasPrefix(statement, "CREATE TABLE") {
+				continue
+			}
+			if err := conn.Exec(statement).Error; err != nil {
+				return fmt.Errorf("%w in %.40q", err, statement)
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Function	: Test collection
+Description	: Read the collection of a user from the test DB.
+Parameters 	: test, userID
+Return     	: "VersionID/extras/condition" -> count map
+*/
+func testCollection(t *testing.T, userID uint) map[string]uint {
+	t.Helper()
+	var cardOwnerships []models.CardOwnership
+	if err := models.DB.Where("user_id = ?", userID).Find(&cardOwnerships).Error; err != nil {
+		t.Fatalf("reading the collection of %d: %v", userID, err)
+	}
+	collection := map[string]uint{}
+	for _, cardDB := range cardOwnerships {
+		collection[cardDB.VersionID+"/"+cardDB.Extras+"/"+cardDB.Condi] += cardDB.Count
+	}
+	return collection
+}
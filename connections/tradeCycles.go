@@ -0,0 +1,673 @@
+/*
+File		: tradeCycles.go
+Description	: File that deals with the trade cycles: trades that are impossible between two users but work as a ring
+(A gives to B, B gives to C and C gives to A). The matching job (FindTradeCyclesDB) builds a graph where every user
+points to the users that want his cards, finds short rings in it and proposes every ring as linked trades, one for
+each user and the next one. The users accept or decline the cycle, and its trades are only accepted when all of them
+accepted it.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Returned when a trade cycle doesn't exist or the user is not in it
+var ErrCycleNotFound = errors.New("trade cycle not found")
+
+// Users of the rings found by the matching job
+const (
+	minCycleUsers = 3 // Two users can trade without a cycle
+	maxCycleUsers = 5
+)
+
+// Data of the events of the trade cycles
+type cycleEvent struct {
+	CycleID uint   `json:"cycle_id"`
+	State   string `json:"state"`
+}
+
+/*
+Function	: Find trade cycles
+Description	: Find rings of users that can trade their wanted cards and propose them as trade cycles. The shorter rings
+are proposed first, and a user is only in one proposed cycle at a time, so the cycles don't compete for the same copies.
+The rings that were already proposed or declined are not proposed again. Run it periodically (find-cycles command).
+
+Parameters 	: context
+Return     	: number of cycles proposed, error
+*/
+func FindTradeCyclesDB(ctx context.Context) (int, error) {
+	if err := expireTradeCycles(ctx); err != nil {
+		return 0, err
+	}
+
+	// The users of the proposed cycles, and the rings that are not proposed again
+	var busyUsers []uint
+	err := models.DB.WithContext(ctx).Model(&models.TradeCycleMember{}).
+		Joins("JOIN trade_cycles ON trade_cycles.cycle_id = trade_cycle_members.cycle_id").
+		Where("trade_cycles.state = ?", models.CycleProposed).Pluck("trade_cycle_members.user_id", &busyUsers).Error
+	if err != nil {
+		return 0, err
+	}
+	used := map[uint]bool{}
+	for _, user := range busyUsers {
+		used[user] = true
+	}
+	var oldRings []string
+	err = models.DB.WithContext(ctx).Model(&models.TradeCycle{}).
+		Where("state IN ?", []string{models.CycleProposed, models.CycleDeclined}).Pluck("ring", &oldRings).Error
+	if err != nil {
+		return 0, err
+	}
+	skip := map[string]bool{}
+	for _, ring := range oldRings {
+		skip[ring] = true
+	}
+
+	gives, err := buildWantGraph(ctx, used)
+	if err != nil {
+		return 0, err
+	}
+	var users []uint
+	for user := range gives {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+
+	proposed := 0
+	for length := minCycleUsers; length <= maxCycleUsers; length++ {
+		for _, start := range users {
+			if used[start] {
+				continue
+			}
+			ring := findRing(gives, []uint{start}, length, used, skip)
+			if ring == nil {
+				continue
+			}
+			skip[ringKey(ring)] = true
+			cycle, err := proposeTradeCycle(ctx, ring, gives)
+			if errors.Is(err, ErrNotAvailable) {
+				// The copies were reserved by a trade after the graph was built
+				log.Println("trade cycles:", err)
+				continue
+			}
+			if err != nil {
+				return proposed, err
+			}
+			for _, user := range ring {
+				used[user] = true
+			}
+			publishEvent(events.CycleProposed, cycleEvent{CycleID: cycle.CycleID, State: cycle.State}, ring...)
+			proposed++
+		}
+	}
+	return proposed, nil
+}
+
+/*
+Function	: Get trade cycles
+Description	: Get the trade cycles of the user, the newest first.
+Parameters 	: context, userID
+Return     	: HoleTradeCycle list, error
+*/
+func GetTradeCyclesDB(ctx context.Context, userID uint) ([]models.HoleTradeCycle, error) {
+	if err := expireTradeCycles(ctx); err != nil {
+		return []models.HoleTradeCycle{}, err
+	}
+	var cycles []models.TradeCycle
+	err := models.DB.WithContext(ctx).
+		Where("cycle_id IN (?)", models.DB.Model(&models.TradeCycleMember{}).Select("cycle_id").Where("user_id = ?", userID)).
+		Order("cycle_id DESC").Find(&cycles).Error
+	if err != nil {
+		return []models.HoleTradeCycle{}, err
+	}
+	return buildHoleTradeCycles(ctx, userID, cycles)
+}
+
+/*
+Function	: Get trade cycle
+Description	: Get a trade cycle of the user.
+Parameters 	: context, userID, CycleID
+Return     	: HoleTradeCycle, error
+*/
+func GetTradeCycleDB(ctx context.Context, userID uint, cycleID uint) (models.HoleTradeCycle, error) {
+	if err := expireTradeCycles(ctx); err != nil {
+		return models.HoleTradeCycle{}, err
+	}
+	cycle, _, err := getUserCycle(models.DB.WithContext(ctx), userID, cycleID)
+	if err != nil {
+		return models.HoleTradeCycle{}, err
+	}
+	holeCycles, err := buildHoleTradeCycles(ctx, userID, []models.TradeCycle{cycle})
+	if err != nil {
+		return models.HoleTradeCycle{}, err
+	}
+	return holeCycles[0], nil
+}
+
+/*
+Function	: Answer trade cycle
+Description	: Accept or decline a proposed trade cycle of the user. When the last user accepts it, all the trades of the
+cycle are accepted at once and the traded copies are removed from the collections. If a user declines it, all its
+trades are cancelled.
+
+Parameters 	: context, userID, CycleID, answer (accepted or declined)
+Return     	: error
+*/
+func AnswerTradeCycleDB(ctx context.Context, userID uint, cycleID uint, answer string) error {
+	if err := expireTradeCycles(ctx); err != nil {
+		return err
+	}
+	var cycle models.TradeCycle
+	var members []models.TradeCycleMember
+	var trades []models.Trade
+	var oldStates []string
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the cycle before reading the answers, so the last answer always sees the other ones
+		_, err := models.GetTradeCycleForUpdate(tx, cycleID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if cycle, members, err = getUserCycle(tx, userID, cycleID); err != nil {
+			return err
+		}
+		if cycle.State != models.CycleProposed {
+			return fmt.Errorf("%w: a %s trade cycle can't be answered", ErrIllegalTransition, cycle.State)
+		}
+		accepted := 0
+		for i := range members {
+			if members[i].UserID == userID {
+				if members[i].Answer != "" {
+					return fmt.Errorf("%w: the trade cycle was already %s", ErrIllegalTransition, members[i].Answer)
+				}
+				now := time.Now()
+				members[i].Answer, members[i].AnsweredAt = answer, &now
+				if err := tx.Save(&members[i]).Error; err != nil {
+					return err
+				}
+			}
+			if members[i].Answer == models.CycleAnswerAccepted {
+				accepted++
+			}
+		}
+
+		if trades, err = getCycleTrades(tx, cycle.CycleID); err != nil {
+			return err
+		}
+		for _, trade := range trades {
+			oldStates = append(oldStates, trade.State)
+		}
+		switch {
+		case answer == models.CycleAnswerDeclined:
+			return closeTradeCycle(tx, &cycle, trades, userID, models.CycleDeclined, models.TradeCancelled)
+		case accepted == len(members):
+			return commitTradeCycle(tx, &cycle, trades, members, userID)
+		default:
+			// Mark the acceptance in the trades of the user
+			for i := range trades {
+				if trades[i].IsParticipant(userID) {
+					_, heChecked := trades[i].Checks(userID)
+					trades[i].SetChecks(userID, true, heChecked)
+					if err := tx.Save(&trades[i]).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var ring []uint
+	for _, member := range members {
+		ring = append(ring, member.UserID)
+	}
+	if cycle.State == models.CycleProposed {
+		publishEvent(events.CycleAnswered, cycleEvent{CycleID: cycle.CycleID, State: cycle.State}, ring...)
+		return nil
+	}
+	publishEvent(events.CycleState, cycleEvent{CycleID: cycle.CycleID, State: cycle.State}, ring...)
+	for i := range trades {
+		publishTradeEvents(&trades[i], userID, oldStates[i], trades[i].Revision)
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Build want graph
+Description	: Build the graph of the users that can give wanted cards to other users. Only the users with a wantlist
+can be in a ring, because every user of a ring also gets cards.
+
+Parameters 	: context, userIDs that can't be in the graph
+Return     	: giver -> receiver -> wantedCard list map, error
+Private
+*/
+func buildWantGraph(ctx context.Context, excluded map[uint]bool) (map[uint]map[uint][]wantedCard, error) {
+	gives := map[uint]map[uint][]wantedCard{}
+	var wantlists []models.WantlistItem
+	if err := models.DB.WithContext(ctx).Order("user_id, want_id").Find(&wantlists).Error; err != nil {
+		return gives, err
+	}
+	userWantlists := map[uint][]models.WantlistItem{}
+	for _, want := range wantlists {
+		if !excluded[want.UserID] {
+			userWantlists[want.UserID] = append(userWantlists[want.UserID], want)
+		}
+	}
+	for receiver, wantlist := range userWantlists {
+		givers, offers, err := usersWithWantedCards(ctx, receiver, wantlist)
+		if err != nil {
+			return gives, err
+		}
+		for _, giver := range givers {
+			if _, ok := userWantlists[giver]; !ok {
+				continue
+			}
+			if gives[giver] == nil {
+				gives[giver] = map[uint][]wantedCard{}
+			}
+			gives[giver][receiver] = offers[giver]
+		}
+	}
+	return gives, nil
+}
+
+/*
+Function	: Find ring
+Description	: Find a ring of a number of users that starts with a path of the graph. To find every ring only once, the
+first user of a ring is its lowest userID.
+
+Parameters 	: giver -> receiver graph, path (the first user is the start), users of the ring, used userIDs, rings to skip
+Return     	: ring (nil if there is none)
+Private
+*/
+func findRing(gives map[uint]map[uint][]wantedCard, path []uint, length int, used map[uint]bool, skip map[string]bool) []uint {
+	last := path[len(path)-1]
+	if len(path) == length {
+		if _, ok := gives[last][path[0]]; ok && !skip[ringKey(path)] {
+			return path
+		}
+		return nil
+	}
+	var receivers []uint
+	for receiver := range gives[last] {
+		if receiver > path[0] && !used[receiver] && !inRing(path, receiver) {
+			receivers = append(receivers, receiver)
+		}
+	}
+	sort.Slice(receivers, func(i, j int) bool { return receivers[i] < receivers[j] })
+	for _, receiver := range receivers {
+		next := append(append([]uint{}, path...), receiver)
+		if ring := findRing(gives, next, length, used, skip); ring != nil {
+			return ring
+		}
+	}
+	return nil
+}
+
+/*
+Function	: In ring
+Description	: Check if a user is in a path.
+Parameters 	: path, userID
+Return     	: bool
+Private
+*/
+func inRing(path []uint, userID uint) bool {
+	for _, user := range path {
+		if user == userID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Function	: Ring key
+Description	: Build the key of a ring that is saved with its cycle (the userIDs in order, separated by ">").
+Parameters 	: ring
+Return     	: key
+Private
+*/
+func ringKey(ring []uint) string {
+	users := make([]string, 0, len(ring))
+	for _, user := range ring {
+		users = append(users, strconv.FormatUint(uint64(user), 10))
+	}
+	return strings.Join(users, ">")
+}
+
+/*
+Function	: Propose trade cycle
+Description	: Save a ring as a proposed trade cycle with a trade for every user and the next one, where the user gives
+the wanted cards. The copies of the trades are reserved like in any negotiated trade.
+
+Parameters 	: context, ring, giver -> receiver graph
+Return     	: TradeCycle, error (ErrNotAvailable if the copies were reserved)
+Private
+*/
+func proposeTradeCycle(ctx context.Context, ring []uint, gives map[uint]map[uint][]wantedCard) (models.TradeCycle, error) {
+	cycle := models.TradeCycle{State: models.CycleProposed, Ring: ringKey(ring)}
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&cycle).Error; err != nil {
+			return err
+		}
+		if err := lockUserCollections(tx, ring); err != nil {
+			return err
+		}
+		for position, giver := range ring {
+			receiver := ring[(position+1)%len(ring)]
+			member := models.TradeCycleMember{CycleID: cycle.CycleID, UserID: giver, Position: position}
+			if err := tx.Create(&member).Error; err != nil {
+				return err
+			}
+
+			// The receiver asks the cards of the giver, as if he started the trade
+			trade := models.Trade{UserIdOrigin: receiver, UserIdOwner: giver, State: models.TradeProposed, CycleID: cycle.CycleID}
+			if err := tx.Create(&trade).Error; err != nil {
+				return err
+			}
+			transition := models.TradeTransition{TradeID: trade.TradeID, ToState: models.TradeProposed}
+			if err := tx.Create(&transition).Error; err != nil {
+				return err
+			}
+			var items []models.TradeItem
+			var cardIDs []uint
+			for _, offer := range gives[giver][receiver] {
+				items = append(items, models.TradeItem{
+					TradeID:    trade.TradeID,
+					CardID:     offer.card.CardID,
+					UserID:     giver,
+					CardSelect: offer.copies,
+				})
+				cardIDs = append(cardIDs, offer.card.CardID)
+			}
+			cards, err := lockCards(tx, cardIDs)
+			if err != nil {
+				return err
+			}
+			if err := checkTradeItemsAvailable(tx, trade.TradeID, items, cards); err != nil {
+				return err
+			}
+			if err := tx.Create(&items).Error; err != nil {
+				return err
+			}
+			if err := saveRevision(tx, &trade, 0, items); err != nil {
+				return err
+			}
+			if err := tx.Save(&trade).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return cycle, err
+}
+
+/*
+Function	: Commit trade cycle
+Description	: Accept all the trades of a cycle that all its users accepted, removing the traded copies from the
+collections, and close the cycle.
+
+Parameters 	: DB transaction, locked TradeCycle, locked Trade list of the cycle, TradeCycleMember list, userID of the last answer
+Return     	: error
+Private
+*/
+func commitTradeCycle(tx *gorm.DB, cycle *models.TradeCycle, trades []models.Trade, members []models.TradeCycleMember, userID uint) error {
+	var users []uint
+	for _, member := range members {
+		users = append(users, member.UserID)
+	}
+	if err := lockUserCollections(tx, users); err != nil {
+		return err
+	}
+	var tradeIDs []uint
+	for _, trade := range trades {
+		tradeIDs = append(tradeIDs, trade.TradeID)
+	}
+	items, err := models.GetTradeItems(tx, tradeIDs)
+	if err != nil {
+		return err
+	}
+	var cardIDs []uint
+	for _, tradeItems := range items {
+		for _, item := range tradeItems {
+			cardIDs = append(cardIDs, item.CardID)
+		}
+	}
+	cards, err := lockCards(tx, cardIDs)
+	if err != nil {
+		return err
+	}
+	for i := range trades {
+		trades[i].OriginChecked, trades[i].OwnerChecked = true, true
+		if err := acceptIfAgreed(tx, &trades[i], userID, items[trades[i].TradeID], cards); err != nil {
+			return err
+		}
+		if err := tx.Save(&trades[i]).Error; err != nil {
+			return err
+		}
+	}
+	return closeCycle(tx, cycle, models.CycleAccepted)
+}
+
+/*
+Function	: Close trade cycle
+Description	: Close a trade cycle that will not be accepted, moving all its trades to a final state.
+Parameters 	: DB transaction, locked TradeCycle, locked Trade list of the cycle, userID (0 for the API), cycle state, trade state
+Return     	: error
+Private
+*/
+func closeTradeCycle(tx *gorm.DB, cycle *models.TradeCycle, trades []models.Trade, userID uint, state string, tradeState string) error {
+	for i := range trades {
+		if err := transitionTrade(tx, &trades[i], userID, tradeState); err != nil {
+			return err
+		}
+		if err := tx.Save(&trades[i]).Error; err != nil {
+			return err
+		}
+	}
+	return closeCycle(tx, cycle, state)
+}
+
+/*
+Function	: Close cycle
+Description	: Save the final state of a trade cycle.
+Parameters 	: DB transaction, TradeCycle, state
+Return     	: error
+Private
+*/
+func closeCycle(tx *gorm.DB, cycle *models.TradeCycle, state string) error {
+	now := time.Now()
+	cycle.State, cycle.ClosedAt = state, &now
+	return tx.Save(cycle).Error
+}
+
+/*
+Function	: Expire trade cycles
+Description	: Expire the proposed trade cycles that were not accepted by all their users in tradeExpiration.
+Parameters 	: context
+Return     	: error
+Private
+*/
+func expireTradeCycles(ctx context.Context) error {
+	var cycles []models.TradeCycle
+	err := models.DB.WithContext(ctx).Where("state = ? AND created_at < ?", models.CycleProposed, time.Now().Add(-tradeExpiration)).
+		Find(&cycles).Error
+	if err != nil {
+		return err
+	}
+	for _, expired := range cycles {
+		var cycle models.TradeCycle
+		var trades []models.Trade
+		var oldStates []string
+		err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			if cycle, err = models.GetTradeCycleForUpdate(tx, expired.CycleID); err != nil {
+				return err
+			}
+			if cycle.State != models.CycleProposed {
+				return nil
+			}
+			if trades, err = getCycleTrades(tx, cycle.CycleID); err != nil {
+				return err
+			}
+			for _, trade := range trades {
+				oldStates = append(oldStates, trade.State)
+			}
+			return closeTradeCycle(tx, &cycle, trades, 0, models.CycleExpired, models.TradeExpired)
+		})
+		if err != nil {
+			return err
+		}
+		if cycle.State != models.CycleExpired {
+			continue
+		}
+		var users []uint
+		for i := range trades {
+			users = append(users, trades[i].UserIdOwner)
+			publishTradeEvents(&trades[i], 0, oldStates[i], trades[i].Revision)
+		}
+		publishEvent(events.CycleState, cycleEvent{CycleID: cycle.CycleID, State: cycle.State}, users...)
+	}
+	return nil
+}
+
+/*
+Function	: Get user cycle
+Description	: Get a trade cycle of a user and its members.
+Parameters 	: DB transaction, userID, CycleID
+Return     	: TradeCycle, TradeCycleMember list, error (ErrCycleNotFound if it doesn't exist or the user is not in it)
+Private
+*/
+func getUserCycle(tx *gorm.DB, userID uint, cycleID uint) (models.TradeCycle, []models.TradeCycleMember, error) {
+	var cycle models.TradeCycle
+	err := tx.First(&cycle, cycleID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cycle, nil, fmt.Errorf("%w: %d", ErrCycleNotFound, cycleID)
+	}
+	if err != nil {
+		return cycle, nil, err
+	}
+	members, err := models.GetCycleMembers(tx, []uint{cycleID})
+	if err != nil {
+		return cycle, nil, err
+	}
+	for _, member := range members[cycleID] {
+		if member.UserID == userID {
+			return cycle, members[cycleID], nil
+		}
+	}
+	return cycle, nil, fmt.Errorf("%w: %d", ErrCycleNotFound, cycleID)
+}
+
+/*
+Function	: Get cycle trades
+Description	: Get the trades of a trade cycle, locking them until the end of the transaction.
+Parameters 	: DB transaction, CycleID
+Return     	: Trade list, error
+Private
+*/
+func getCycleTrades(tx *gorm.DB, cycleID uint) ([]models.Trade, error) {
+	var trades []models.Trade
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cycle_id = ?", cycleID).Order("trade_id").Find(&trades).Error
+	return trades, err
+}
+
+/*
+Function	: Lock user collections
+Description	: Lock the collections of a list of users until the end of the transaction, ordered by userID.
+Parameters 	: DB transaction, userID list
+Return     	: error
+Private
+*/
+func lockUserCollections(tx *gorm.DB, users []uint) error {
+	sorted := append([]uint{}, users...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, user := range sorted {
+		if _, err := models.LockCollectionVersion(tx, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Function	: Build hole trade cycles
+Description	: Build the view of a list of trade cycles for one of their users, with the trades of the user.
+Parameters 	: context, userID, TradeCycle list
+Return     	: HoleTradeCycle list (same order), error
+Private
+*/
+func buildHoleTradeCycles(ctx context.Context, userAsking uint, cycles []models.TradeCycle) ([]models.HoleTradeCycle, error) {
+	holeCycles := []models.HoleTradeCycle{}
+	if len(cycles) == 0 {
+		return holeCycles, nil
+	}
+	var cycleIDs []uint
+	for _, cycle := range cycles {
+		cycleIDs = append(cycleIDs, cycle.CycleID)
+	}
+	members, err := models.GetCycleMembers(models.DB.WithContext(ctx), cycleIDs)
+	if err != nil {
+		return holeCycles, err
+	}
+	var users []uint
+	for _, cycleMembers := range members {
+		for _, member := range cycleMembers {
+			users = append(users, member.UserID)
+		}
+	}
+	usernames, err := getUsernames(ctx, models.RemoveDuplicate(users))
+	if err != nil {
+		return holeCycles, err
+	}
+	var trades []models.Trade
+	err = models.DB.WithContext(ctx).Where("cycle_id IN ? AND (user_id_origin = ? OR user_id_owner = ?)", cycleIDs, userAsking, userAsking).
+		Order("trade_id").Find(&trades).Error
+	if err != nil {
+		return holeCycles, err
+	}
+	holeTrades, err := buildHoleTrades(ctx, userAsking, trades)
+	if err != nil {
+		return holeCycles, err
+	}
+
+	for _, cycle := range cycles {
+		holeCycle := models.HoleTradeCycle{
+			CycleID:   cycle.CycleID,
+			State:     cycle.State,
+			Members:   []models.CycleMemberView{},
+			Trades:    []models.HoleTrade{},
+			CreatedAt: cycle.CreatedAt,
+			ClosedAt:  cycle.ClosedAt,
+		}
+		for _, member := range members[cycle.CycleID] {
+			holeCycle.Members = append(holeCycle.Members, models.CycleMemberView{Username: usernames[member.UserID], Answer: member.Answer})
+		}
+		for _, holeTrade := range holeTrades {
+			if holeTrade.CycleID == cycle.CycleID {
+				holeCycle.Trades = append(holeCycle.Trades, holeTrade)
+			}
+		}
+		holeCycles = append(holeCycles, holeCycle)
+	}
+	return holeCycles, nil
+}
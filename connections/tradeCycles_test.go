@@ -0,0 +1,80 @@
+/*
+File		: tradeCycles_test.go
+Description	: Tests of the search of rings of users in the graph of the wanted cards.
+*/
+
+package connections
+
+import (
+	"reflect"
+	"testing"
+)
+
+/*
+Function	: Want graph
+Description	: Build a giver -> receiver graph from a list of edges, without cards.
+Parameters 	: edges (giver, receiver)
+Return     	: giver -> receiver -> wantedCard list map
+Private
+*/
+func wantGraph(edges ...[2]uint) map[uint]map[uint][]wantedCard {
+	gives := map[uint]map[uint][]wantedCard{}
+	for _, edge := range edges {
+		if gives[edge[0]] == nil {
+			gives[edge[0]] = map[uint][]wantedCard{}
+		}
+		gives[edge[0]][edge[1]] = nil
+	}
+	return gives
+}
+
+func TestFindRing(t *testing.T) {
+	triangle := wantGraph([2]uint{1, 2}, [2]uint{2, 3}, [2]uint{3, 1})
+	tests := []struct {
+		name   string
+		gives  map[uint]map[uint][]wantedCard
+		start  uint
+		length int
+		used   map[uint]bool
+		skip   map[string]bool
+		want   []uint
+	}{
+		{"triangle", triangle, 1, 3, nil, nil, []uint{1, 2, 3}},
+		{"triangle from a higher user", triangle, 2, 3, nil, nil, nil}, // Found from its lowest user
+		{"too long", triangle, 1, 4, nil, nil, nil},
+		{"used user", triangle, 1, 3, map[uint]bool{3: true}, nil, nil},
+		{"skipped ring", triangle, 1, 3, nil, map[string]bool{"1>2>3": true}, nil},
+		{"no way back", wantGraph([2]uint{1, 2}, [2]uint{2, 3}), 1, 3, nil, nil, nil},
+		{"lowest receivers first", wantGraph([2]uint{1, 3}, [2]uint{1, 2}, [2]uint{2, 4}, [2]uint{3, 4}, [2]uint{4, 1}),
+			1, 3, nil, nil, []uint{1, 2, 4}},
+		{"second ring when the first is skipped",
+			wantGraph([2]uint{1, 3}, [2]uint{1, 2}, [2]uint{2, 4}, [2]uint{3, 4}, [2]uint{4, 1}),
+			1, 3, nil, map[string]bool{"1>2>4": true}, []uint{1, 3, 4}},
+		{"square", wantGraph([2]uint{1, 2}, [2]uint{2, 3}, [2]uint{3, 4}, [2]uint{4, 1}, [2]uint{3, 1}),
+			1, 4, nil, nil, []uint{1, 2, 3, 4}},
+		{"no repeated users", wantGraph([2]uint{1, 2}, [2]uint{2, 1}, [2]uint{2, 3}), 1, 3, nil, nil, nil},
+	}
+	for _, test := range tests {
+		got := findRing(test.gives, []uint{test.start}, test.length, test.used, test.skip)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRingKey(t *testing.T) {
+	tests := []struct {
+		ring []uint
+		want string
+	}{
+		{[]uint{1, 2, 3}, "1>2>3"},
+		{[]uint{4, 10, 7, 12}, "4>10>7>12"},
+		{[]uint{5}, "5"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if got := ringKey(test.ring); got != test.want {
+			t.Errorf("ringKey(%v) = %q, want %q", test.ring, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+File		: tradeMessages.go
+Description	: File that deals with the messages of the trades, so the users of a trade can talk without knowing the
+email of each other. Only the users of a trade can send and read its messages.
+*/
+
+package connections
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+)
+
+// Number of messages of a page, by default and at most
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
+/*
+Function	: Send trade message
+Description	: Send a message to the other user of a trade. The message is also sent as an event to both users, and by email
+to the other user.
+Parameters 	: context, userID, TradeID, body
+Return     	: TradeMessage, error
+*/
+func SendTradeMessageDB(ctx context.Context, userID uint, tradeID uint, body string) (models.TradeMessage, error) {
+	message := models.TradeMessage{TradeID: tradeID, UserID: userID, Body: body}
+	trade, err := getUserTrade(models.DB.WithContext(ctx), userID, tradeID)
+	if err != nil {
+		return message, err
+	}
+	if err := models.DB.WithContext(ctx).Create(&message).Error; err != nil {
+		return message, err
+	}
+	var user models.User
+	if err := models.DB.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return message, err
+	}
+	message.Username = user.Username
+	publishEvent(events.MessageCreated, message, trade.UserIdOrigin, trade.UserIdOwner)
+	notify(events.MessageCreated, trade, body, trade.OtherUser(userID))
+	return message, nil
+}
+
+/*
+Function	: Get trade messages
+Description	: Get a page of the messages of a trade, the newest first. The messages sent to the user are marked as read.
+Parameters 	: context, userID, TradeID, cursor (empty for the newest messages), limit (0 for the default)
+Return     	: TradeMessage list, cursor of the next page (empty if there are no more messages), error
+*/
+func GetTradeMessagesDB(ctx context.Context, userID uint, tradeID uint, cursor string, limit int) ([]models.TradeMessage, string, error) {
+	trade, err := getUserTrade(models.DB.WithContext(ctx), userID, tradeID)
+	if err != nil {
+		return []models.TradeMessage{}, "", err
+	}
+	var before uint64
+	if cursor != "" {
+		if before, err = strconv.ParseUint(cursor, 10, 32); err != nil {
+			return []models.TradeMessage{}, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+	}
+	if limit <= 0 {
+		limit = defaultMessagesLimit
+	}
+	if limit > maxMessagesLimit {
+		limit = maxMessagesLimit
+	}
+
+	// Get one more message to know if there is a next page
+	messages, err := models.GetTradeMessages(models.DB.WithContext(ctx), tradeID, uint(before), limit+1)
+	if err != nil {
+		return messages, "", err
+	}
+	nextCursor := ""
+	if len(messages) > limit {
+		messages = messages[:limit]
+		nextCursor = strconv.FormatUint(uint64(messages[limit-1].MessageID), 10)
+	}
+	if len(messages) == 0 {
+		return messages, nextCursor, nil
+	}
+
+	// Mark the messages of the other user in this page as read
+	now := time.Now()
+	var unread []uint
+	for _, message := range messages {
+		if message.UserID != userID && message.ReadAt == nil {
+			unread = append(unread, message.MessageID)
+		}
+	}
+	if err := models.MarkMessagesRead(models.DB.WithContext(ctx), tradeID, userID, unread, now); err != nil {
+		return messages, nextCursor, err
+	}
+
+	var users []models.User
+	if err := models.DB.WithContext(ctx).Where("user_id IN ?", []uint{trade.UserIdOrigin, trade.UserIdOwner}).Find(&users).Error; err != nil {
+		return messages, nextCursor, err
+	}
+	usernames := make(map[uint]string)
+	for _, user := range users {
+		usernames[user.User_id] = user.Username
+	}
+	for i := range messages {
+		messages[i].Username = usernames[messages[i].UserID]
+		if messages[i].UserID != userID && messages[i].ReadAt == nil {
+			messages[i].ReadAt = &now
+		}
+	}
+	return messages, nextCursor, nil
+}
@@ -0,0 +1,225 @@
+/*
+File		: tradeRevisions.go
+Description	: File that deals with the revisions of the trades. Every time the cards of a trade change, the new proposal
+is saved as a revision that is never modified, so both users can see how the trade was negotiated. A user accepts a
+specific revision, so nobody accepts a proposal that changed after he saw it.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Returned when a request is for a revision of a trade that is not the current one
+var ErrStaleTrade = errors.New("the trade was changed by another request")
+
+/*
+Function	: Accept trade
+Description	: Accept a revision of a trade. If the other user already accepted it, the trade is accepted and the
+selected copies are removed from the collections.
+
+Parameters 	: context, userID, TradeID, revision
+Return     	: error (ErrStaleTrade if the revision is not the current one)
+*/
+func AcceptTradeDB(ctx context.Context, userID uint, tradeID uint, revision uint) error {
+	if err := expireTrades(ctx, userID); err != nil {
+		return err
+	}
+	return changeTrade(ctx, userID, tradeID, func(tx *gorm.DB, trade *models.Trade) error {
+		if !trade.IsNegotiated() {
+			return fmt.Errorf("%w: a %s trade can't be accepted", ErrIllegalTransition, trade.State)
+		}
+		if revision != trade.Revision {
+			return staleTradeError(trade)
+		}
+
+		// Lock the collections of both users and the cards of the trade
+		if err := lockCollections(tx, userID, trade.OtherUser(userID)); err != nil {
+			return err
+		}
+		items, err := models.GetTradeItems(tx, []uint{trade.TradeID})
+		if err != nil {
+			return err
+		}
+		var cardIDs []uint
+		for _, item := range items[trade.TradeID] {
+			cardIDs = append(cardIDs, item.CardID)
+		}
+		cards, err := lockCards(tx, cardIDs)
+		if err != nil {
+			return err
+		}
+
+		_, heChecked := trade.Checks(userID)
+		trade.SetChecks(userID, true, heChecked)
+		if err := acceptIfAgreed(tx, trade, userID, items[trade.TradeID], cards); err != nil {
+			return err
+		}
+		return tx.Save(trade).Error
+	})
+}
+
+/*
+Function	: Get trade revisions
+Description	: Get the revisions of a trade of the user, with the cards added, removed and changed by each of them.
+Parameters 	: context, userID, TradeID
+Return     	: TradeRevisionView list (the oldest first), error
+*/
+func GetTradeRevisionsDB(ctx context.Context, userAsking uint, tradeID uint) ([]models.TradeRevisionView, error) {
+	views := []models.TradeRevisionView{}
+	if _, err := getUserTrade(models.DB.WithContext(ctx), userAsking, tradeID); err != nil {
+		return views, err
+	}
+	revisions, items, err := models.GetTradeRevisions(models.DB.WithContext(ctx), tradeID)
+	if err != nil {
+		return views, err
+	}
+
+	// Get all the cards of all the revisions at once
+	var cardIDs []uint
+	for _, revisionItems := range items {
+		for _, item := range revisionItems {
+			cardIDs = append(cardIDs, item.CardID)
+		}
+	}
+	cards, err := resolveTradeCards(ctx, cardIDs)
+	if err != nil {
+		return views, err
+	}
+
+	usernames := make(map[uint]string)
+	var previous []models.TradeRevisionItem
+	for _, revision := range revisions {
+		// Get the user of the revision (once per user)
+		username, ok := usernames[revision.UserID]
+		if !ok {
+			var user models.User
+			if err := models.DB.WithContext(ctx).First(&user, revision.UserID).Error; err != nil {
+				return views, err
+			}
+			username = user.Username
+			usernames[revision.UserID] = username
+		}
+		view := models.TradeRevisionView{
+			Revision:     revision.Number,
+			Username:     username,
+			CreatedAt:    revision.CreatedAt,
+			WhatHeTrade:  []models.CardSelect{},
+			WhatYouTrade: []models.CardSelect{},
+			Changes:      diffRevisions(userAsking, previous, items[revision.RevisionID], cards),
+		}
+		for _, item := range items[revision.RevisionID] {
+			cardSelect := models.CardSelect{Card: cards[item.CardID], Select: item.CardSelect}
+			if item.UserID == userAsking {
+				view.WhatYouTrade = append(view.WhatYouTrade, cardSelect)
+			} else {
+				view.WhatHeTrade = append(view.WhatHeTrade, cardSelect)
+			}
+		}
+		views = append(views, view)
+		previous = items[revision.RevisionID]
+	}
+	return views, nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Stale trade error
+Description	: Build the error of a request for an old revision of a trade.
+Parameters 	: Trade
+Return     	: error (ErrStaleTrade)
+Private
+*/
+func staleTradeError(trade *models.Trade) error {
+	return fmt.Errorf("%w (current revision %d)", ErrStaleTrade, trade.Revision)
+}
+
+/*
+Function	: Save revision
+Description	: Save the new cards of a trade as its next revision.
+Parameters 	: DB transaction, Trade, userID of the author, TradeItem list
+Return     	: error
+Private
+*/
+func saveRevision(tx *gorm.DB, trade *models.Trade, userID uint, items []models.TradeItem) error {
+	revision := models.TradeRevision{TradeID: trade.TradeID, Number: trade.Revision + 1, UserID: userID}
+	if err := tx.Create(&revision).Error; err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		revisionItems := make([]models.TradeRevisionItem, 0, len(items))
+		for _, item := range items {
+			revisionItems = append(revisionItems, models.TradeRevisionItem{
+				RevisionID: revision.RevisionID,
+				CardID:     item.CardID,
+				UserID:     item.UserID,
+				CardSelect: item.CardSelect,
+			})
+		}
+		if err := tx.Create(&revisionItems).Error; err != nil {
+			return err
+		}
+	}
+	trade.Revision = revision.Number
+	return nil
+}
+
+/*
+Function	: Diff revisions
+Description	: Get the changes of the cards between two revisions of a trade.
+Parameters 	: userID asking, previous TradeRevisionItem list, TradeRevisionItem list, CardID -> Card map
+Return     	: TradeChange list (in the order of the revisions)
+Private
+*/
+func diffRevisions(userAsking uint, previous []models.TradeRevisionItem, current []models.TradeRevisionItem, cards map[uint]models.Card) []models.TradeChange {
+	changes := []models.TradeChange{}
+	before := make(map[uint]models.TradeRevisionItem)
+	for _, item := range previous {
+		before[item.CardID] = item
+	}
+	after := make(map[uint]models.TradeRevisionItem)
+	for _, item := range current {
+		after[item.CardID] = item
+	}
+
+	for _, item := range current {
+		old, found := before[item.CardID]
+		switch {
+		case !found:
+			changes = append(changes, tradeChange(userAsking, item, "added", 0, item.CardSelect, cards))
+		case old.CardSelect != item.CardSelect:
+			changes = append(changes, tradeChange(userAsking, item, "changed", old.CardSelect, item.CardSelect, cards))
+		}
+	}
+	for _, item := range previous {
+		if _, found := after[item.CardID]; !found {
+			changes = append(changes, tradeChange(userAsking, item, "removed", item.CardSelect, 0, cards))
+		}
+	}
+	return changes
+}
+
+/*
+Function	: Trade change
+Description	: Build the change of a card between two revisions.
+Parameters 	: userID asking, TradeRevisionItem, change, copies before, copies after, CardID -> Card map
+Return     	: TradeChange
+Private
+*/
+func tradeChange(userAsking uint, item models.TradeRevisionItem, change string, from uint, to uint, cards map[uint]models.Card) models.TradeChange {
+	return models.TradeChange{
+		Card:   cards[item.CardID],
+		Yours:  item.UserID == userAsking,
+		Change: change,
+		From:   from,
+		To:     to,
+	}
+}
@@ -0,0 +1,113 @@
+/*
+File		: tradeRevisions_test.go
+Description	: Tests of the changes of the cards between two revisions of a trade and of the acceptance of the revisions.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	const me, other = 1, 2
+	cards := map[uint]models.Card{
+		10: {Name: "Lightning Bolt"},
+		11: {Name: "Counterspell"},
+		20: {Name: "Sol Ring"},
+		21: {Name: "Dark Ritual"},
+	}
+	previous := []models.TradeRevisionItem{
+		{CardID: 10, UserID: me, CardSelect: 2},
+		{CardID: 11, UserID: me, CardSelect: 1},
+		{CardID: 20, UserID: other, CardSelect: 1},
+	}
+	tests := []struct {
+		name     string
+		previous []models.TradeRevisionItem
+		current  []models.TradeRevisionItem
+		want     []models.TradeChange
+	}{
+		{"same cards", previous, previous, []models.TradeChange{}},
+		{"first revision", nil, previous[:1], []models.TradeChange{
+			{Card: cards[10], Yours: true, Change: "added", From: 0, To: 2},
+		}},
+		{"added, changed and removed", previous, []models.TradeRevisionItem{
+			{CardID: 10, UserID: me, CardSelect: 3},
+			{CardID: 20, UserID: other, CardSelect: 1},
+			{CardID: 21, UserID: other, CardSelect: 4},
+		}, []models.TradeChange{
+			{Card: cards[10], Yours: true, Change: "changed", From: 2, To: 3},
+			{Card: cards[21], Yours: false, Change: "added", From: 0, To: 4},
+			{Card: cards[11], Yours: true, Change: "removed", From: 1, To: 0},
+		}},
+		{"all removed", previous, nil, []models.TradeChange{
+			{Card: cards[10], Yours: true, Change: "removed", From: 2, To: 0},
+			{Card: cards[11], Yours: true, Change: "removed", From: 1, To: 0},
+			{Card: cards[20], Yours: false, Change: "removed", From: 1, To: 0},
+		}},
+	}
+	for _, test := range tests {
+		if got := diffRevisions(me, test.previous, test.current, cards); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAcceptTradeDB(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	alice, bob, tradeID := createTestTrade(t)
+
+	// Bob asks for the 2 bolts of alice, which removes her acceptance
+	err := UpdateTradeDB(ctx, bob.User_id, tradeID, models.HoleTrade{
+		WhatHeTrade:  []models.CardSelect{{Card: models.Card{VersionID: boltM10, Condi: "NM"}, Select: 2}},
+		WhatYouTrade: []models.CardSelect{{Card: models.Card{VersionID: solRing, Condi: "NM"}, Select: 1}},
+		YouChecked:   true,
+		Revision:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := map[uint]map[string]uint{alice.User_id: testCollection(t, alice.User_id), bob.User_id: testCollection(t, bob.User_id)}
+
+	// Alice can't accept the proposal she saw before the counter
+	if err := AcceptTradeDB(ctx, alice.User_id, tradeID, 1); !errors.Is(err, ErrStaleTrade) {
+		t.Fatalf("revision 1 accepted: %v, want ErrStaleTrade", err)
+	}
+	if trade, err := GetTradeDB(ctx, alice.User_id, tradeID); err != nil || trade.State != models.TradeCountered || trade.YouChecked {
+		t.Fatalf("trade = %s, checked by alice %t (%v), want countered and not checked", trade.State, trade.YouChecked, err)
+	}
+	for userID, collection := range before {
+		if got := testCollection(t, userID); !reflect.DeepEqual(got, collection) {
+			t.Errorf("collection of %d = %v, want it unchanged %v", userID, got, collection)
+		}
+	}
+
+	// Both users accepted the revision 2: the copies leave the collections
+	if err := AcceptTradeDB(ctx, alice.User_id, tradeID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if trade, err := GetTradeDB(ctx, bob.User_id, tradeID); err != nil || trade.State != models.TradeAccepted {
+		t.Errorf("trade = %s (%v), want accepted", trade.State, err)
+	}
+	want := map[uint]map[string]uint{alice.User_id: {boltM10 + "//NM": 0}, bob.User_id: {solRing + "//NM": 0}}
+	for userID, collection := range want {
+		if got := testCollection(t, userID); !reflect.DeepEqual(got, collection) {
+			t.Errorf("collection of %d = %v, want %v", userID, got, collection)
+		}
+		if version, err := models.GetCollectionVersion(ctx, userID); err != nil || version != 1 {
+			t.Errorf("collection version of %d = %d (%v), want 1", userID, version, err)
+		}
+	}
+
+	if err := AcceptTradeDB(ctx, bob.User_id, tradeID, 2); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("accepted trade accepted again: %v, want ErrIllegalTransition", err)
+	}
+}
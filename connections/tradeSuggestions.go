@@ -0,0 +1,113 @@
+/*
+File		: tradeSuggestions.go
+Description	: File that suggests trades where both users get cards of their wantlists: the other user has cards that the
+user wants, and the user has cards that the other user wants. Every suggestion is a trade ready to be proposed with
+NewTradeDB.
+*/
+
+package connections
+
+import (
+	"context"
+	"sort"
+
+	"CardaliaAPI/models"
+)
+
+/*
+Function	: Get trade suggestions
+Description	: Get a trade proposal for every other user whose collection has cards of the wantlist of the user and whose
+wantlist has cards of the collection of the user. The trades that give more wanted cards to both users are first. Only
+the copies that are not reserved by trades are offered.
+
+Parameters 	: context, userID
+Return     	: HoleTrade list {username, whatHeTrade, whatYouTrade}, error
+*/
+func GetTradeSuggestionsDB(ctx context.Context, userID uint) ([]models.HoleTrade, error) {
+	suggestions := []models.HoleTrade{}
+	wantlist, err := GetWantlistDB(ctx, userID)
+	if err != nil || len(wantlist) == 0 {
+		return suggestions, err
+	}
+	users, theirOffers, err := usersWithWantedCards(ctx, userID, wantlist)
+	if err != nil || len(users) == 0 {
+		return suggestions, err
+	}
+
+	// The wantlists of those users against the collection of the user
+	var collection []models.CardOwnership
+	err = models.DB.WithContext(ctx).Where("user_id = ? AND count != ?", userID, 0).Order("card_id").Find(&collection).Error
+	if err != nil || len(collection) == 0 {
+		return suggestions, err
+	}
+	reserved, err := getReservedCopies(ctx, collection)
+	if err != nil {
+		return suggestions, err
+	}
+	var wantlists []models.WantlistItem
+	if err := models.DB.WithContext(ctx).Where("user_id IN ?", users).Order("want_id").Find(&wantlists).Error; err != nil {
+		return suggestions, err
+	}
+	userWantlists := map[uint][]models.WantlistItem{}
+	for _, want := range wantlists {
+		userWantlists[want.UserID] = append(userWantlists[want.UserID], want)
+	}
+	var partners []uint
+	yourOffers := map[uint][]wantedCard{}
+	for _, user := range users {
+		if offer := matchWantlist(userWantlists[user], collection, reserved); len(offer) > 0 {
+			partners = append(partners, user)
+			yourOffers[user] = offer
+		}
+	}
+	if len(partners) == 0 {
+		return suggestions, nil
+	}
+
+	// Build the cards of all the trades at once
+	var tradedCards []models.CardOwnership
+	for _, user := range partners {
+		for _, offer := range theirOffers[user] {
+			tradedCards = append(tradedCards, offer.card)
+		}
+		for _, offer := range yourOffers[user] {
+			tradedCards = append(tradedCards, offer.card)
+		}
+	}
+	cards, err := buildCards(ctx, tradedCards)
+	if err != nil {
+		return suggestions, err
+	}
+	usernames, err := getUsernames(ctx, partners)
+	if err != nil {
+		return suggestions, err
+	}
+	balance := map[string]uint{}
+	for _, user := range partners {
+		suggestion := models.HoleTrade{Username: usernames[user]}
+		var heGives, youGive uint
+		for _, offer := range theirOffers[user] {
+			suggestion.WhatHeTrade = append(suggestion.WhatHeTrade, models.CardSelect{Card: cards[0], Select: offer.copies})
+			cards = cards[1:]
+			heGives += offer.copies
+		}
+		for _, offer := range yourOffers[user] {
+			suggestion.WhatYouTrade = append(suggestion.WhatYouTrade, models.CardSelect{Card: cards[0], Select: offer.copies})
+			cards = cards[1:]
+			youGive += offer.copies
+		}
+		// A trade is as good as the side that gives less wanted copies
+		balance[suggestion.Username] = heGives
+		if youGive < heGives {
+			balance[suggestion.Username] = youGive
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if balance[suggestions[i].Username] != balance[suggestions[j].Username] {
+			return balance[suggestions[i].Username] > balance[suggestions[j].Username]
+		}
+		return suggestions[i].Username < suggestions[j].Username
+	})
+	return suggestions, nil
+}
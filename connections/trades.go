@@ -0,0 +1,761 @@
+/*
+File		: trades.go
+Description	: File that deals with the trades between users. A trade has a header (the users, its state and who
+accepts it) and an item for every traded card. All the changes of a trade are done in one transaction, with the
+collections of both users and the traded cards locked, so a trade is changed completely or not at all.
+The state of a trade only changes with the transitions of models.CanTransition, and every change is recorded.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors of the trades. The routes map them to HTTP status codes.
+var (
+	ErrTradeNotFound     = errors.New("trade not found")
+	ErrIllegalTransition = errors.New("illegal trade transition")
+)
+
+// Time without changes after which a negotiated trade expires
+const tradeExpiration = 30 * 24 * time.Hour
+
+// Data of the events of the trades
+type tradeEvent struct {
+	TradeID  uint   `json:"trade_id"`
+	State    string `json:"state"`
+	Revision uint   `json:"revision"`
+}
+
+/*
+Function	: New Trade DB
+Description	: Start a new trade with another user. A user can have more than one trade with the same user.
+Parameters 	: context, userID, Trade list {username, whatHeTrade, whatYouTrade, youChecked}
+Return     	: TradeID, error
+*/
+func NewTradeDB(ctx context.Context, user_id_origin uint, holeTrade models.HoleTrade) (uint, error) {
+	// Get the userID of the owner of the card
+	user_id_owner, err := models.GetUserIDByUsername(holeTrade.Username)
+	if err != nil {
+		return 0, err
+	}
+	if user_id_owner == user_id_origin {
+		return 0, errors.New("a user can't trade with himself")
+	}
+
+	trade := models.Trade{UserIdOrigin: user_id_origin, UserIdOwner: user_id_owner, State: models.TradeProposed}
+	err = models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&trade).Error; err != nil {
+			return err
+		}
+		transition := models.TradeTransition{TradeID: trade.TradeID, ToState: models.TradeProposed, UserID: user_id_origin}
+		if err := tx.Create(&transition).Error; err != nil {
+			return err
+		}
+		return updateTrade(tx, &trade, user_id_origin, holeTrade)
+	})
+	if err == nil {
+		publishTradeEvents(&trade, user_id_origin, "", 0)
+	}
+	return trade.TradeID, err
+}
+
+/*
+Function	: Modify Trade
+Description	: Modify a Trade in the DB. If the trade has no ID, the last negotiated trade with the user is modified (or
+a new one is started), as the clients did before trades had their own ID.
+
+Parameters 	: context, userID, Trade list {trade_id, username, whatHeTrade, whatYouTrade, youChecked}
+Return     	: error
+*/
+func ModifyTradeDB(ctx context.Context, user_id_origin uint, holeTrade models.HoleTrade) error {
+	if holeTrade.TradeID != 0 {
+		return UpdateTradeDB(ctx, user_id_origin, holeTrade.TradeID, holeTrade)
+	}
+	// Get the userID of the owner of the card
+	user_id_owner, err := models.GetUserIDByUsername(holeTrade.Username)
+	if err != nil {
+		return err
+	}
+	if err := expireTrades(ctx, user_id_origin); err != nil {
+		return err
+	}
+	var trade models.Trade
+	err = models.DB.WithContext(ctx).Where("((user_id_origin = ? AND user_id_owner = ?) OR (user_id_origin = ? AND user_id_owner = ?)) AND state IN ? AND cycle_id = ?",
+		user_id_origin, user_id_owner, user_id_owner, user_id_origin, negotiatedStates, 0).Order("trade_id DESC").First(&trade).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_, err = NewTradeDB(ctx, user_id_origin, holeTrade)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return UpdateTradeDB(ctx, user_id_origin, trade.TradeID, holeTrade)
+}
+
+/*
+Function	: Update Trade
+Description	: Change the cards of a negotiated trade and the acceptance of the user. If the cards change, the trade is
+countered and the acceptance of the other user is removed, because he agreed to other cards. When both users accept
+the trade, it is accepted and the selected copies are removed from the collections.
+
+Parameters 	: context, userID, TradeID, Trade list {whatHeTrade, whatYouTrade, youChecked}
+Return     	: error
+*/
+func UpdateTradeDB(ctx context.Context, userID uint, tradeID uint, holeTrade models.HoleTrade) error {
+	if err := expireTrades(ctx, userID); err != nil {
+		return err
+	}
+	return changeTrade(ctx, userID, tradeID, func(tx *gorm.DB, trade *models.Trade) error {
+		return updateTrade(tx, trade, userID, holeTrade)
+	})
+}
+
+/*
+Function	: Cancel Trade
+Description	: Cancel a negotiated trade of the user.
+Parameters 	: context, userID, TradeID
+Return     	: error
+*/
+func CancelTradeDB(ctx context.Context, userID uint, tradeID uint) error {
+	return ChangeTradeStateDB(ctx, userID, tradeID, models.TradeCancelled)
+}
+
+/*
+Function	: Change trade state
+Description	: Move a trade of the user to another state (shipped, received, completed or cancelled). The user that
+marks the trade as shipped is recorded, and only the other user can mark it as received.
+
+Parameters 	: context, userID, TradeID, state
+Return     	: error (ErrIllegalTransition if the trade can't go to the state or the user can't move it)
+*/
+func ChangeTradeStateDB(ctx context.Context, userID uint, tradeID uint, state string) error {
+	if state == models.TradeAccepted || state == models.TradeCountered {
+		return fmt.Errorf("%w: a trade is %s by changing its cards or acceptance", ErrIllegalTransition, state)
+	}
+	if err := expireTrades(ctx, userID); err != nil {
+		return err
+	}
+	return changeTrade(ctx, userID, tradeID, func(tx *gorm.DB, trade *models.Trade) error {
+		if state == models.TradeReceived && trade.ShippedBy == userID {
+			return fmt.Errorf("%w: the cards are received by the user that didn't ship them", ErrIllegalTransition)
+		}
+		if err := transitionTrade(tx, trade, userID, state); err != nil {
+			return err
+		}
+		if state == models.TradeShipped {
+			trade.ShippedBy = userID
+		}
+		return tx.Save(trade).Error
+	})
+}
+
+/*
+Function	: Delete all trades between users
+Description	: Cancel all the negotiated trades between two users, except the ones of trade cycles.
+Parameters 	: context, userID, userID
+Return     	: error
+*/
+func DeleteAllTradesBetweenUsersDB(ctx context.Context, user1 uint, user2 uint) error {
+	var trades []models.Trade
+	var oldStates []string
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("((user_id_origin = ? AND user_id_owner = ?) OR (user_id_origin = ? AND user_id_owner = ?)) AND state IN ? AND cycle_id = ?",
+				user1, user2, user2, user1, negotiatedStates, 0).Find(&trades).Error
+		if err != nil {
+			return err
+		}
+		for i := range trades {
+			oldStates = append(oldStates, trades[i].State)
+			if err := transitionTrade(tx, &trades[i], user1, models.TradeCancelled); err != nil {
+				return err
+			}
+			if err := tx.Save(&trades[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for i := range trades {
+			publishTradeEvents(&trades[i], user1, oldStates[i], trades[i].Revision)
+		}
+	}
+	return err
+}
+
+/*
+Function	: Get Trade
+Description	: Get a trade of the user, with its changes of state.
+Parameters 	: context, userID, TradeID
+Return     	: HoleTrade, error
+*/
+func GetTradeDB(ctx context.Context, userAsking uint, tradeID uint) (models.HoleTrade, error) {
+	if err := expireTrades(ctx, userAsking); err != nil {
+		return models.HoleTrade{}, err
+	}
+	trade, err := getUserTrade(models.DB.WithContext(ctx), userAsking, tradeID)
+	if err != nil {
+		return models.HoleTrade{}, err
+	}
+	holeTrades, err := buildHoleTrades(ctx, userAsking, []models.Trade{trade})
+	if err != nil {
+		return models.HoleTrade{}, err
+	}
+	holeTrades[0].History, err = models.GetTradeTransitions(models.DB.WithContext(ctx), tradeID)
+	return holeTrades[0], err
+}
+
+/*
+Function	: Get Trades
+Description	: Get all the trades of the user that are not cancelled or expired, the newest first.
+Parameters 	: context, userID
+Return     	: HoleTrade list, error
+*/
+func GetTradesDB(ctx context.Context, userAsking uint) ([]models.HoleTrade, error) {
+	if err := expireTrades(ctx, userAsking); err != nil {
+		return []models.HoleTrade{}, err
+	}
+	var trades []models.Trade
+	// Get all the trades in where the user contributes
+	err := models.DB.WithContext(ctx).Where("(user_id_origin = ? OR user_id_owner = ?) AND state NOT IN ?",
+		userAsking, userAsking, []string{models.TradeCancelled, models.TradeExpired}).Order("trade_id DESC").Find(&trades).Error
+	if err != nil {
+		return []models.HoleTrade{}, err
+	}
+	return buildHoleTrades(ctx, userAsking, trades)
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+// States in which the cards of a trade can be changed
+var negotiatedStates = []string{models.TradeProposed, models.TradeCountered}
+
+/*
+Function	: Get user trade
+Description	: Get a trade of a user to read it, without locking it.
+Parameters 	: DB, userID, TradeID
+Return     	: Trade, error (ErrTradeNotFound if the trade doesn't exist or the user is not in it)
+Private
+*/
+func getUserTrade(db *gorm.DB, userID uint, tradeID uint) (models.Trade, error) {
+	trade, err := models.GetTrade(db, tradeID)
+	return trade, userTradeError(trade, err, userID, tradeID)
+}
+
+/*
+Function	: Lock user trade
+Description	: Get a trade of a user to change it, locking it until the end of the transaction.
+Parameters 	: DB transaction, userID, TradeID
+Return     	: Trade, error (ErrTradeNotFound if the trade doesn't exist or the user is not in it)
+Private
+*/
+func lockUserTrade(tx *gorm.DB, userID uint, tradeID uint) (models.Trade, error) {
+	trade, err := models.GetTradeForUpdate(tx, tradeID)
+	return trade, userTradeError(trade, err, userID, tradeID)
+}
+
+/*
+Function	: User trade error
+Description	: Error of a trade read for a user.
+Parameters 	: Trade, error of the read, userID, TradeID
+Return     	: error (ErrTradeNotFound if the trade doesn't exist or the user is not in it)
+Private
+*/
+func userTradeError(trade models.Trade, err error, userID uint, tradeID uint) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !trade.IsParticipant(userID)) {
+		return fmt.Errorf("%w: %d", ErrTradeNotFound, tradeID)
+	}
+	return err
+}
+
+/*
+Function	: Update trade
+Description	: Replace the cards of a negotiated trade and set the acceptance of the user, accepting the trade if both
+users accept it.
+
+Parameters 	: DB transaction, Trade, userID, Trade list {whatHeTrade, whatYouTrade, youChecked}
+Return     	: error
+Private
+*/
+func updateTrade(tx *gorm.DB, trade *models.Trade, userID uint, holeTrade models.HoleTrade) error {
+	if !trade.IsNegotiated() {
+		return fmt.Errorf("%w: the cards of a %s trade can't be changed", ErrIllegalTransition, trade.State)
+	}
+	if holeTrade.Revision != 0 && holeTrade.Revision != trade.Revision {
+		return staleTradeError(trade)
+	}
+	otherUser := trade.OtherUser(userID)
+
+	// Lock the collections of both users, always in the same order
+	if err := lockCollections(tx, userID, otherUser); err != nil {
+		return err
+	}
+	// Get the new items and lock their cards
+	items, err := getTradeItems(tx, trade.TradeID, otherUser, holeTrade.WhatHeTrade)
+	if err != nil {
+		return err
+	}
+	youItems, err := getTradeItems(tx, trade.TradeID, userID, holeTrade.WhatYouTrade)
+	if err != nil {
+		return err
+	}
+	items = append(items, youItems...)
+	cardIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		cardIDs = append(cardIDs, item.CardID)
+	}
+	cards, err := lockCards(tx, cardIDs)
+	if err != nil {
+		return err
+	}
+	// The cards can't be offered in more trades than their copies
+	if err := checkTradeItemsAvailable(tx, trade.TradeID, items, cards); err != nil {
+		return err
+	}
+
+	// Replace the items if they changed. The other user agreed to the old ones, so his acceptance is removed.
+	// Only the changes of the user that proposed the trade, before the other user changes it, are not a counter.
+	oldItems, err := models.GetTradeItems(tx, []uint{trade.TradeID})
+	if err != nil {
+		return err
+	}
+	_, heChecked := trade.Checks(userID)
+	if !sameTradeItems(oldItems[trade.TradeID], items) {
+		heChecked = false
+		if trade.State != models.TradeProposed || userID != trade.UserIdOrigin {
+			if err := transitionTrade(tx, trade, userID, models.TradeCountered); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("trade_id = ?", trade.TradeID).Delete(&models.TradeItem{}).Error; err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			if err := tx.Create(&items).Error; err != nil {
+				return err
+			}
+		}
+		if err := saveRevision(tx, trade, userID, items); err != nil {
+			return err
+		}
+	}
+	trade.SetChecks(userID, holeTrade.YouChecked, heChecked)
+
+	if err := acceptIfAgreed(tx, trade, userID, items, cards); err != nil {
+		return err
+	}
+	return tx.Save(trade).Error
+}
+
+/*
+Function	: Accept if agreed
+Description	: If both users accept the trade, delete the selections from the user collections and accept it.
+Parameters 	: DB transaction, Trade, userID, TradeItem list of the trade, CardID -> locked CardOwnership map
+Return     	: error
+Private
+*/
+func acceptIfAgreed(tx *gorm.DB, trade *models.Trade, userID uint, items []models.TradeItem, cards map[uint]*models.CardOwnership) error {
+	if !trade.OriginChecked || !trade.OwnerChecked {
+		return nil
+	}
+	if len(items) == 0 {
+		return errors.New("a trade without cards can't be accepted")
+	}
+	for _, item := range items {
+		if err := deleteSelect(tx, cards[item.CardID], item.CardSelect); err != nil {
+			return err
+		}
+	}
+	for _, user := range []uint{trade.UserIdOrigin, trade.UserIdOwner} {
+		if _, err := bumpCollectionVersion(tx, user, nil); err != nil {
+			return err
+		}
+	}
+	return transitionTrade(tx, trade, userID, models.TradeAccepted)
+}
+
+/*
+Function	: Transition trade
+Description	: Change the state of a trade and record who did it. The trade is not saved.
+Parameters 	: DB transaction, Trade, userID (0 for the API), state
+Return     	: error (ErrIllegalTransition if the trade can't go to the state)
+Private
+*/
+func transitionTrade(tx *gorm.DB, trade *models.Trade, userID uint, state string) error {
+	if !models.CanTransition(trade.State, state) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, trade.State, state)
+	}
+	transition := models.TradeTransition{TradeID: trade.TradeID, FromState: trade.State, ToState: state, UserID: userID}
+	if err := tx.Create(&transition).Error; err != nil {
+		return err
+	}
+	trade.State = state
+	if models.IsFinalState(state) {
+		trade.ClosedAt = &transition.CreatedAt
+	}
+	return nil
+}
+
+/*
+Function	: Expire trades
+Description	: Expire the negotiated trades of a user that nobody changed in tradeExpiration. The trades of the trade
+cycles expire with their cycles (see expireTradeCycles). The trades are only locked if some of them expired.
+Parameters 	: context, userID
+Return     	: error
+Private
+*/
+func expireTrades(ctx context.Context, userID uint) error {
+	expired := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("(user_id_origin = ? OR user_id_owner = ?) AND state IN ? AND updated_at < ? AND cycle_id = ?",
+			userID, userID, negotiatedStates, time.Now().Add(-tradeExpiration), 0)
+	}
+	var count int64
+	if err := expired(models.DB.WithContext(ctx)).Model(&models.Trade{}).Count(&count).Error; err != nil || count == 0 {
+		return err
+	}
+
+	var trades []models.Trade
+	var oldStates []string
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Read again with the lock: they could have been changed since they were counted
+		err := expired(tx.Clauses(clause.Locking{Strength: "UPDATE"})).Find(&trades).Error
+		if err != nil {
+			return err
+		}
+		for i := range trades {
+			oldStates = append(oldStates, trades[i].State)
+			if err := transitionTrade(tx, &trades[i], 0, models.TradeExpired); err != nil {
+				return err
+			}
+			if err := tx.Save(&trades[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for i := range trades {
+			publishTradeEvents(&trades[i], 0, oldStates[i], trades[i].Revision)
+		}
+	}
+	return err
+}
+
+/*
+Function	: Change trade
+Description	: Change a trade of a user in a transaction and, when it is committed, send the events of the changes to
+both users of the trade. The negotiated trades of the trade cycles can't be changed.
+
+Parameters 	: context, userID, TradeID, function that changes the locked trade
+Return     	: error
+Private
+*/
+func changeTrade(ctx context.Context, userID uint, tradeID uint, change func(tx *gorm.DB, trade *models.Trade) error) error {
+	var trade models.Trade
+	var oldState string
+	var oldRevision uint
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if trade, err = lockUserTrade(tx, userID, tradeID); err != nil {
+			return err
+		}
+		// The trades of a cycle are answered with the cycle
+		if trade.CycleID != 0 && trade.IsNegotiated() {
+			return fmt.Errorf("%w: the trade is in the trade cycle %d, answer the cycle", ErrIllegalTransition, trade.CycleID)
+		}
+		oldState, oldRevision = trade.State, trade.Revision
+		return change(tx, &trade)
+	})
+	if err == nil {
+		publishTradeEvents(&trade, userID, oldState, oldRevision)
+	}
+	return err
+}
+
+/*
+Function	: Publish trade events
+Description	: Send to both users of a trade the events of its changes. Only the state and revision are sent, the clients
+get the trade if they need it. The other user also gets the emails of the changes (see notify).
+
+Parameters 	: Trade, userID that made the changes (0 if there is no user), state and revision before the changes
+(empty state if the trade was created)
+Return     	:
+Private
+*/
+func publishTradeEvents(trade *models.Trade, actor uint, oldState string, oldRevision uint) {
+	data := tradeEvent{TradeID: trade.TradeID, State: trade.State, Revision: trade.Revision}
+	users := []uint{trade.UserIdOrigin, trade.UserIdOwner}
+	others := []uint{}
+	for _, userID := range users {
+		if userID != actor {
+			others = append(others, userID)
+		}
+	}
+	eventTypes := []string{}
+	if oldState == "" {
+		eventTypes = append(eventTypes, events.TradeCreated)
+	} else {
+		if trade.Revision != oldRevision {
+			eventTypes = append(eventTypes, events.TradeRevised)
+		}
+		if trade.State != oldState && trade.State != models.TradeCountered {
+			switch trade.State {
+			case models.TradeAccepted:
+				eventTypes = append(eventTypes, events.TradeAccepted)
+			case models.TradeCompleted:
+				eventTypes = append(eventTypes, events.TradeCompleted)
+			default:
+				eventTypes = append(eventTypes, events.TradeState)
+			}
+		}
+	}
+	for _, eventType := range eventTypes {
+		publishEvent(eventType, data, users...)
+		notify(eventType, *trade, "", others...)
+	}
+}
+
+/*
+Function	: Get trade items
+Description	: Build the items of the selected cards of a user (the selects don't have the primary key).
+Parameters 	: DB transaction, TradeID, userID of the owner, CardSelect list
+Return     	: TradeItem list (same order), error
+Private
+*/
+func getTradeItems(tx *gorm.DB, tradeID uint, user_id_owner uint, cardSelects []models.CardSelect) ([]models.TradeItem, error) {
+	items := make([]models.TradeItem, 0, len(cardSelects))
+	for _, cardSelect := range cardSelects {
+		if cardSelect.Select == 0 {
+			return nil, fmt.Errorf("no copies of %s selected", cardSelect.Card.Name)
+		}
+		cardID, err := models.GetCardIDByParams(tx, user_id_owner, cardSelect.Card.VersionID, cardSelect.Card.Extras, cardSelect.Card.Condi)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotInCollection, cardSelect.Card.VersionID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, models.TradeItem{
+			TradeID:    tradeID,
+			CardID:     cardID,
+			UserID:     user_id_owner,
+			CardSelect: cardSelect.Select,
+		})
+	}
+	return items, nil
+}
+
+/*
+Function	: Same trade items
+Description	: Check if two lists of items have the same cards and selections, in any order.
+Parameters 	: TradeItem list, TradeItem list
+Return     	: bool
+Private
+*/
+func sameTradeItems(items1 []models.TradeItem, items2 []models.TradeItem) bool {
+	if len(items1) != len(items2) {
+		return false
+	}
+	selects := make(map[uint]int)
+	for _, item := range items1 {
+		selects[item.CardID] += int(item.CardSelect)
+	}
+	for _, item := range items2 {
+		selects[item.CardID] -= int(item.CardSelect)
+	}
+	for _, diff := range selects {
+		if diff != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Function	: Build hole trades
+Description	: Build the view of a list of trades for one of their users. All the cards of all the trades are resolved
+at once with the card provider.
+
+Parameters 	: context, userID, Trade list
+Return     	: HoleTrade list (same order), error
+Private
+*/
+func buildHoleTrades(ctx context.Context, userAsking uint, trades []models.Trade) ([]models.HoleTrade, error) {
+	holeTrades := []models.HoleTrade{}
+	var tradeIDs []uint
+	for _, trade := range trades {
+		tradeIDs = append(tradeIDs, trade.TradeID)
+	}
+	items, err := models.GetTradeItems(models.DB.WithContext(ctx), tradeIDs)
+	if err != nil {
+		return holeTrades, err
+	}
+	// Get all the cards of all the trades at once
+	var cardIDs []uint
+	for _, tradeItems := range items {
+		for _, item := range tradeItems {
+			cardIDs = append(cardIDs, item.CardID)
+		}
+	}
+	cards, err := resolveTradeCards(ctx, cardIDs)
+	if err != nil {
+		return holeTrades, err
+	}
+	unread, err := models.GetUnreadCounts(models.DB.WithContext(ctx), userAsking, tradeIDs)
+	if err != nil {
+		return holeTrades, err
+	}
+
+	users := make(map[uint]models.User)
+	for _, trade := range trades {
+		// Get the other user (once per user)
+		otherID := trade.OtherUser(userAsking)
+		other, ok := users[otherID]
+		if !ok {
+			if err := models.DB.WithContext(ctx).First(&other, otherID).Error; err != nil {
+				return holeTrades, err
+			}
+			users[otherID] = other
+		}
+		youChecked, heChecked := trade.Checks(userAsking)
+		holeTrade := models.HoleTrade{
+			TradeID:      trade.TradeID,
+			State:        trade.State,
+			Username:     other.Username,
+			WhatHeTrade:  []models.CardSelect{},
+			WhatYouTrade: []models.CardSelect{},
+			YouChecked:   youChecked,
+			HeChecked:    heChecked,
+			YouShipped:   trade.ShippedBy == userAsking,
+			CreatedAt:    trade.CreatedAt,
+			UpdatedAt:    trade.UpdatedAt,
+			ClosedAt:     trade.ClosedAt,
+			Revision:     trade.Revision,
+			Unread:       unread[trade.TradeID],
+			CycleID:      trade.CycleID,
+		}
+		// If both users agreed the trade, we pass the email of the other user
+		if trade.IsAgreed() {
+			holeTrade.Email = other.Email
+		}
+
+		for _, item := range items[trade.TradeID] {
+			card, found := cards[item.CardID]
+			if !found {
+				return holeTrades, fmt.Errorf("card %d of trade %d not found", item.CardID, trade.TradeID)
+			}
+			cardSelect := models.CardSelect{Card: card, Select: item.CardSelect}
+			if item.UserID == userAsking {
+				holeTrade.WhatYouTrade = append(holeTrade.WhatYouTrade, cardSelect)
+			} else {
+				holeTrade.WhatHeTrade = append(holeTrade.WhatHeTrade, cardSelect)
+			}
+		}
+		holeTrades = append(holeTrades, holeTrade)
+	}
+	return holeTrades, nil
+}
+
+/*
+Function	: Resolve trade cards
+Description	: Get the cards of a list of cardOwnerships, with all the card info resolved from the card provider.
+Parameters 	: context, CardID list
+Return     	: CardID -> Card map, error
+Private
+*/
+func resolveTradeCards(ctx context.Context, cardIDs []uint) (map[uint]models.Card, error) {
+	tradeCards := make(map[uint]models.Card)
+	cardOwnerships, err := models.GetCardOwnershipsByCardIDs(cardIDs)
+	if err != nil {
+		return tradeCards, err
+	}
+	var tradedCards []models.CardOwnership
+	for _, cardOwnership := range cardOwnerships {
+		tradedCards = append(tradedCards, cardOwnership)
+	}
+	cards, err := resolveCards(ctx, tradedCards)
+	if err != nil {
+		return tradeCards, err
+	}
+	for cardID, cardOwnership := range cardOwnerships {
+		card := cards[cardOwnership.VersionID]
+		card.Extras = cardOwnership.Extras
+		card.Condi = cardOwnership.Condi
+		card.VersionID = card.ID
+		tradeCards[cardID] = card
+	}
+	return tradeCards, nil
+}
+
+/*
+Function	: Lock cards
+Description	: Lock a list of cardOwnerships until the end of the transaction. They are locked ordered by CardID, so two
+trades with the same cards can't block each other.
+
+Parameters 	: DB transaction, CardID list
+Return     	: CardID -> CardOwnership map, error
+Private
+*/
+func lockCards(tx *gorm.DB, cardIDs []uint) (map[uint]*models.CardOwnership, error) {
+	cards := make(map[uint]*models.CardOwnership)
+	if len(cardIDs) == 0 {
+		return cards, nil
+	}
+	var cardOwnerships []models.CardOwnership
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("card_id IN ?", cardIDs).Order("card_id").Find(&cardOwnerships).Error
+	if err != nil {
+		return cards, err
+	}
+	for i := range cardOwnerships {
+		cards[cardOwnerships[i].CardID] = &cardOwnerships[i]
+	}
+	return cards, nil
+}
+
+/*
+Function	: Lock collections
+Description	: Lock the collections of two users until the end of the transaction, ordered by userID.
+Parameters 	: DB transaction, userID, userID
+Return     	: error
+Private
+*/
+func lockCollections(tx *gorm.DB, user1 uint, user2 uint) error {
+	if user1 > user2 {
+		user1, user2 = user2, user1
+	}
+	if _, err := models.LockCollectionVersion(tx, user1); err != nil {
+		return err
+	}
+	_, err := models.LockCollectionVersion(tx, user2)
+	return err
+}
+
+/*
+Function	: Delete Select
+Description	: Delete the selected cards from a user collection when a trade is finished.
+Parameters 	: DB transaction, locked CardOwnership, CardSelect
+Return     	: error
+Private
+*/
+func deleteSelect(tx *gorm.DB, cardOwnership *models.CardOwnership, cardSelect uint) error {
+	if cardOwnership == nil {
+		return fmt.Errorf("%w: traded card", ErrNotInCollection)
+	}
+	if cardSelect > cardOwnership.Count {
+		return fmt.Errorf("only %d copies of card %d are left to trade", cardOwnership.Count, cardOwnership.CardID)
+	}
+	cardOwnership.Count -= cardSelect
+	return tx.Model(cardOwnership).Update("count", cardOwnership.Count).Error
+}
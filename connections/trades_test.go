@@ -0,0 +1,176 @@
+/*
+File		: trades_test.go
+Description	: Tests of the states of the trades and their events.
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+)
+
+func TestTradeStates(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	alice, bob, tradeID := createTestTrade(t)
+	carol, _ := createTestUser(t, "carol")
+
+	// Only the users of the trade can see or change it
+	if _, err := GetTradeDB(ctx, carol.User_id, tradeID); !errors.Is(err, ErrTradeNotFound) {
+		t.Errorf("trade got by another user: %v, want ErrTradeNotFound", err)
+	}
+	if err := ChangeTradeStateDB(ctx, carol.User_id, tradeID, models.TradeCancelled); !errors.Is(err, ErrTradeNotFound) {
+		t.Errorf("trade cancelled by another user: %v, want ErrTradeNotFound", err)
+	}
+
+	steps := []struct {
+		user  models.User
+		state string
+		err   error // nil if the trade goes to the state
+	}{
+		{alice, models.TradeShipped, ErrIllegalTransition},  // Not accepted yet
+		{alice, models.TradeAccepted, ErrIllegalTransition}, // Only by accepting it
+		{bob, models.TradeCountered, ErrIllegalTransition},  // Only by changing the cards
+		{bob, models.TradeAccepted, nil},
+		{alice, models.TradeCancelled, ErrIllegalTransition}, // Not negotiated anymore
+		{bob, models.TradeReceived, ErrIllegalTransition},    // Not shipped yet
+		{bob, models.TradeShipped, nil},
+		{bob, models.TradeReceived, ErrIllegalTransition}, // The cards were shipped by him
+		{alice, models.TradeReceived, nil},
+		{alice, models.TradeCompleted, nil},
+		{bob, models.TradeCancelled, ErrIllegalTransition}, // Finished
+	}
+	wantHistory := []models.TradeTransition{{ToState: models.TradeProposed, UserID: alice.User_id}}
+	for _, step := range steps {
+		var err error
+		if step.state == models.TradeAccepted && step.user.User_id == bob.User_id {
+			err = AcceptTradeDB(ctx, bob.User_id, tradeID, 1)
+		} else {
+			err = ChangeTradeStateDB(ctx, step.user.User_id, tradeID, step.state)
+		}
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%s by %s: %v, want %v", step.state, step.user.Username, err, step.err)
+		}
+		if err == nil {
+			from := wantHistory[len(wantHistory)-1].ToState
+			wantHistory = append(wantHistory, models.TradeTransition{FromState: from, ToState: step.state, UserID: step.user.User_id})
+		}
+	}
+
+	trade, err := GetTradeDB(ctx, alice.User_id, tradeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.State != models.TradeCompleted || trade.ClosedAt == nil || trade.YouShipped {
+		t.Errorf("trade = %s, closed at %v, shipped by alice %t; want completed, closed and shipped by bob",
+			trade.State, trade.ClosedAt, trade.YouShipped)
+	}
+	var history []models.TradeTransition
+	for _, transition := range trade.History {
+		history = append(history, models.TradeTransition{FromState: transition.FromState, ToState: transition.ToState, UserID: transition.UserID})
+	}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Errorf("history = %+v, want %+v", history, wantHistory)
+	}
+}
+
+func TestTradeCancelledEvents(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	alice, bob, tradeID := createTestTrade(t)
+	subscription, unsubscribe := events.Events.Subscribe(bob.User_id)
+	defer unsubscribe()
+
+	// The trades cancelled together send the change of state, they were not created
+	if err := DeleteAllTradesBetweenUsersDB(ctx, alice.User_id, bob.User_id); err != nil {
+		t.Fatal(err)
+	}
+	if got := eventTypes(subscription); !reflect.DeepEqual(got, []string{events.TradeState}) {
+		t.Errorf("events = %v, want %v", got, []string{events.TradeState})
+	}
+	if trade, err := GetTradeDB(ctx, bob.User_id, tradeID); err != nil || trade.State != models.TradeCancelled {
+		t.Errorf("trade = %s (%v), want cancelled", trade.State, err)
+	}
+	if err := AcceptTradeDB(ctx, bob.User_id, tradeID, 1); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("cancelled trade accepted: %v, want ErrIllegalTransition", err)
+	}
+}
+
+func TestTradeExpiredEvents(t *testing.T) {
+	useFakeProvider(t)
+	useTestDB(t)
+	ctx := context.Background()
+	_, bob, tradeID := createTestTrade(t)
+	err := models.DB.Model(&models.Trade{}).Where("trade_id = ?", tradeID).
+		UpdateColumn("updated_at", time.Now().Add(-tradeExpiration-time.Hour)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, unsubscribe := events.Events.Subscribe(bob.User_id)
+	defer unsubscribe()
+
+	// Reading the trade expires it
+	trade, err := GetTradeDB(ctx, bob.User_id, tradeID)
+	if err != nil || trade.State != models.TradeExpired || trade.ClosedAt == nil {
+		t.Errorf("trade = %s, closed at %v (%v), want expired", trade.State, trade.ClosedAt, err)
+	}
+	if got := eventTypes(subscription); !reflect.DeepEqual(got, []string{events.TradeState}) {
+		t.Errorf("events = %v, want %v", got, []string{events.TradeState})
+	}
+	if err := ChangeTradeStateDB(ctx, bob.User_id, tradeID, models.TradeCancelled); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expired trade cancelled: %v, want ErrIllegalTransition", err)
+	}
+}
+
+/*
+Function	: Create test trade
+Description	: Create two users and a trade, accepted by alice, where alice gives a Lightning Bolt to bob for a Sol
+Ring.
+
+Parameters 	: test
+Return     	: alice, bob, TradeID
+Private
+*/
+func createTestTrade(t *testing.T) (models.User, models.User, uint) {
+	t.Helper()
+	alice, _ := createTestUser(t, "alice", models.CardOwnership{VersionID: boltM10, OracleID: "bolt", Count: 2, Condi: "NM"})
+	bob, _ := createTestUser(t, "bob", models.CardOwnership{VersionID: solRing, OracleID: "ring", Count: 1, Condi: "NM"})
+	tradeID, err := NewTradeDB(context.Background(), alice.User_id, models.HoleTrade{
+		Username:     bob.Username,
+		WhatHeTrade:  []models.CardSelect{{Card: models.Card{VersionID: solRing, Condi: "NM"}, Select: 1}},
+		WhatYouTrade: []models.CardSelect{{Card: models.Card{VersionID: boltM10, Condi: "NM"}, Select: 1}},
+		YouChecked:   true,
+	})
+	if err != nil {
+		t.Fatalf("creating the trade: %v", err)
+	}
+	return alice, bob, tradeID
+}
+
+/*
+Function	: Event types
+Description	: Read the events already sent to a subscription.
+Parameters 	: Event channel
+Return     	: event type list
+Private
+*/
+func eventTypes(subscription <-chan events.Event) []string {
+	var types []string
+	for {
+		select {
+		case event := <-subscription:
+			types = append(types, event.Type)
+		default:
+			return types
+		}
+	}
+}
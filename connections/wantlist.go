@@ -0,0 +1,350 @@
+/*
+File		: wantlist.go
+Description	: File that deals with the wantlists of the users, and finds the other users whose collections have the
+wanted cards (the matches).
+*/
+
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"CardaliaAPI/models"
+
+	"gorm.io/gorm"
+)
+
+// Returned when a card of a wantlist doesn't exist or it is from another user
+var ErrWantNotFound = errors.New("wanted card not found")
+
+// Copies of a card of a collection that are given to a wantlist
+type wantedCard struct {
+	card   models.CardOwnership
+	copies uint
+}
+
+/*
+Function	: Get wantlist
+Description	: Get the wantlist of the user.
+Parameters 	: context, userID
+Return     	: WantlistItem list, error
+*/
+func GetWantlistDB(ctx context.Context, userID uint) ([]models.WantlistItem, error) {
+	wantlist := []models.WantlistItem{}
+	err := models.DB.WithContext(ctx).Where("user_id = ?", userID).Order("want_id").Find(&wantlist).Error
+	return wantlist, err
+}
+
+/*
+Function	: Add want
+Description	: Add a card to the wantlist of the user. The card is wanted in any printing if only the oracle ID is sent,
+or in one printing if the version ID is sent. If the user already wants the card with the same condition and foil
+preference, the copies are added to it.
+
+Parameters 	: context, userID, WantRequest {oracle_id, version_id, count, min_condi, foil}
+Return     	: WantlistItem, error
+*/
+func AddWantDB(ctx context.Context, userID uint, request models.WantRequest) (models.WantlistItem, error) {
+	want := models.WantlistItem{
+		UserID:    userID,
+		OracleID:  request.OracleID,
+		VersionID: request.VersionID,
+		Count:     request.Count,
+		MinCondi:  request.MinCondi,
+		Foil:      request.Foil,
+	}
+	if want.OracleID == "" && want.VersionID == "" {
+		return want, errors.New("oracle_id or version_id is required")
+	}
+	if want.VersionID != "" {
+		card, err := Cards.CardByID(ctx, want.VersionID)
+		if err != nil {
+			return want, err
+		}
+		if want.OracleID != "" && want.OracleID != card.OracleID {
+			return want, fmt.Errorf("version %s is not a printing of %s", want.VersionID, want.OracleID)
+		}
+		want.OracleID = card.OracleID
+	}
+	if err := checkWantPreferences(&want); err != nil {
+		return want, err
+	}
+
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.WantlistItem
+		err := tx.Where("user_id = ? AND oracle_id = ? AND version_id = ? AND min_condi = ? AND foil = ?",
+			userID, want.OracleID, want.VersionID, want.MinCondi, want.Foil).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(&want).Error
+		}
+		if err != nil {
+			return err
+		}
+		existing.Count += want.Count
+		want = existing
+		return tx.Save(&want).Error
+	})
+	return want, err
+}
+
+/*
+Function	: Modify want
+Description	: Change the copies, minimum condition or foil preference of a card of the wantlist of the user.
+Parameters 	: context, userID, WantID, WantUpdate
+Return     	: WantlistItem, error
+*/
+func ModifyWantDB(ctx context.Context, userID uint, wantID uint, update models.WantUpdate) (models.WantlistItem, error) {
+	want, err := getUserWant(ctx, userID, wantID)
+	if err != nil {
+		return want, err
+	}
+	if update.Count != nil {
+		want.Count = *update.Count
+	}
+	if update.MinCondi != nil {
+		want.MinCondi = *update.MinCondi
+	}
+	if update.Foil != nil {
+		want.Foil = *update.Foil
+	}
+	if err := checkWantPreferences(&want); err != nil {
+		return want, err
+	}
+	return want, models.DB.WithContext(ctx).Save(&want).Error
+}
+
+/*
+Function	: Delete want
+Description	: Remove a card from the wantlist of the user.
+Parameters 	: context, userID, WantID
+Return     	: error
+*/
+func DeleteWantDB(ctx context.Context, userID uint, wantID uint) error {
+	want, err := getUserWant(ctx, userID, wantID)
+	if err != nil {
+		return err
+	}
+	return models.DB.WithContext(ctx).Delete(&want).Error
+}
+
+/*
+Function	: Get matches
+Description	: Get the other users that own cards of the wantlist of the user, the ones that can offer more wanted copies
+first. Only the copies that are not reserved by trades are offered, and every copy is only counted for one card of
+the wantlist.
+
+Parameters 	: context, userID
+Return     	: UserMatch list, error
+*/
+func GetMatchesDB(ctx context.Context, userID uint) ([]models.UserMatch, error) {
+	matches := []models.UserMatch{}
+	wantlist, err := GetWantlistDB(ctx, userID)
+	if err != nil || len(wantlist) == 0 {
+		return matches, err
+	}
+	users, offers, err := usersWithWantedCards(ctx, userID, wantlist)
+	if err != nil || len(users) == 0 {
+		return matches, err
+	}
+	var matchedCards []models.CardOwnership
+	for _, user := range users {
+		for _, offer := range offers[user] {
+			matchedCards = append(matchedCards, offer.card)
+		}
+	}
+	cards, err := buildCards(ctx, matchedCards)
+	if err != nil {
+		return matches, err
+	}
+	usernames, err := getUsernames(ctx, users)
+	if err != nil {
+		return matches, err
+	}
+	for _, user := range users {
+		match := models.UserMatch{Username: usernames[user]}
+		for _, offer := range offers[user] {
+			match.Matches += offer.copies
+			match.Cards = append(match.Cards, cards[0])
+			cards = cards[1:]
+		}
+		matches = append(matches, match)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Matches != matches[j].Matches {
+			return matches[i].Matches > matches[j].Matches
+		}
+		return matches[i].Username < matches[j].Username
+	})
+	return matches, nil
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Get user want
+Description	: Get a card of the wantlist of the user.
+Parameters 	: context, userID, WantID
+Return     	: WantlistItem, error (ErrWantNotFound if it doesn't exist or it is from another user)
+Private
+*/
+func getUserWant(ctx context.Context, userID uint, wantID uint) (models.WantlistItem, error) {
+	var want models.WantlistItem
+	err := models.DB.WithContext(ctx).Where("want_id = ? AND user_id = ?", wantID, userID).First(&want).Error
+	if err != nil {
+		return want, fmt.Errorf("%w: %d", ErrWantNotFound, wantID)
+	}
+	return want, nil
+}
+
+/*
+Function	: Check want preferences
+Description	: Check the minimum condition and foil preference of a wanted card. The condition names of other collection
+managers are converted to the Cardmarket grading, and an empty foil preference is any.
+
+Parameters 	: WantlistItem
+Return     	: error
+Private
+*/
+func checkWantPreferences(want *models.WantlistItem) error {
+	if want.MinCondi != "" {
+		condition := models.NormalizeCondition(want.MinCondi)
+		if condition == "" {
+			return fmt.Errorf("unknown condition %q", want.MinCondi)
+		}
+		want.MinCondi = condition
+	}
+	if want.Foil == "" {
+		want.Foil = models.FoilAny
+	}
+	return nil
+}
+
+/*
+Function	: Users with wanted cards
+Description	: Get the other users that have available copies of the cards of a wantlist, and the copies they can give.
+Parameters 	: context, userID of the wantlist, wantlist
+Return     	: userID list, userID -> wantedCard list map, error
+Private
+*/
+func usersWithWantedCards(ctx context.Context, userID uint, wantlist []models.WantlistItem) ([]uint, map[uint][]wantedCard, error) {
+	offers := map[uint][]wantedCard{}
+	var oracleIDs []string
+	wanted := map[string]bool{}
+	for _, want := range wantlist {
+		if !wanted[want.OracleID] {
+			wanted[want.OracleID] = true
+			oracleIDs = append(oracleIDs, want.OracleID)
+		}
+	}
+	if len(oracleIDs) == 0 {
+		return nil, offers, nil
+	}
+
+	// Get the cards of the other users with the wanted oracle IDs, as in getUsersWithCardDB
+	var cardOwnerships []models.CardOwnership
+	err := models.DB.WithContext(ctx).Where("oracle_id IN ? AND user_id != ? AND count != ?", oracleIDs, userID, 0).
+		Order("user_id, card_id").Find(&cardOwnerships).Error
+	if err != nil || len(cardOwnerships) == 0 {
+		return nil, offers, err
+	}
+	reserved, err := getReservedCopies(ctx, cardOwnerships)
+	if err != nil {
+		return nil, offers, err
+	}
+
+	var users []uint
+	for start := 0; start < len(cardOwnerships); {
+		end := start
+		for end < len(cardOwnerships) && cardOwnerships[end].User_id == cardOwnerships[start].User_id {
+			end++
+		}
+		user := cardOwnerships[start].User_id
+		if offer := matchWantlist(wantlist, cardOwnerships[start:end], reserved); len(offer) > 0 {
+			users = append(users, user)
+			offers[user] = offer
+		}
+		start = end
+	}
+	return users, offers, nil
+}
+
+/*
+Function	: Get reserved copies
+Description	: Get the copies of a list of cardOwnerships that are reserved by the negotiated trades.
+Parameters 	: context, CardOwnership list
+Return     	: CardID -> reserved copies map, error
+Private
+*/
+func getReservedCopies(ctx context.Context, cardOwnerships []models.CardOwnership) (map[uint]uint, error) {
+	var cardIDs []uint
+	for _, cardDB := range cardOwnerships {
+		cardIDs = append(cardIDs, cardDB.CardID)
+	}
+	return models.GetReservedCounts(models.DB.WithContext(ctx), cardIDs, 0)
+}
+
+/*
+Function	: Get usernames
+Description	: Get the usernames of a list of users.
+Parameters 	: context, userID list
+Return     	: userID -> username map, error
+Private
+*/
+func getUsernames(ctx context.Context, userIDs []uint) (map[uint]string, error) {
+	usernames := map[uint]string{}
+	var users []models.User
+	if err := models.DB.WithContext(ctx).Where("user_id IN ?", userIDs).Find(&users).Error; err != nil {
+		return usernames, err
+	}
+	for _, user := range users {
+		usernames[user.User_id] = user.Username
+	}
+	return usernames, nil
+}
+
+/*
+Function	: Match wantlist
+Description	: Give the available copies of the cards of a user to the cards of a wantlist, in the order of the
+wantlist, until every wanted card has all its copies.
+
+Parameters 	: wantlist, CardOwnership list of the user, CardID -> reserved copies map
+Return     	: wantedCard list (the cards that give copies, in the order of the CardOwnership list)
+Private
+*/
+func matchWantlist(wantlist []models.WantlistItem, cardOwnerships []models.CardOwnership, reserved map[uint]uint) []wantedCard {
+	available := make([]uint, len(cardOwnerships))
+	for i, cardDB := range cardOwnerships {
+		if cardDB.Count > reserved[cardDB.CardID] {
+			available[i] = cardDB.Count - reserved[cardDB.CardID]
+		}
+	}
+	given := make([]uint, len(cardOwnerships))
+	for _, want := range wantlist {
+		missing := want.Count
+		for i, cardDB := range cardOwnerships {
+			if missing == 0 {
+				break
+			}
+			if available[i] == 0 || !want.Accepts(cardDB) {
+				continue
+			}
+			copies := available[i]
+			if copies > missing {
+				copies = missing
+			}
+			available[i] -= copies
+			missing -= copies
+			given[i] += copies
+		}
+	}
+	var matched []wantedCard
+	for i, cardDB := range cardOwnerships {
+		if given[i] > 0 {
+			matched = append(matched, wantedCard{card: cardDB, copies: given[i]})
+		}
+	}
+	return matched
+}
@@ -0,0 +1,56 @@
+/*
+File		: wantlist_test.go
+Description	: Tests of the allocation of the copies of a collection to the cards of a wantlist.
+*/
+
+package connections
+
+import (
+	"reflect"
+	"testing"
+
+	"CardaliaAPI/models"
+)
+
+func TestMatchWantlist(t *testing.T) {
+	collection := []models.CardOwnership{
+		{CardID: 1, OracleID: "bolt", VersionID: "bolt-lea", Count: 2, Condi: "PL"},
+		{CardID: 2, OracleID: "bolt", VersionID: "bolt-m10", Count: 3, Condi: "NM"},
+		{CardID: 3, OracleID: "bolt", VersionID: "bolt-m10", Count: 1, Condi: "NM", Extras: "foil"},
+		{CardID: 4, OracleID: "ring", VersionID: "ring-c21", Count: 1, Condi: "NM"},
+	}
+	tests := []struct {
+		name     string
+		wantlist []models.WantlistItem
+		reserved map[uint]uint
+		want     map[uint]uint // CardID -> copies
+	}{
+		{"nothing wanted", nil, nil, map[uint]uint{}},
+		{"card not owned", []models.WantlistItem{{OracleID: "counterspell", Count: 4}}, nil, map[uint]uint{}},
+		{"in the order of the collection", []models.WantlistItem{{OracleID: "bolt", Count: 4}}, nil,
+			map[uint]uint{1: 2, 2: 2}},
+		{"more wanted than owned", []models.WantlistItem{{OracleID: "bolt", Count: 10}, {OracleID: "ring", Count: 2}}, nil,
+			map[uint]uint{1: 2, 2: 3, 3: 1, 4: 1}},
+		{"minimum condition", []models.WantlistItem{{OracleID: "bolt", Count: 4, MinCondi: "EX"}}, nil,
+			map[uint]uint{2: 3, 3: 1}},
+		{"foil only", []models.WantlistItem{{OracleID: "bolt", Count: 4, Foil: models.FoilOnly}}, nil,
+			map[uint]uint{3: 1}},
+		{"printing", []models.WantlistItem{{OracleID: "bolt", VersionID: "bolt-m10", Count: 2, Foil: models.FoilNonFoil}}, nil,
+			map[uint]uint{2: 2}},
+		{"reserved copies", []models.WantlistItem{{OracleID: "bolt", Count: 5}}, map[uint]uint{1: 2, 2: 1},
+			map[uint]uint{2: 2, 3: 1}},
+		{"copies are not given twice", []models.WantlistItem{
+			{OracleID: "bolt", VersionID: "bolt-m10", Count: 2},
+			{OracleID: "bolt", Count: 3, MinCondi: "NM"},
+		}, nil, map[uint]uint{2: 3, 3: 1}},
+	}
+	for _, test := range tests {
+		got := map[uint]uint{}
+		for _, matched := range matchWantlist(test.wantlist, collection, test.reserved) {
+			got[matched.card.CardID] = matched.copies
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+/*
+File		: webhooks.go
+Description	: File that deals with the webhooks of the users. Every event of a user (see utils/events) is also sent to
+his webhooks that want its type, as a POST signed with HMAC-SHA256 and the secret of the webhook. Failed deliveries are
+retried with exponential backoff, and every delivery is logged so it can be checked and sent again. The pending
+retries are stored in the deliveries, so the ones stopped by a restart are resumed when the API starts. The retries of a
+deleted webhook stop before their next attempt. The webhooks can
+only send to public addresses: the URL is checked when the webhook is created and every connection is checked again
+when it is opened, so a user can't reach the internal network of the API.
+*/
+
+package connections
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+
+	"gorm.io/gorm"
+)
+
+// Returned when a webhook or delivery doesn't exist or it is from another user
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Deliveries of the webhooks
+const (
+	webhookAttempts   = 5                // Attempts of a delivery
+	webhookBackoff    = 2 * time.Second  // Wait before the first retry, doubled on every retry
+	webhookTimeout    = 10 * time.Second // Timeout of an attempt
+	maxWebhooks       = 10               // Webhooks of a user
+	webhookDeliveries = 50               // Deliveries returned by GetWebhookDeliveriesDB
+)
+
+// Returned when a webhook would connect to an address that is not public
+var ErrWebhookAddress = errors.New("webhook address is not public")
+
+// Networks that are not public and are not covered by the net.IP checks (see publicAddress)
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "This network"
+	mustParseCIDR("100.64.0.0/10"), // Carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // Benchmarking
+}
+
+// HTTP client of the deliveries. It never uses a proxy and only connects to public addresses, also after redirects
+// and if the DNS of the host changes after the webhook was created.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		Proxy:       nil,
+		DialContext: (&net.Dialer{Timeout: webhookTimeout, Control: checkDialAddress}).DialContext,
+	},
+}
+
+// Deliveries running in the background
+var runningDeliveries sync.WaitGroup
+
+// Context of the deliveries, cancelled by StopWebhooks
+var webhooksCtx, stopWebhooks = context.WithCancel(context.Background())
+
+/*
+Function	: Wait webhooks
+Description	: Wait until the deliveries running in the background are finished. Used by the commands, that exit when
+their work is done.
+
+Parameters 	:
+Return     	:
+*/
+func WaitWebhooks() {
+	runningDeliveries.Wait()
+}
+
+/*
+Function	: Stop webhooks
+Description	: Stop the deliveries running in the background and wait for them. The deliveries stopped while waiting
+for a retry or during an attempt stay pending, so they are resumed when the API starts again.
+
+Parameters 	:
+Return     	:
+*/
+func StopWebhooks() {
+	stopWebhooks()
+	runningDeliveries.Wait()
+}
+
+/*
+Function	: Create webhook
+Description	: Register a webhook of the user. The secret used to sign the deliveries is generated.
+Parameters 	: context, userID, WebhookRequest {url, events}
+Return     	: Webhook, secret, error
+*/
+func CreateWebhookDB(ctx context.Context, userID uint, request models.WebhookRequest) (models.Webhook, string, error) {
+	webhook := models.Webhook{UserID: userID, URL: request.URL, Events: request.Events}
+	if err := checkWebhookURL(ctx, request.URL); err != nil {
+		return webhook, "", err
+	}
+	for _, eventType := range request.Events {
+		if !events.IsType(eventType) {
+			return webhook, "", fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	var webhooks int64
+	if err := models.DB.WithContext(ctx).Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&webhooks).Error; err != nil {
+		return webhook, "", err
+	}
+	if webhooks >= maxWebhooks {
+		return webhook, "", fmt.Errorf("a user can't have more than %d webhooks", maxWebhooks)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return webhook, "", err
+	}
+	webhook.Secret = hex.EncodeToString(secret)
+	return webhook, webhook.Secret, models.DB.WithContext(ctx).Create(&webhook).Error
+}
+
+/*
+Function	: Get webhooks
+Description	: Get the webhooks of the user.
+Parameters 	: context, userID
+Return     	: Webhook list, error
+*/
+func GetWebhooksDB(ctx context.Context, userID uint) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	err := models.DB.WithContext(ctx).Where("user_id = ?", userID).Order("webhook_id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+/*
+Function	: Delete webhook
+Description	: Delete a webhook of the user and its deliveries.
+Parameters 	: context, userID, WebhookID
+Return     	: error
+*/
+func DeleteWebhookDB(ctx context.Context, userID uint, webhookID uint) error {
+	webhook, err := getUserWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return err
+	}
+	if err := models.DB.WithContext(ctx).Where("webhook_id = ?", webhook.WebhookID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+	return models.DB.WithContext(ctx).Delete(&webhook).Error
+}
+
+/*
+Function	: Get webhook deliveries
+Description	: Get the last deliveries of a webhook of the user, the newest first.
+Parameters 	: context, userID, WebhookID
+Return     	: WebhookDelivery list, error
+*/
+func GetWebhookDeliveriesDB(ctx context.Context, userID uint, webhookID uint) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	if _, err := getUserWebhook(ctx, userID, webhookID); err != nil {
+		return deliveries, err
+	}
+	err := models.DB.WithContext(ctx).Where("webhook_id = ?", webhookID).Order("delivery_id DESC").
+		Limit(webhookDeliveries).Find(&deliveries).Error
+	return deliveries, err
+}
+
+/*
+Function	: Redeliver
+Description	: Send again the payload of a delivery of a webhook of the user, as a new delivery.
+Parameters 	: context, userID, WebhookID, DeliveryID
+Return     	: new WebhookDelivery, error
+*/
+func RedeliverDB(ctx context.Context, userID uint, webhookID uint, deliveryID uint) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	webhook, err := getUserWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return delivery, err
+	}
+	err = models.DB.WithContext(ctx).Where("delivery_id = ? AND webhook_id = ?", deliveryID, webhookID).First(&delivery).Error
+	if err != nil {
+		return delivery, fmt.Errorf("%w: delivery %d", ErrWebhookNotFound, deliveryID)
+	}
+	redelivery := models.WebhookDelivery{WebhookID: webhook.WebhookID, EventType: delivery.EventType, Payload: delivery.Payload,
+		Pending: true}
+	if err := models.DB.WithContext(ctx).Create(&redelivery).Error; err != nil {
+		return redelivery, err
+	}
+	runningDeliveries.Add(1)
+	go deliver(webhook, redelivery)
+	return redelivery, nil
+}
+
+/*
+Function	: Resume webhook deliveries
+Description	: Continue in the background the deliveries that were pending when the API stopped, at the time of their
+next attempt. Called when the server starts.
+
+Parameters 	:
+Return     	:
+*/
+func ResumeWebhookDeliveries() {
+	var deliveries []models.WebhookDelivery
+	if err := models.DB.Where("pending = ?", true).Order("delivery_id").Find(&deliveries).Error; err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+	webhooks := map[uint]*models.Webhook{}
+	for _, delivery := range deliveries {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			webhook = &models.Webhook{}
+			if err := models.DB.First(webhook, delivery.WebhookID).Error; err != nil {
+				webhook = nil
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+		if webhook == nil {
+			// The webhook was deleted: nothing to send
+			delivery.Pending = false
+			delivery.NextAttemptAt = nil
+			if err := models.DB.Save(&delivery).Error; err != nil {
+				log.Println("webhooks:", err)
+			}
+			continue
+		}
+		runningDeliveries.Add(1)
+		go deliver(*webhook, delivery)
+	}
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: Get user webhook
+Description	: Get a webhook of the user.
+Parameters 	: context, userID, WebhookID
+Return     	: Webhook, error (ErrWebhookNotFound if it doesn't exist or it is from another user)
+Private
+*/
+func getUserWebhook(ctx context.Context, userID uint, webhookID uint) (models.Webhook, error) {
+	var webhook models.Webhook
+	err := models.DB.WithContext(ctx).Where("webhook_id = ? AND user_id = ?", webhookID, userID).First(&webhook).Error
+	if err != nil {
+		return webhook, fmt.Errorf("%w: %d", ErrWebhookNotFound, webhookID)
+	}
+	return webhook, nil
+}
+
+/*
+Function	: Check webhook URL
+Description	: Check that a webhook URL is http or https and that all the addresses of its host are public.
+Parameters 	: context, URL
+Return     	: error (ErrWebhookAddress if an address is not public)
+Private
+*/
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Hostname() == "" {
+		return fmt.Errorf("invalid webhook url %q", rawURL)
+	}
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsedURL.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid webhook url %q: %w", rawURL, err)
+	}
+	for _, address := range addresses {
+		if !publicAddress(address.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookAddress, parsedURL.Hostname(), address.IP)
+		}
+	}
+	return nil
+}
+
+/*
+Function	: Check dial address
+Description	: Control function of the dialer of the webhooks. Rejects the connections to addresses that are not
+public; the address is already resolved, so a host that changed its DNS records is also checked.
+
+Parameters 	: network, address (ip:port), raw connection
+Return     	: error (ErrWebhookAddress if the address is not public)
+Private
+*/
+func checkDialAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+	}
+	return nil
+}
+
+/*
+Function	: Public address
+Description	: Check if an IP address is public: not loopback, private, link-local, multicast, unspecified or reserved.
+Parameters 	: IP
+Return     	: bool
+Private
+*/
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Function	: Must parse CIDR
+Description	: Parse a network of reservedNetworks. Panics if it is not valid.
+Parameters 	: CIDR
+Return     	: network
+Private
+*/
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+/*
+Function	: Publish event
+Description	: Send an event to the users (see utils/events) and to their webhooks that want it. Called when the changes
+of the event are committed.
+
+Parameters 	: event type, data, userID list
+Return     	:
+Private
+*/
+func publishEvent(eventType string, data interface{}, userIDs ...uint) {
+	event := events.Event{Type: eventType, Data: data, Time: time.Now()}
+	events.Events.Publish(event, userIDs...)
+	runningDeliveries.Add(1)
+	go dispatchWebhooks(event, userIDs)
+}
+
+/*
+Function	: Dispatch webhooks
+Description	: Send an event to the webhooks of the users that want it.
+Parameters 	: Event, userID list
+Return     	:
+Private
+*/
+func dispatchWebhooks(event events.Event, userIDs []uint) {
+	defer runningDeliveries.Done()
+	var webhooks []models.Webhook
+	if err := models.DB.Where("user_id IN ?", userIDs).Find(&webhooks).Error; err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.WantsEvent(event.Type) {
+			continue
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Println("webhooks:", err)
+			return
+		}
+		delivery := models.WebhookDelivery{WebhookID: webhook.WebhookID, EventType: event.Type, Payload: string(payload),
+			Pending: true}
+		if err := models.DB.Create(&delivery).Error; err != nil {
+			log.Println("webhooks:", err)
+			continue
+		}
+		runningDeliveries.Add(1)
+		go deliver(webhook, delivery)
+	}
+}
+
+/*
+Function	: Deliver
+Description	: Send a delivery to its webhook until it succeeds or there are no attempts left, waiting more after every
+failed attempt. The result of every attempt and the time of the next one are saved in the delivery, so it can be
+resumed (see ResumeWebhookDeliveries). It stops when the webhook is deleted or the deliveries are stopped.
+
+Parameters 	: Webhook, WebhookDelivery
+Return     	:
+Private
+*/
+func deliver(webhook models.Webhook, delivery models.WebhookDelivery) {
+	defer runningDeliveries.Done()
+	for {
+		if delivery.NextAttemptAt != nil {
+			if err := sleep(webhooksCtx, time.Until(*delivery.NextAttemptAt)); err != nil {
+				return
+			}
+		}
+		if err := models.DB.WithContext(webhooksCtx).First(&webhook, webhook.WebhookID).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) && webhooksCtx.Err() == nil {
+				log.Println("webhooks:", err)
+			}
+			return
+		}
+		delivery.Attempts++
+		start := time.Now()
+		statusCode, err := sendDelivery(webhooksCtx, webhook, delivery)
+		if webhooksCtx.Err() != nil {
+			// Stopped during the attempt: it is made again when the delivery is resumed
+			return
+		}
+		delivery.LatencyMs = time.Since(start).Milliseconds()
+		delivery.StatusCode = statusCode
+		delivery.Success = err == nil
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+			if len(delivery.Error) > 500 {
+				delivery.Error = delivery.Error[:500]
+			}
+		}
+		delivery.Pending = !delivery.Success && delivery.Attempts < webhookAttempts
+		delivery.NextAttemptAt = nil
+		if delivery.Pending {
+			next := time.Now().Add(webhookBackoff << (delivery.Attempts - 1))
+			delivery.NextAttemptAt = &next
+		}
+		// Update, never insert: the delivery is gone if the webhook was deleted during the attempt
+		result := models.DB.Model(&delivery).Select("*").Updates(&delivery)
+		if result.Error != nil {
+			log.Println("webhooks:", result.Error)
+		}
+		if !delivery.Pending || result.RowsAffected == 0 {
+			return
+		}
+	}
+}
+
+/*
+Function	: Send delivery
+Description	: Make an attempt of a delivery. The body is signed in the X-Cardalia-Signature header
+("sha256=" + hex HMAC-SHA256 of the body with the secret of the webhook).
+
+Parameters 	: context, Webhook, WebhookDelivery
+Return     	: status code (0 if there was no response), error (also if the status code is not 2xx)
+Private
+*/
+func sendDelivery(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "CardaliaAPI-Webhooks")
+	request.Header.Set("X-Cardalia-Event", delivery.EventType)
+	request.Header.Set("X-Cardalia-Delivery", strconv.FormatUint(uint64(delivery.DeliveryID), 10))
+	request.Header.Set("X-Cardalia-Signature", "sha256="+signPayload(webhook.Secret, delivery.Payload))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+/*
+Function	: Sign payload
+Description	: Sign the payload of a delivery.
+Parameters 	: secret, payload
+Return     	: hex HMAC-SHA256
+Private
+*/
+func signPayload(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,194 @@
+/*
+File		: webhooks_test.go
+Description	: Tests of the addresses that the webhooks can be sent to and of the retries of the deliveries.
+*/
+
+package connections
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.1.2.3", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := publicAddress(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"8.8.8.8:443", false},
+		{"127.0.0.1:80", true},
+		{"[::1]:8080", true},
+		{"10.0.0.5:443", true},
+		{"example.com:443", true}, // Only resolved addresses are dialed
+	}
+	for _, test := range tests {
+		if err := checkDialAddress("tcp", test.address, nil); (err != nil) != test.wantErr {
+			t.Errorf("checkDialAddress(%s) = %v, want error %v", test.address, err, test.wantErr)
+		}
+	}
+}
+
+/*
+Function	: Start test delivery
+Description	: Create a webhook of a new user to a test server and a pending delivery, and deliver it in the background.
+The deliveries are sent with the client of the test server, that is local.
+
+Parameters 	: test, handler of the test server
+Return     	: Webhook, channel closed when the delivery stops
+*/
+func startTestDelivery(t *testing.T, handler http.HandlerFunc) (models.Webhook, chan struct{}) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	previous := webhookClient
+	webhookClient = srv.Client()
+	t.Cleanup(func() { webhookClient = previous })
+
+	user, _ := createTestUser(t, "alice")
+	webhook := models.Webhook{UserID: user.User_id, URL: srv.URL, Secret: "secret", Events: []string{events.TradeCreated}}
+	if err := models.DB.Create(&webhook).Error; err != nil {
+		t.Fatal(err)
+	}
+	delivery := models.WebhookDelivery{WebhookID: webhook.WebhookID, EventType: events.TradeCreated, Payload: "{}",
+		Pending: true}
+	if err := models.DB.Create(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	runningDeliveries.Add(1)
+	go func() {
+		deliver(webhook, delivery)
+		close(done)
+	}()
+	return webhook, done
+}
+
+/*
+Function	: Wait delivery
+Description	: Fail the test if a delivery doesn't stop in time.
+Parameters 	: test, channel closed when the delivery stops, time
+Return     	:
+*/
+func waitDelivery(t *testing.T, done chan struct{}, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("the delivery didn't stop in %v", timeout)
+	}
+}
+
+func TestDeliverRetriesUntilSuccess(t *testing.T) {
+	useTestDB(t)
+	var attempts int32
+	webhook, done := startTestDelivery(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	waitDelivery(t, done, webhookBackoff+5*time.Second)
+
+	var delivery models.WebhookDelivery
+	if err := models.DB.Where("webhook_id = ?", webhook.WebhookID).First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !delivery.Success || delivery.Pending || delivery.Attempts != 2 || delivery.StatusCode != http.StatusOK ||
+		delivery.NextAttemptAt != nil {
+		t.Errorf("got %+v, want a successful delivery after 2 attempts", delivery)
+	}
+}
+
+func TestDeliverStopsWhenTheWebhookIsDeleted(t *testing.T) {
+	tests := []struct {
+		name         string
+		deleteDuring bool // Delete the webhook during the first attempt, or while waiting for the retry
+	}{
+		{"during an attempt", true},
+		{"before a retry", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDB(t)
+			var attempts int32
+			webhook, done := startTestDelivery(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				if test.deleteDuring {
+					deleteTestWebhooks(t)
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+			if !test.deleteDuring {
+				// Once the failed attempt is saved
+				for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+					var delivery models.WebhookDelivery
+					models.DB.Where("webhook_id = ?", webhook.WebhookID).First(&delivery)
+					if delivery.Attempts == 1 {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				deleteTestWebhooks(t)
+			}
+			waitDelivery(t, done, webhookBackoff+5*time.Second)
+
+			var deliveries int64
+			if err := models.DB.Model(&models.WebhookDelivery{}).Count(&deliveries).Error; err != nil {
+				t.Fatal(err)
+			}
+			if deliveries != 0 || atomic.LoadInt32(&attempts) != 1 {
+				t.Errorf("got %d deliveries after %d attempts, want none after 1 attempt", deliveries, attempts)
+			}
+		})
+	}
+}
+
+/*
+Function	: Delete test webhooks
+Description	: Delete all the webhooks of the test DB and their deliveries, like DeleteWebhookDB.
+Parameters 	: test
+Return     	:
+*/
+func deleteTestWebhooks(t *testing.T) {
+	if err := models.DB.Where("1 = 1").Delete(&models.WebhookDelivery{}).Error; err != nil {
+		t.Error(err)
+	}
+	if err := models.DB.Where("1 = 1").Delete(&models.Webhook{}).Error; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+File		: workerPool.go
+Description	: Bounded worker pool used to hydrate cards concurrently. The number of workers is HYDRATION_WORKERS.
+The Scryfall rate limit is shared by all the workers, so more workers never means more requests per second.
+*/
+
+package connections
+
+import (
+	"context"
+	"sync"
+)
+
+// Default number of workers of a pool
+const defaultHydrationWorkers = 4
+
+/*
+Function	: Run pool
+Description	: Run a task for every index from 0 to n-1 with a bounded number of workers. When a task fails or the context
+is cancelled (the client disconnected), the tasks that didn't start are skipped and the running ones see the
+context cancelled.
+
+Parameters 	: context, number of tasks, task
+Return     	: first error
+*/
+func runPool(ctx context.Context, n int, task func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := envInt("HYDRATION_WORKERS", defaultHydrationWorkers)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	tasks := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				if err := task(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	// Send the tasks until all are sent or the context is cancelled
+sending:
+	for i := 0; i < n; i++ {
+		select {
+		case tasks <- i:
+		case <-ctx.Done():
+			break sending
+		}
+	}
+	close(tasks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -6,7 +6,8 @@ CREATE TABLE `users` (
   `user_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
   `username` varchar(50) NOT NULL UNIQUE,
   `email` varchar(50) NOT NULL UNIQUE,
-  `password` varchar(70) NOT NULL
+  `password` varchar(70) NOT NULL,
+  `collection_version` int(11) NOT NULL DEFAULT 0 /* Changes on every write of the collection (ETag) */
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
 
 CREATE TABLE `card_ownerships` (
@@ -33,4 +34,240 @@ CREATE TABLE `trades` (
 	`status`	TINYINT SIGNED,
     KEY `FK_card_id` (`card_id`),
 	CONSTRAINT `FK_card_id` FOREIGN KEY (`card_id`) REFERENCES `card_ownerships` (`card_id`) ON DELETE NO ACTION ON UPDATE NO ACTION 
-) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_headers` ( /* A trade between two users. Replaces `trades`, that is only read to migrate the old trades */
+    `trade_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `user_id_origin` int(11) NOT NULL, /* The user that started the trade */
+    `user_id_owner` int(11) NOT NULL,
+    `state` varchar(20) NOT NULL, /* proposed, countered, accepted, shipped, received, completed, cancelled or expired */
+    `origin_checked` tinyint(1),
+    `owner_checked` tinyint(1),
+    `created_at` datetime(3),
+    `updated_at` datetime(3),
+    `closed_at` datetime(3),
+    `revision` int(11) NOT NULL DEFAULT 0, /* Number of the current revision of the cards */
+    `cycle_id` int(11) NOT NULL DEFAULT 0, /* Trade cycle of the trade, 0 if it is not in one */
+    `shipped_by` int(11) NOT NULL DEFAULT 0, /* User that shipped the cards, 0 if they were not shipped */
+    KEY `idx_trade_headers_user_id_origin` (`user_id_origin`),
+    KEY `idx_trade_headers_user_id_owner` (`user_id_owner`),
+    KEY `idx_trade_headers_cycle_id` (`cycle_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_items` ( /* Cards of a trade */
+    `item_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `trade_id` int(11) NOT NULL,
+    `card_id` int(11) NOT NULL,
+    `user_id` int(11) NOT NULL, /* Owner of the card, that gives it to the other user */
+    `card_select` int(11) NOT NULL,
+    KEY `idx_trade_items_trade_id` (`trade_id`),
+    KEY `idx_trade_items_card_id` (`card_id`),
+    CONSTRAINT `FK_trade_items_trade_id` FOREIGN KEY (`trade_id`) REFERENCES `trade_headers` (`trade_id`) ON DELETE CASCADE ON UPDATE NO ACTION,
+    CONSTRAINT `FK_trade_items_card_id` FOREIGN KEY (`card_id`) REFERENCES `card_ownerships` (`card_id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_revisions` ( /* Proposals of the cards of the trades. Never modified */
+    `revision_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `trade_id` int(11) NOT NULL,
+    `number` int(11) NOT NULL, /* 1 for the first proposal of the trade */
+    `user_id` int(11) NOT NULL, /* User that made the proposal */
+    `created_at` datetime(3),
+    KEY `idx_trade_revisions_trade_id` (`trade_id`),
+    CONSTRAINT `FK_trade_revisions_trade_id` FOREIGN KEY (`trade_id`) REFERENCES `trade_headers` (`trade_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_revision_items` ( /* Cards of a revision of a trade */
+    `item_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `revision_id` int(11) NOT NULL,
+    `card_id` int(11) NOT NULL,
+    `user_id` int(11) NOT NULL, /* Owner of the card */
+    `card_select` int(11) NOT NULL,
+    KEY `idx_trade_revision_items_revision_id` (`revision_id`),
+    CONSTRAINT `FK_trade_revision_items_revision_id` FOREIGN KEY (`revision_id`) REFERENCES `trade_revisions` (`revision_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_transitions` ( /* Changes of state of the trades */
+    `transition_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `trade_id` int(11) NOT NULL,
+    `from_state` varchar(20), /* Empty when the trade was created */
+    `to_state` varchar(20) NOT NULL,
+    `user_id` int(11), /* User that changed the state, 0 if the trade expired */
+    `created_at` datetime(3),
+    KEY `idx_trade_transitions_trade_id` (`trade_id`),
+    CONSTRAINT `FK_trade_transitions_trade_id` FOREIGN KEY (`trade_id`) REFERENCES `trade_headers` (`trade_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_messages` ( /* Messages between the users of a trade */
+    `message_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `trade_id` int(11) NOT NULL,
+    `user_id` int(11) NOT NULL, /* User that sent the message */
+    `body` text NOT NULL,
+    `created_at` datetime(3),
+    `read_at` datetime(3), /* When the other user read the message */
+    KEY `idx_trade_messages_trade_id` (`trade_id`),
+    CONSTRAINT `FK_trade_messages_trade_id` FOREIGN KEY (`trade_id`) REFERENCES `trade_headers` (`trade_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `webhooks` ( /* URLs where the events of the users are sent */
+    `webhook_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `user_id` int(11) NOT NULL,
+    `url` varchar(500) NOT NULL,
+    `secret` varchar(64) NOT NULL, /* Key of the HMAC-SHA256 signatures */
+    `events` varchar(500) NOT NULL, /* Event types, separated by commas */
+    `created_at` datetime(3),
+    KEY `idx_webhooks_user_id` (`user_id`),
+    CONSTRAINT `FK_webhooks_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `webhook_deliveries` ( /* Log of the deliveries of the webhooks */
+    `delivery_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `webhook_id` int(11) NOT NULL,
+    `event_type` varchar(50) NOT NULL,
+    `payload` text NOT NULL,
+    `attempts` int(11),
+    `status_code` int(11), /* Of the last attempt, 0 if there was no response */
+    `latency_ms` bigint, /* Of the last attempt */
+    `error` varchar(500),
+    `success` tinyint(1),
+    `pending` tinyint(1) NOT NULL, /* There are attempts left, resumed when the API starts */
+    `next_attempt_at` datetime(3),
+    `created_at` datetime(3),
+    `updated_at` datetime(3),
+    KEY `idx_webhook_deliveries_webhook_id` (`webhook_id`),
+    KEY `idx_webhook_deliveries_pending` (`pending`),
+    CONSTRAINT `FK_webhook_deliveries_webhook_id` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`webhook_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `notification_preferences` ( /* Email notifications that the users want, all of them if there is no row */
+    `user_id` int(11) PRIMARY KEY NOT NULL,
+    `proposals` tinyint(1) NOT NULL,
+    `acceptances` tinyint(1) NOT NULL,
+    `completions` tinyint(1) NOT NULL,
+    `messages` tinyint(1) NOT NULL,
+    `price_alerts` tinyint(1) NOT NULL,
+    `digest` tinyint(1) NOT NULL, /* One email a day instead of one per notification */
+    CONSTRAINT `FK_notification_preferences_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `pending_notifications` ( /* Notifications waiting for the daily digest */
+    `notification_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `user_id` int(11) NOT NULL,
+    `summary` varchar(255) NOT NULL,
+    `created_at` datetime(3),
+    KEY `idx_pending_notifications_user_id` (`user_id`),
+    KEY `idx_pending_notifications_created_at` (`created_at`),
+    CONSTRAINT `FK_pending_notifications_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `card_prices` ( /* Last known prices of the card versions, from Scryfall. NULL if unknown */
+    `version_id` varchar(50) PRIMARY KEY NOT NULL,
+    `usd` decimal(10,2),
+    `usd_foil` decimal(10,2),
+    `eur` decimal(10,2),
+    `eur_foil` decimal(10,2),
+    `tix` decimal(10,2),
+    `updated_at` datetime(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `price_snapshots` ( /* Daily copy of the card_prices, for the price history */
+    `version_id` varchar(50) NOT NULL,
+    `date` date NOT NULL,
+    `usd` decimal(10,2),
+    `usd_foil` decimal(10,2),
+    `eur` decimal(10,2),
+    `eur_foil` decimal(10,2),
+    `tix` decimal(10,2),
+    PRIMARY KEY (`version_id`, `date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `collection_value_snapshots` ( /* Daily value of the collections */
+    `user_id` int(11) NOT NULL,
+    `date` date NOT NULL,
+    `usd` decimal(12,2) NOT NULL,
+    `eur` decimal(12,2) NOT NULL,
+    `tix` decimal(12,2) NOT NULL,
+    PRIMARY KEY (`user_id`, `date`),
+    CONSTRAINT `FK_collection_value_snapshots_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `price_alerts` ( /* The user is notified when a card (or any card of his collection if version_id is empty) rises or drops a percentage in some days */
+    `alert_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `user_id` int(11) NOT NULL,
+    `version_id` varchar(50) NOT NULL,
+    `foil` tinyint(1) NOT NULL,
+    `currency` varchar(20) NOT NULL,
+    `direction` varchar(20) NOT NULL, /* rise or drop */
+    `percent` double NOT NULL,
+    `days` bigint NOT NULL,
+    `created_at` datetime(3),
+    KEY `idx_price_alerts_user_id` (`user_id`),
+    CONSTRAINT `FK_price_alerts_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `price_alert_hits` ( /* Last time that a card triggered a price alert */
+    `alert_id` int(11) NOT NULL,
+    `version_id` varchar(50) NOT NULL,
+    `foil` tinyint(1) NOT NULL,
+    `old_price` decimal(10,2) NOT NULL,
+    `new_price` decimal(10,2) NOT NULL,
+    `triggered_at` datetime(3) NOT NULL,
+    PRIMARY KEY (`alert_id`, `version_id`, `foil`),
+    CONSTRAINT `FK_price_alert_hits_alert_id` FOREIGN KEY (`alert_id`) REFERENCES `price_alerts` (`alert_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `wantlist_items` ( /* Cards wanted by the users */
+    `want_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `user_id` int(11) NOT NULL,
+    `oracle_id` varchar(50) NOT NULL,
+    `version_id` varchar(50) NOT NULL, /* Empty for any printing */
+    `count` int(11) NOT NULL,
+    `min_condi` varchar(50) NOT NULL, /* Worst condition accepted, empty for any */
+    `foil` varchar(50) NOT NULL, /* any, foil or nonfoil */
+    `created_at` datetime(3),
+    KEY `idx_wantlist_items_user_id` (`user_id`),
+    KEY `idx_wantlist_items_oracle_id` (`oracle_id`),
+    CONSTRAINT `FK_wantlist_items_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_cycles` ( /* Rings of trades between 3 or more users, found by the find-cycles job */
+    `cycle_id` int(11) PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    `state` varchar(20) NOT NULL, /* proposed, accepted, declined or expired */
+    `ring` varchar(255) NOT NULL, /* User IDs in the order of the ring, from the lowest */
+    `created_at` datetime(3),
+    `updated_at` datetime(3),
+    `closed_at` datetime(3),
+    KEY `idx_trade_cycles_ring` (`ring`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `trade_cycle_members` ( /* Users of a trade cycle and their answers */
+    `cycle_id` int(11) NOT NULL,
+    `user_id` int(11) NOT NULL,
+    `position` int(11) NOT NULL, /* Gives cards to the next position, the last one to the first one */
+    `answer` varchar(20) NOT NULL, /* accepted or declined, empty until the user answers */
+    `answered_at` datetime(3),
+    PRIMARY KEY (`cycle_id`, `user_id`),
+    KEY `idx_trade_cycle_members_user_id` (`user_id`),
+    CONSTRAINT `FK_trade_cycle_members_cycle_id` FOREIGN KEY (`cycle_id`) REFERENCES `trade_cycles` (`cycle_id`) ON DELETE CASCADE ON UPDATE NO ACTION,
+    CONSTRAINT `FK_trade_cycle_members_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `cards` ( /* Local catalog of the Scryfall cards. One row per card version */
+    `id` varchar(50) PRIMARY KEY NOT NULL, /* Scryfall ID (version_id in card_ownerships) */
+    `oracle_id` varchar(50),
+    `name` varchar(200) NOT NULL,
+    `set` varchar(10),
+    `set_name` varchar(100),
+    `collector_number` varchar(20),
+    `games` varchar(100),
+    `released_at` varchar(10),
+    `image_small` varchar(255),
+    `image_large` varchar(255),
+    `updated_at` datetime(3),
+    KEY `idx_cards_oracle_id` (`oracle_id`),
+    KEY `idx_cards_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
+
+CREATE TABLE `card_printings` ( /* Card names with all their versions stored in the catalog */
+    `name` varchar(200) PRIMARY KEY NOT NULL,
+    `updated_at` datetime(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_general_ci;
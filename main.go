@@ -7,11 +7,14 @@ The CardaliaAPI diagram from the memory of the project (Figure 8) is very useful
 package main
 
 import (
+	"CardaliaAPI/connections"
 	"CardaliaAPI/middlewares"
 	"CardaliaAPI/models"
 	"CardaliaAPI/routes"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -19,15 +22,23 @@ import (
 
 func main() {
 
+	// Run a subcommand (import-bulk, ...) instead of the server
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	models.ConnectDataBase()
+	connections.ConnectCardProvider()
+	connections.ConnectMailer()
+	connections.ResumeWebhookDeliveries()
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 	}))
 
@@ -37,6 +48,7 @@ func main() {
 
 	router.GET("/cards/:autocomplete", routes.GetCardsByName)
 	router.GET("/cards/versions/:cardname", routes.GetCardVersions)
+	router.GET("/cards/:autocomplete/prices", routes.GetPriceHistory) // :autocomplete is the version ID (gin needs one wildcard name)
 
 	router.GET("/user/collection/:username", routes.GetUserCollectionByName)
 
@@ -48,6 +60,14 @@ func main() {
 
 	protected.POST("/user/collection", routes.SaveCollection)
 	protected.GET("/user/collection", routes.GetCollection)
+	protected.POST("/user/collection/import", routes.ImportCollection)
+	protected.GET("/user/collection/export", routes.ExportCollection)
+	protected.GET("/user/collection/value", routes.GetCollectionValue)
+	protected.GET("/user/collection/value/history", routes.GetCollectionValueHistory)
+	protected.POST("/user/collection/items", routes.AddCollectionItem)
+	protected.PATCH("/user/collection/items", routes.PatchCollection)
+	protected.PATCH("/user/collection/items/:card_id", routes.ModifyCollectionItem)
+	protected.DELETE("/user/collection/items/:card_id", routes.DeleteCollectionItem)
 
 	protected.GET("/users/collections/:card_id", routes.GetAllUserCollectionsByCardId)
 
@@ -56,6 +76,42 @@ func main() {
 	protected.DELETE("/user/trade/:username", routes.DeleteTrade)
 
 	protected.GET("/user/trades", routes.GetTrades)
+	protected.GET("/trades", routes.GetTrades)
+	protected.POST("/trades", routes.CreateTrade)
+	protected.GET("/trades/:id", routes.GetTrade)
+	protected.PUT("/trades/:id", routes.UpdateTrade)
+	protected.DELETE("/trades/:id", routes.CancelTrade)
+	protected.POST("/trades/:id/state", routes.ChangeTradeState)
+	protected.POST("/trades/:id/accept", routes.AcceptTrade)
+	protected.GET("/trades/:id/revisions", routes.GetTradeRevisions)
+	protected.POST("/trades/:id/messages", routes.SendTradeMessage)
+	protected.GET("/trades/:id/messages", routes.GetTradeMessages)
+
+	protected.GET("/trade-cycles", routes.GetTradeCycles)
+	protected.GET("/trade-cycles/:id", routes.GetTradeCycle)
+	protected.POST("/trade-cycles/:id/answer", routes.AnswerTradeCycle)
+
+	protected.GET("/events", routes.GetEvents)
+
+	protected.GET("/user/wantlist", routes.GetWantlist)
+	protected.POST("/user/wantlist", routes.AddWant)
+	protected.PUT("/user/wantlist/:id", routes.ModifyWant)
+	protected.DELETE("/user/wantlist/:id", routes.DeleteWant)
+	protected.GET("/user/matches", routes.GetMatches)
+	protected.GET("/user/matches/trades", routes.GetTradeSuggestions)
+
+	protected.GET("/user/price-alerts", routes.GetPriceAlerts)
+	protected.POST("/user/price-alerts", routes.AddPriceAlert)
+	protected.DELETE("/user/price-alerts/:id", routes.DeletePriceAlert)
+
+	protected.GET("/user/notifications", routes.GetNotificationPreferences)
+	protected.PUT("/user/notifications", routes.SaveNotificationPreferences)
+
+	protected.POST("/user/webhooks", routes.CreateWebhook)
+	protected.GET("/user/webhooks", routes.GetWebhooks)
+	protected.DELETE("/user/webhooks/:id", routes.DeleteWebhook)
+	protected.GET("/user/webhooks/:id/deliveries", routes.GetWebhookDeliveries)
+	protected.POST("/user/webhooks/:id/deliveries/:delivery_id/redeliver", routes.Redeliver)
 
 	host := os.Getenv("HOST")
 	port := os.Getenv("PORT")
@@ -63,6 +119,16 @@ func main() {
 	if port == "" {
 		log.Fatal("$PORT and $HOST must be set")
 	}
+
+	// Stop the webhook deliveries before exiting, the pending ones are resumed when the server starts again
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		connections.StopWebhooks()
+		os.Exit(0)
+	}()
+
 	router.Run(host + ":" + port)
 
 }
@@ -36,6 +36,9 @@ type Card struct {
 	CollectorNumber string    `json:"collector_number"`
 	Extras          string    `json:"extras"`
 	Condi           string    `json:"condi"`
+	Reserved        int       `json:"reserved"`   // Copies offered in negotiated trades
+	Available       int       `json:"available"`  // Copies that can still be offered (count - reserved)
+	Unresolved      bool      `json:"unresolved"` // The card provider doesn't know the version, only the IDs are set
 }
 
 // Used to get the info of the version of a card from the Scryfall API and also used to send a cardVersion to the frontend.
@@ -54,6 +57,25 @@ type Image_url struct {
 	Large string `json:"large"`
 }
 
+// Changes of a single card of a collection. The fields that are missing are not changed.
+type CardOwnershipUpdate struct {
+	Count  *uint   `json:"count"`
+	Extras *string `json:"extras"`
+	Condi  *string `json:"condi"`
+}
+
+// Identifies a card version by its set code and collector number
+type SetNumber struct {
+	Set             string
+	CollectorNumber string
+}
+
+// Card conditions, from best to worst (Cardmarket grading)
+var Conditions = []string{"M", "NM", "EX", "GD", "LP", "PL", "PO"}
+
+// Value of Extras for foil cards
+const ExtrasFoil = "foil"
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 /*
@@ -63,13 +85,13 @@ Description	: This function updates the card or creates a new one.
 	The search parameters thet make a unique combination are: UserID, VersionID, Extras, Condi
 
 Self		: CardOwnership
-Parameters 	:
-Return     	: CardOwnership
+Parameters 	: DB transaction
+Return     	: CardOwnership, error
 */
-func (card *CardOwnership) SaveCard() (*CardOwnership, error) {
+func (card *CardOwnership) SaveCard(tx *gorm.DB) (*CardOwnership, error) {
 	// Find existing card by unique combination of fields
 	existingCard := &CardOwnership{}
-	err := DB.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ?", card.User_id, card.VersionID, card.Extras, card.Condi).First(existingCard).Error
+	err := tx.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ?", card.User_id, card.VersionID, card.Extras, card.Condi).First(existingCard).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -81,12 +103,37 @@ func (card *CardOwnership) SaveCard() (*CardOwnership, error) {
 		existingCard.OracleID = card.OracleID
 		existingCard.Extras = card.Extras
 		existingCard.Condi = card.Condi
-		DB.Save(existingCard)
-		return existingCard, nil
+		return existingCard, tx.Save(existingCard).Error
 	} else {
-		DB.Create(card)
-		return card, nil
+		return card, tx.Create(card).Error
+	}
+}
+
+/*
+Function	: Add Card
+Description	: This function adds the count of the card to an existing card with the same unique combination
+(UserID, VersionID, Extras, Condi) or creates a new one.
+
+Self		: CardOwnership
+Parameters 	: DB transaction
+Return     	: true if the card already existed, error
+*/
+func (card *CardOwnership) AddCard(tx *gorm.DB) (bool, error) {
+	existingCard := &CardOwnership{}
+	err := tx.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ?", card.User_id, card.VersionID, card.Extras, card.Condi).First(existingCard).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
 	}
+
+	if existingCard.CardID != 0 {
+		existingCard.Count += card.Count
+		if err := tx.Save(existingCard).Error; err != nil {
+			return true, err
+		}
+		*card = *existingCard
+		return true, nil
+	}
+	return false, tx.Create(card).Error
 }
 
 /*
@@ -104,16 +151,37 @@ func GetCardOwnershipByCardID(cardId uint) (CardOwnership, error) {
 	return cardOwnership, nil
 }
 
+/*
+Function	: Get cardOwnerships by CardIDs
+Description	: Get the CardOwnerships from the DB with a list of primary keys.
+Parameters 	: CardID list
+Return     	: CardID -> CardOwnership map, error
+*/
+func GetCardOwnershipsByCardIDs(cardIds []uint) (map[uint]CardOwnership, error) {
+	cardOwnerships := make(map[uint]CardOwnership)
+	if len(cardIds) == 0 {
+		return cardOwnerships, nil
+	}
+	var found []CardOwnership
+	if err := DB.Where("card_id IN ?", cardIds).Find(&found).Error; err != nil {
+		return cardOwnerships, err
+	}
+	for _, cardOwnership := range found {
+		cardOwnerships[cardOwnership.CardID] = cardOwnership
+	}
+	return cardOwnerships, nil
+}
+
 /*
 Function	: Get card ID by parameters
 Description	: Get a CardID from the DB with a unique combinations of parameters (without primary key).
-Parameters 	: UserID, CardID, CardExtras, CardCondition
+Parameters 	: DB transaction, UserID, CardID, CardExtras, CardCondition
 Return     	: Card, error
 Private
 */
-func GetCardIDByParams(userId uint, versionId string, extras string, condi string) (uint, error) {
+func GetCardIDByParams(tx *gorm.DB, userId uint, versionId string, extras string, condi string) (uint, error) {
 	cardOwnership := CardOwnership{}
-	err := DB.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ?", userId, versionId, extras, condi).First(&cardOwnership).Error
+	err := tx.Where("user_id = ? AND version_id = ? AND extras = ? AND condi = ?", userId, versionId, extras, condi).First(&cardOwnership).Error
 	if err != nil {
 		return 0, err
 	}
@@ -134,3 +202,60 @@ func CleanCardName(exact_cardname string) string {
 	cleaned_exact_cardname = strings.ReplaceAll(cleaned_exact_cardname, "\r", "")
 	return cleaned_exact_cardname
 }
+
+/*
+Function	: Normalize condition
+Description	: Convert the condition names used by other collection managers to the Cardmarket grading of Conditions.
+US names are mapped to the grade with the same abbreviation or, if there is none, the closest worse grade (Lightly
+Played -> LP, Moderately Played and Heavily Played -> PL), so "LP" and "Lightly Played" are the same condition.
+
+Parameters 	: condition
+Return     	: condition ("" if unknown)
+*/
+func NormalizeCondition(condition string) string {
+	condition = strings.ToLower(strings.TrimSpace(condition))
+	condition = strings.NewReplacer("_", " ", "-", " ").Replace(condition)
+	condition = strings.TrimSpace(strings.TrimSuffix(condition, "foil"))
+	switch condition {
+	case "m", "mint":
+		return "M"
+	case "nm", "near mint", "nm/m", "nm m":
+		return "NM"
+	case "ex", "excellent":
+		return "EX"
+	case "gd", "good":
+		return "GD"
+	case "lp", "light played", "lightly played", "slightly played", "sp", "good (lightly played)":
+		return "LP"
+	case "pl", "played", "moderately played", "mp", "hp", "heavily played":
+		return "PL"
+	case "po", "poor", "damaged", "dmg":
+		return "PO"
+	}
+	return ""
+}
+
+/*
+Function	: Condition rank
+Description	: Position of a condition in Conditions (0 is the best). Unknown conditions are the worst.
+Parameters 	: condition
+Return     	: rank
+*/
+func ConditionRank(condition string) int {
+	for rank, c := range Conditions {
+		if c == condition {
+			return rank
+		}
+	}
+	return len(Conditions)
+}
+
+/*
+Function	: Is foil
+Description	: Check if the Extras of a card mark it as foil.
+Parameters 	: extras
+Return     	: bool
+*/
+func IsFoil(extras string) bool {
+	return strings.Contains(strings.ToLower(extras), ExtrasFoil)
+}
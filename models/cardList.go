@@ -10,6 +10,19 @@ type CardOwnershipList struct {
 	CardOwnerships []CardOwnership `json:"collection"`
 }
 
+// Operation of a bulk collection edit (JSON-Patch style):
+//   - add	: add count copies of a card version (version_id, extras, condi), merging them with an existing card
+//   - remove	: remove the card card_id from the collection
+//   - adjust	: add count copies (or remove them if negative) to the card card_id
+type CollectionOperation struct {
+	Op        string `json:"op" binding:"required,oneof=add remove adjust"`
+	CardID    uint   `json:"card_id"`
+	VersionID string `json:"version_id"`
+	Extras    string `json:"extras"`
+	Condi     string `json:"condi"`
+	Count     int    `json:"count"`
+}
+
 // Used to get the all the cards that match a uncompleated card search from ScryFall
 type StringCardList struct {
 	Cards []string `json:"data"`
@@ -0,0 +1,86 @@
+/*
+File		: card_test.go
+Description	: Tests of the conditions and extras of the cards.
+*/
+
+package models
+
+import "testing"
+
+func TestNormalizeCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		want      string
+	}{
+		{"M", "M"},
+		{"mint", "M"},
+		{"NM", "NM"},
+		{" Near Mint ", "NM"},
+		{"near_mint", "NM"},
+		{"NM/M", "NM"},
+		{"near-mint foil", "NM"},
+		{"EX", "EX"},
+		{"Excellent", "EX"},
+		{"good", "GD"},
+		{"LP", "LP"},
+		{"lp", "LP"},
+		{"Lightly Played", "LP"},
+		{"light_played", "LP"},
+		{"SP", "LP"},
+		{"Good (Lightly Played)", "LP"},
+		{"Moderately Played", "PL"},
+		{"played", "PL"},
+		{"Heavily Played", "PL"},
+		{"HP", "PL"},
+		{"Damaged", "PO"},
+		{"dmg", "PO"},
+		{"", ""},
+		{"like new", ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeCondition(test.condition); got != test.want {
+			t.Errorf("NormalizeCondition(%q) = %q, want %q", test.condition, got, test.want)
+		}
+	}
+}
+
+func TestConditionRank(t *testing.T) {
+	for rank, condition := range Conditions {
+		if got := ConditionRank(condition); got != rank {
+			t.Errorf("ConditionRank(%q) = %d, want %d", condition, got, rank)
+		}
+	}
+	tests := []struct {
+		better string
+		worse  string
+	}{
+		{"M", "NM"},
+		{"NM", "EX"},
+		{"GD", "PO"},
+		{"PO", ""},
+		{"PO", "unknown"},
+	}
+	for _, test := range tests {
+		if ConditionRank(test.better) >= ConditionRank(test.worse) {
+			t.Errorf("ConditionRank(%q) should be lower than ConditionRank(%q)", test.better, test.worse)
+		}
+	}
+}
+
+func TestIsFoil(t *testing.T) {
+	tests := []struct {
+		extras string
+		want   bool
+	}{
+		{"foil", true},
+		{"Foil, signed", true},
+		{"FOIL", true},
+		{"", false},
+		{"signed", false},
+	}
+	for _, test := range tests {
+		if got := IsFoil(test.extras); got != test.want {
+			t.Errorf("IsFoil(%q) = %v, want %v", test.extras, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+/*
+File		: catalog.go
+Description	: Model file to represent the local card catalog. The catalog is a copy of the Scryfall cards the API has
+already seen, so the card information can be read from the DB instead of asking Scryfall every time.
+*/
+
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Object asociated to the cards table from the DB. Each row is a card version (a Scryfall card ID).
+type CatalogCard struct {
+	ID              string    `gorm:"primary_key;size:50;not_null;" json:"id"`
+	OracleID        string    `gorm:"index;size:50;" json:"oracle_id"`
+	Name            string    `gorm:"index;size:200;not_null;" json:"name"`
+	Set             string    `gorm:"size:10;" json:"set"`
+	SetName         string    `gorm:"size:100;" json:"set_name"`
+	CollectorNumber string    `gorm:"size:20;" json:"collector_number"`
+	Games           string    `gorm:"size:100;" json:"games"` // Comma separated list (paper,mtgo,arena)
+	ReleasedAt      string    `gorm:"size:10;" json:"released_at"`
+	ImageSmall      string    `gorm:"size:255;" json:"image_small"`
+	ImageLarge      string    `gorm:"size:255;" json:"image_large"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Marks that all the printings of a card name are in the catalog, so the versions of a card can be read from the DB.
+type CatalogPrintings struct {
+	Name      string    `gorm:"primary_key;size:200;not_null;" json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (CatalogCard) TableName() string {
+	return "cards"
+}
+
+func (CatalogPrintings) TableName() string {
+	return "card_printings"
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: New catalog card
+Description	: Build a catalog card from a Scryfall card, the list of games the card is printed in and its release date.
+Parameters 	: Card, games, releasedAt
+Return     	: CatalogCard
+*/
+func NewCatalogCard(card Card, games []string, releasedAt string) CatalogCard {
+	return CatalogCard{
+		ID:              card.ID,
+		OracleID:        card.OracleID,
+		Name:            card.Name,
+		Set:             card.Set,
+		SetName:         card.SetName,
+		CollectorNumber: card.CollectorNumber,
+		Games:           strings.Join(games, ","),
+		ReleasedAt:      releasedAt,
+		ImageSmall:      card.ImageURL.Small,
+		ImageLarge:      card.ImageURL.Large,
+	}
+}
+
+/*
+Function	: To card
+Description	: Convert a catalog card to the Card object sent to the frontend.
+Self		: CatalogCard
+Parameters 	:
+Return     	: Card
+*/
+func (c CatalogCard) ToCard() Card {
+	return Card{
+		Name:            c.Name,
+		ImageURL:        Image_url{Small: c.ImageSmall, Large: c.ImageLarge},
+		ID:              c.ID,
+		OracleID:        c.OracleID,
+		Set:             c.Set,
+		SetName:         c.SetName,
+		CollectorNumber: c.CollectorNumber,
+	}
+}
+
+/*
+Function	: To card version
+Description	: Convert a catalog card to the CardVersion object sent to the frontend.
+Self		: CatalogCard
+Parameters 	:
+Return     	: CardVersion
+*/
+func (c CatalogCard) ToCardVersion() CardVersion {
+	var games []string
+	if c.Games != "" {
+		games = strings.Split(c.Games, ",")
+	}
+	return CardVersion{
+		Id:              c.ID,
+		Games:           games,
+		Set:             c.Set,
+		SetName:         c.SetName,
+		CollectorNumber: c.CollectorNumber,
+		ImageURL:        Image_url{Small: c.ImageSmall, Large: c.ImageLarge},
+	}
+}
+
+/*
+Function	: Is fresh
+Description	: Check if the catalog card was refreshed less than ttl ago. A ttl of 0 means the card never expires.
+Self		: CatalogCard
+Parameters 	: ttl
+Return     	: bool
+*/
+func (c CatalogCard) IsFresh(ttl time.Duration) bool {
+	return ttl == 0 || time.Since(c.UpdatedAt) < ttl
+}
+
+/*
+Function	: Save catalog cards
+Description	: Insert the cards in the catalog or update them if they already exist (upsert by card ID).
+Parameters 	: CatalogCard list
+Return     	: error
+*/
+func SaveCatalogCards(cards []CatalogCard) error {
+	if len(cards) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range cards {
+		cards[i].UpdatedAt = now
+	}
+	return DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cards).Error
+}
+
+/*
+Function	: Get catalog card by ID
+Description	: Get a card from the catalog by its Scryfall ID.
+Parameters 	: context, cardID
+Return     	: CatalogCard, error (gorm.ErrRecordNotFound if the card is not in the catalog)
+*/
+func GetCatalogCardByID(ctx context.Context, id string) (CatalogCard, error) {
+	card := CatalogCard{}
+	err := DB.WithContext(ctx).Where("id = ?", id).Take(&card).Error
+	return card, err
+}
+
+/*
+Function	: Get catalog cards by ID
+Description	: Get a list of cards from the catalog by their Scryfall IDs. The IDs that are not in the catalog are ignored.
+Parameters 	: context, cardID list
+Return     	: CatalogCard list, error
+*/
+func GetCatalogCardsByID(ctx context.Context, ids []string) ([]CatalogCard, error) {
+	cards := []CatalogCard{}
+	if len(ids) == 0 {
+		return cards, nil
+	}
+	err := DB.WithContext(ctx).Where("id IN ?", ids).Find(&cards).Error
+	return cards, err
+}
+
+/*
+Function	: Get catalog cards by set number
+Description	: Get a list of cards from the catalog by their set code and collector number.
+Parameters 	: context, SetNumber list
+Return     	: CatalogCard list, error
+*/
+func GetCatalogCardsBySetNumber(ctx context.Context, keys []SetNumber) ([]CatalogCard, error) {
+	cards := []CatalogCard{}
+	if len(keys) == 0 {
+		return cards, nil
+	}
+	var pairs [][]interface{}
+	for _, key := range keys {
+		pairs = append(pairs, []interface{}{key.Set, key.CollectorNumber})
+	}
+	err := DB.WithContext(ctx).Where("(`set`, collector_number) IN ?", pairs).Find(&cards).Error
+	return cards, err
+}
+
+/*
+Function	: Get catalog card by name
+Description	: Get a card from the catalog by its exact name (case insensitive). Returns the last refreshed version.
+Parameters 	: context, cardName
+Return     	: CatalogCard, error (gorm.ErrRecordNotFound if the card is not in the catalog)
+*/
+func GetCatalogCardByName(ctx context.Context, name string) (CatalogCard, error) {
+	card := CatalogCard{}
+	err := DB.WithContext(ctx).Where("LOWER(name) = LOWER(?)", name).Order("updated_at DESC").Take(&card).Error
+	return card, err
+}
+
+/*
+Function	: Get catalog printings
+Description	: Get all the versions of a card from the catalog. Only valid if all the printings were stored before,
+the second return value is false otherwise.
+
+Parameters 	: context, cardName, ttl
+Return     	: CatalogCard list, found, error
+*/
+func GetCatalogPrintings(ctx context.Context, name string, ttl time.Duration) ([]CatalogCard, bool, error) {
+	cards := []CatalogCard{}
+	printings := CatalogPrintings{}
+	err := DB.WithContext(ctx).Where("LOWER(name) = LOWER(?)", name).Take(&printings).Error
+	if err == gorm.ErrRecordNotFound {
+		return cards, false, nil
+	}
+	if err != nil {
+		return cards, false, err
+	}
+	if ttl != 0 && time.Since(printings.UpdatedAt) >= ttl {
+		return cards, false, nil
+	}
+	err = DB.WithContext(ctx).Where("LOWER(name) = LOWER(?)", name).Order("released_at").Find(&cards).Error
+	return cards, err == nil, err
+}
+
+/*
+Function	: Save catalog printings
+Description	: Store all the versions of a card and mark the card name as complete in the catalog.
+Parameters 	: cardName, CatalogCard list
+Return     	: error
+*/
+func SaveCatalogPrintings(name string, cards []CatalogCard) error {
+	if err := SaveCatalogCards(cards); err != nil {
+		return err
+	}
+	return MarkCatalogPrintings([]string{name})
+}
+
+/*
+Function	: Mark catalog printings
+Description	: Mark a list of card names as complete in the catalog (all their versions are stored).
+Parameters 	: cardName list
+Return     	: error
+*/
+func MarkCatalogPrintings(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	now := time.Now()
+	printings := make([]CatalogPrintings, 0, len(names))
+	for _, name := range names {
+		printings = append(printings, CatalogPrintings{Name: name, UpdatedAt: now})
+	}
+	return DB.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&printings, 500).Error
+}
+
+/*
+Function	: Search catalog names
+Description	: Get the names of the cards in the catalog that start with a partial name. Used when Scryfall can't be reached.
+Parameters 	: context, uncompleted cardName, max number of names
+Return     	: cardName list, error
+*/
+func SearchCatalogNames(ctx context.Context, partial string, limit int) ([]string, error) {
+	names := []string{}
+	partial = strings.NewReplacer("%", "\\%", "_", "\\_").Replace(partial)
+	err := DB.WithContext(ctx).Model(&CatalogCard{}).Distinct("name").Where("name LIKE ?", partial+"%").Order("name").Limit(limit).Pluck("name", &names).Error
+	return names, err
+}
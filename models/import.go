@@ -0,0 +1,35 @@
+/*
+File		: import.go
+Description	: Model file to represent the result of a collection import.
+*/
+
+package models
+
+// Result of a collection import sent to the frontend
+type ImportReport struct {
+	Imported   int           `json:"imported"`   // Rows stored as new cards of the collection
+	Merged     int           `json:"merged"`     // Rows added to cards already in the collection
+	Unresolved []ImportIssue `json:"unresolved"` // Rows that could not be imported
+	Version    uint          `json:"version"`    // Version of the collection after the import
+}
+
+// A row of an import that could not be imported
+type ImportIssue struct {
+	Line        int      `json:"line"`
+	Row         string   `json:"row"`
+	Reason      string   `json:"reason"`
+	Suggestions []string `json:"suggestions,omitempty"` // Card names that could be the one in the row
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Add issue
+Description	: Add a row that could not be imported to the report.
+Self		: ImportReport
+Parameters 	: line, row, reason
+Return     	:
+*/
+func (report *ImportReport) AddIssue(line int, row string, reason string) {
+	report.Unresolved = append(report.Unresolved, ImportIssue{Line: line, Row: row, Reason: reason})
+}
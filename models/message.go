@@ -0,0 +1,87 @@
+/*
+File		: message.go
+Description	: Model file to represent the messages of the trades and their related functions.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Message DB object. Sent by a user of a trade to the other user.
+type TradeMessage struct {
+	MessageID uint       `gorm:"primary_key;auto_increment;not_null;" json:"message_id"`
+	TradeID   uint       `gorm:"not_null;index;" json:"trade_id"`
+	UserID    uint       `gorm:"not_null;" json:"-"` // User that sent the message
+	Username  string     `gorm:"-" json:"username"`  // User that sent the message
+	Body      string     `gorm:"not_null;type:text;" json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"` // When the other user read the message
+}
+
+// New message of a trade
+type MessageRequest struct {
+	Body string `json:"body" binding:"required,max=2000"`
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Get trade messages
+Description	: Get a page of the messages of a trade, the newest first.
+Parameters 	: DB transaction, TradeID, MessageID of the cursor (only older messages are got, 0 for the newest), limit
+Return     	: TradeMessage list, error
+*/
+func GetTradeMessages(tx *gorm.DB, tradeID uint, before uint, limit int) ([]TradeMessage, error) {
+	messages := []TradeMessage{}
+	query := tx.Where("trade_id = ?", tradeID)
+	if before != 0 {
+		query = query.Where("message_id < ?", before)
+	}
+	err := query.Order("message_id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+/*
+Function	: Mark messages read
+Description	: Mark as read some messages of a trade sent to a user (the messages that he got). The messages of the
+user are not changed.
+
+Parameters 	: DB transaction, TradeID, UserID of the reader, MessageID list, read time
+Return     	: error
+*/
+func MarkMessagesRead(tx *gorm.DB, tradeID uint, userID uint, messageIDs []uint, readAt time.Time) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&TradeMessage{}).
+		Where("trade_id = ? AND user_id != ? AND message_id IN ? AND read_at IS NULL", tradeID, userID, messageIDs).
+		Update("read_at", readAt).Error
+}
+
+/*
+Function	: Get unread counts
+Description	: Get the number of messages sent to a user that he didn't read, by trade.
+Parameters 	: DB transaction, UserID, TradeID list
+Return     	: TradeID -> unread messages map (only the trades with unread messages), error
+*/
+func GetUnreadCounts(tx *gorm.DB, userID uint, tradeIDs []uint) (map[uint]int, error) {
+	unread := make(map[uint]int)
+	if len(tradeIDs) == 0 {
+		return unread, nil
+	}
+	var rows []struct {
+		TradeID uint
+		Unread  int
+	}
+	err := tx.Model(&TradeMessage{}).Select("trade_id, COUNT(*) AS unread").
+		Where("trade_id IN ? AND user_id != ? AND read_at IS NULL", tradeIDs, userID).
+		Group("trade_id").Scan(&rows).Error
+	for _, row := range rows {
+		unread[row.TradeID] = row.Unread
+	}
+	return unread, err
+}
@@ -0,0 +1,49 @@
+/*
+File		: notification.go
+Description	: Model file to represent the email notifications of the users and their related functions.
+*/
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Email notifications that a user wants. Users without preferences get all of them, as they happen.
+type NotificationPreferences struct {
+	UserID      uint `gorm:"primary_key;auto_increment:false;not_null;" json:"-"`
+	Proposals   bool `gorm:"not_null;" json:"proposals"`    // New trades and counter-offers
+	Acceptances bool `gorm:"not_null;" json:"acceptances"`  // Accepted trades
+	Completions bool `gorm:"not_null;" json:"completions"`  // Completed trades
+	Messages    bool `gorm:"not_null;" json:"messages"`     // Messages of the trades
+	PriceAlerts bool `gorm:"not_null;" json:"price_alerts"` // Price changes of the price alerts
+	Digest      bool `gorm:"not_null;" json:"digest"`       // One email a day with all the notifications
+}
+
+// Notification waiting for the daily digest of a user
+type PendingNotification struct {
+	NotificationID uint      `gorm:"primary_key;auto_increment;not_null;"`
+	UserID         uint      `gorm:"not_null;index;"`
+	Summary        string    `gorm:"not_null;size:255;"`
+	CreatedAt      time.Time `gorm:"index;"`
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Get notification preferences
+Description	: Get the notification preferences of a user, or the default ones if he didn't save any.
+Parameters 	: DB transaction, UserID
+Return     	: NotificationPreferences, error
+*/
+func GetNotificationPreferences(tx *gorm.DB, userID uint) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := tx.First(&preferences, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NotificationPreferences{UserID: userID, Proposals: true, Acceptances: true, Completions: true, Messages: true, PriceAlerts: true}, nil
+	}
+	return preferences, err
+}
@@ -0,0 +1,319 @@
+/*
+File		: price.go
+Description	: Model file to represent the prices of the card versions (as given by Scryfall), the value of the
+collections, their daily history and the price alerts of the users.
+*/
+
+package models
+
+import (
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Currencies of the card prices
+const (
+	CurrencyUSD = "usd"
+	CurrencyEUR = "eur"
+	CurrencyTix = "tix" // MTGO event tickets
+)
+
+// All the currencies
+var Currencies = []string{CurrencyUSD, CurrencyEUR, CurrencyTix}
+
+// Directions of a price alert
+const (
+	AlertRise = "rise"
+	AlertDrop = "drop"
+)
+
+// Last known prices of a card version. A nil price is unknown.
+type CardPrice struct {
+	VersionID string    `gorm:"primary_key;size:50;not_null;" json:"version_id"`
+	USD       *float64  `gorm:"type:decimal(10,2);" json:"usd"`
+	USDFoil   *float64  `gorm:"type:decimal(10,2);" json:"usd_foil"`
+	EUR       *float64  `gorm:"type:decimal(10,2);" json:"eur"`
+	EURFoil   *float64  `gorm:"type:decimal(10,2);" json:"eur_foil"`
+	Tix       *float64  `gorm:"type:decimal(10,2);" json:"tix"` // There are no foil tix prices
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Value of the collection of a user in a currency
+type CollectionValue struct {
+	Currency string      `json:"currency"`
+	Total    float64     `json:"total"`
+	Foil     float64     `json:"foil"`     // Value of the foil cards
+	NonFoil  float64     `json:"non_foil"` // Value of the other cards
+	Unpriced int         `json:"unpriced"` // Copies without a price in the currency
+	Sets     []SetValue  `json:"sets"`     // The most valuable set first
+	Cards    []CardValue `json:"cards"`    // The most valuable card first
+}
+
+// Value of the cards of a set of a collection
+type SetValue struct {
+	Set     string  `json:"set"`
+	SetName string  `json:"set_name"`
+	Count   int     `json:"count"` // Copies of the set
+	Value   float64 `json:"value"`
+}
+
+// Value of a card of a collection
+type CardValue struct {
+	Card  Card     `json:"card"`
+	Foil  bool     `json:"foil"`
+	Price *float64 `json:"price"` // Price of a copy, nil if unknown
+	Value float64  `json:"value"` // Price of all the copies
+}
+
+// Prices of a card version on a day, copied from CardPrice once a day
+type PriceSnapshot struct {
+	VersionID string    `gorm:"primary_key;size:50;not_null;" json:"-"`
+	Date      time.Time `gorm:"primary_key;type:date;not_null;" json:"date"`
+	USD       *float64  `gorm:"type:decimal(10,2);" json:"usd"`
+	USDFoil   *float64  `gorm:"type:decimal(10,2);" json:"usd_foil"`
+	EUR       *float64  `gorm:"type:decimal(10,2);" json:"eur"`
+	EURFoil   *float64  `gorm:"type:decimal(10,2);" json:"eur_foil"`
+	Tix       *float64  `gorm:"type:decimal(10,2);" json:"tix"`
+}
+
+// Value of the collection of a user on a day, in all the currencies
+type CollectionValueSnapshot struct {
+	UserID uint      `gorm:"primary_key;auto_increment:false;not_null;"`
+	Date   time.Time `gorm:"primary_key;type:date;not_null;"`
+	USD    float64   `gorm:"type:decimal(12,2);not_null;"`
+	EUR    float64   `gorm:"type:decimal(12,2);not_null;"`
+	Tix    float64   `gorm:"type:decimal(12,2);not_null;"`
+}
+
+// Value of a collection on a day in one currency
+type ValuePoint struct {
+	Date  time.Time `json:"date"`
+	Value float64   `json:"value"`
+}
+
+// Price alert of a user: a card (or any card of his collection) rose or dropped a percentage in some days
+type PriceAlert struct {
+	AlertID   uint      `gorm:"primary_key;auto_increment;not_null;" json:"alert_id"`
+	UserID    uint      `gorm:"not_null;index;" json:"-"`
+	VersionID string    `gorm:"not_null;size:50;" json:"version_id"` // Empty for all the cards of the collection
+	Foil      bool      `gorm:"not_null;" json:"foil"`               // Foil price of VersionID. The cards of the collection use their own.
+	Currency  string    `gorm:"not_null;size:20;" json:"currency"`
+	Direction string    `gorm:"not_null;size:20;" json:"direction"` // AlertRise or AlertDrop
+	Percent   float64   `gorm:"not_null;" json:"percent"`
+	Days      int       `gorm:"not_null;" json:"days"` // The price is compared with the price of Days ago
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// New price alert
+type PriceAlertRequest struct {
+	VersionID string  `json:"version_id"`
+	Foil      bool    `json:"foil"`
+	Currency  string  `json:"currency" binding:"omitempty,oneof=usd eur tix"`
+	Direction string  `json:"direction" binding:"required,oneof=rise drop"`
+	Percent   float64 `json:"percent" binding:"required,gt=0,lte=1000"`
+	Days      int     `json:"days" binding:"omitempty,min=1,max=90"`
+}
+
+// Last time that a card triggered a price alert. The alert is quiet for that card during its Days.
+type PriceAlertHit struct {
+	AlertID     uint      `gorm:"primary_key;auto_increment:false;not_null;"`
+	VersionID   string    `gorm:"primary_key;size:50;not_null;"`
+	Foil        bool      `gorm:"primary_key;not_null;"`
+	OldPrice    float64   `gorm:"type:decimal(10,2);not_null;"`
+	NewPrice    float64   `gorm:"type:decimal(10,2);not_null;"`
+	TriggeredAt time.Time `gorm:"not_null;"`
+}
+
+// Price change of a card that triggered a price alert, sent to the user
+type PriceChange struct {
+	AlertID   uint    `json:"alert_id"`
+	VersionID string  `json:"version_id"`
+	Name      string  `json:"name"`
+	Set       string  `json:"set"`
+	Foil      bool    `json:"foil"`
+	Currency  string  `json:"currency"`
+	OldPrice  float64 `json:"old_price"`
+	NewPrice  float64 `json:"new_price"`
+	Change    float64 `json:"change"` // Percentage, negative if the price dropped
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Price
+Description	: Get the price of a copy of the card version in a currency, foil or not.
+Self		: CardPrice
+Parameters 	: currency, foil
+Return     	: price (nil if unknown)
+*/
+func (price CardPrice) Price(currency string, foil bool) *float64 {
+	switch currency {
+	case CurrencyUSD:
+		if foil {
+			return price.USDFoil
+		}
+		return price.USD
+	case CurrencyEUR:
+		if foil {
+			return price.EURFoil
+		}
+		return price.EUR
+	case CurrencyTix:
+		return price.Tix
+	}
+	return nil
+}
+
+/*
+Function	: Round price
+Description	: Round an amount of money to cents.
+Parameters 	: amount
+Return     	: rounded amount
+*/
+func RoundPrice(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+/*
+Function	: Save card prices
+Description	: Insert the prices of card versions or update them if they already exist (upsert by version ID).
+Parameters 	: DB transaction, CardPrice list
+Return     	: error
+*/
+func SaveCardPrices(tx *gorm.DB, prices []CardPrice) error {
+	if len(prices) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range prices {
+		prices[i].UpdatedAt = now
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&prices, 500).Error
+}
+
+/*
+Function	: Get card prices
+Description	: Get the prices of a list of card versions. The versions without prices are not in the map.
+Parameters 	: DB transaction, VersionID list
+Return     	: VersionID -> CardPrice map, error
+*/
+func GetCardPrices(tx *gorm.DB, versionIDs []string) (map[string]CardPrice, error) {
+	prices := make(map[string]CardPrice)
+	if len(versionIDs) == 0 {
+		return prices, nil
+	}
+	var cardPrices []CardPrice
+	if err := tx.Where("version_id IN ?", versionIDs).Find(&cardPrices).Error; err != nil {
+		return prices, err
+	}
+	for _, price := range cardPrices {
+		prices[price.VersionID] = price
+	}
+	return prices, nil
+}
+
+/*
+Function	: Price
+Description	: Get the price of a copy of the card version on the day of the snapshot, in a currency, foil or not.
+Self		: PriceSnapshot
+Parameters 	: currency, foil
+Return     	: price (nil if unknown)
+*/
+func (snapshot PriceSnapshot) Price(currency string, foil bool) *float64 {
+	return CardPrice{USD: snapshot.USD, USDFoil: snapshot.USDFoil, EUR: snapshot.EUR, EURFoil: snapshot.EURFoil, Tix: snapshot.Tix}.
+		Price(currency, foil)
+}
+
+/*
+Function	: Value
+Description	: Get the value of the collection on the day of the snapshot in a currency.
+Self		: CollectionValueSnapshot
+Parameters 	: currency
+Return     	: value
+*/
+func (snapshot CollectionValueSnapshot) Value(currency string) float64 {
+	switch currency {
+	case CurrencyEUR:
+		return snapshot.EUR
+	case CurrencyTix:
+		return snapshot.Tix
+	}
+	return snapshot.USD
+}
+
+/*
+Function	: Triggered
+Description	: Check if the change of a price from an old price triggers the alert. Unknown or zero old prices never do.
+Self		: PriceAlert
+Parameters 	: old price, new price
+Return     	: bool, change (percentage)
+*/
+func (alert PriceAlert) Triggered(oldPrice float64, newPrice float64) (bool, float64) {
+	if oldPrice <= 0 {
+		return false, 0
+	}
+	change := RoundPrice((newPrice - oldPrice) / oldPrice * 100)
+	if alert.Direction == AlertDrop {
+		return -change >= alert.Percent, change
+	}
+	return change >= alert.Percent, change
+}
+
+/*
+Function	: Day
+Description	: Get the start of the day of a time, in the local time zone of the API (the one of the DB connection).
+Parameters 	: time
+Return     	: day
+*/
+func Day(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+/*
+Function	: Save price snapshots
+Description	: Copy the last known prices of some card versions to their snapshots of a day. The snapshots of that day
+are replaced, so it can run more than once a day.
+
+Parameters 	: DB transaction, CardPrice list, day
+Return     	: error
+*/
+func SavePriceSnapshots(tx *gorm.DB, prices []CardPrice, day time.Time) error {
+	if len(prices) == 0 {
+		return nil
+	}
+	snapshots := make([]PriceSnapshot, 0, len(prices))
+	for _, price := range prices {
+		snapshots = append(snapshots, PriceSnapshot{VersionID: price.VersionID, Date: day, USD: price.USD,
+			USDFoil: price.USDFoil, EUR: price.EUR, EURFoil: price.EURFoil, Tix: price.Tix})
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&snapshots, 500).Error
+}
+
+/*
+Function	: Get price snapshots
+Description	: Get the last snapshot of some card versions between two days. The versions without snapshots in those
+days are not in the map.
+
+Parameters 	: DB transaction, VersionID list, first day, last day
+Return     	: VersionID -> PriceSnapshot map, error
+*/
+func GetPriceSnapshots(tx *gorm.DB, versionIDs []string, from time.Time, to time.Time) (map[string]PriceSnapshot, error) {
+	snapshots := make(map[string]PriceSnapshot)
+	if len(versionIDs) == 0 {
+		return snapshots, nil
+	}
+	var priceSnapshots []PriceSnapshot
+	err := tx.Where("version_id IN ? AND date BETWEEN ? AND ?", versionIDs, from, to).Order("date").Find(&priceSnapshots).Error
+	if err != nil {
+		return snapshots, err
+	}
+	// Ordered by date: the last one stays
+	for _, snapshot := range priceSnapshots {
+		snapshots[snapshot.VersionID] = snapshot
+	}
+	return snapshots, nil
+}
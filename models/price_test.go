@@ -0,0 +1,97 @@
+/*
+File		: price_test.go
+Description	: Tests of the prices of the cards and of the price alerts.
+*/
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceAlertTriggered(t *testing.T) {
+	rise := PriceAlert{Direction: AlertRise, Percent: 20}
+	drop := PriceAlert{Direction: AlertDrop, Percent: 20}
+	tests := []struct {
+		alert      PriceAlert
+		oldPrice   float64
+		newPrice   float64
+		want       bool
+		wantChange float64
+	}{
+		{rise, 10, 12, true, 20},
+		{rise, 10, 11.99, false, 19.9},
+		{rise, 10, 30, true, 200},
+		{rise, 10, 8, false, -20},
+		{drop, 10, 8, true, -20},
+		{drop, 10, 8.01, false, -19.9},
+		{drop, 10, 12, false, 20},
+		{rise, 0, 5, false, 0}, // No old price to compare
+		{drop, 0, 0, false, 0},
+		{rise, 3, 3, false, 0},
+	}
+	for _, test := range tests {
+		got, change := test.alert.Triggered(test.oldPrice, test.newPrice)
+		if got != test.want || change != test.wantChange {
+			t.Errorf("%s %.0f%%: %.2f -> %.2f = %v, %v; want %v, %v", test.alert.Direction, test.alert.Percent,
+				test.oldPrice, test.newPrice, got, change, test.want, test.wantChange)
+		}
+	}
+}
+
+func TestCardPricePrice(t *testing.T) {
+	usd, usdFoil, eur, tix := 1.5, 4.0, 1.2, 0.03
+	price := CardPrice{USD: &usd, USDFoil: &usdFoil, EUR: &eur, Tix: &tix}
+	tests := []struct {
+		currency string
+		foil     bool
+		want     *float64
+	}{
+		{CurrencyUSD, false, &usd},
+		{CurrencyUSD, true, &usdFoil},
+		{CurrencyEUR, false, &eur},
+		{CurrencyEUR, true, nil}, // Unknown
+		{CurrencyTix, false, &tix},
+		{CurrencyTix, true, &tix}, // There are no foil tix prices
+		{"gbp", false, nil},
+	}
+	for _, test := range tests {
+		got := price.Price(test.currency, test.foil)
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("Price(%q, %v) = %v, want %v", test.currency, test.foil, got, test.want)
+		}
+		snapshot := PriceSnapshot{USD: price.USD, USDFoil: price.USDFoil, EUR: price.EUR, EURFoil: price.EURFoil, Tix: price.Tix}
+		if snapshotPrice := snapshot.Price(test.currency, test.foil); snapshotPrice != got {
+			t.Errorf("snapshot Price(%q, %v) = %v, want %v", test.currency, test.foil, snapshotPrice, got)
+		}
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   float64
+	}{
+		{1.004, 1},
+		{1.005000001, 1.01},
+		{0.1 + 0.2, 0.3},
+		{12.345678, 12.35},
+		{-2.499, -2.5},
+	}
+	for _, test := range tests {
+		if got := RoundPrice(test.amount); got != test.want {
+			t.Errorf("RoundPrice(%v) = %v, want %v", test.amount, got, test.want)
+		}
+	}
+}
+
+func TestDay(t *testing.T) {
+	day := Day(time.Date(2024, 3, 9, 23, 59, 59, 0, time.Local))
+	if want := time.Date(2024, 3, 9, 0, 0, 0, 0, time.Local); !day.Equal(want) {
+		t.Errorf("Day = %v, want %v", day, want)
+	}
+	if !Day(day).Equal(day) {
+		t.Errorf("Day of a day changed it: %v", Day(day))
+	}
+}
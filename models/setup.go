@@ -44,6 +44,14 @@ func ConnectDataBase() {
 		fmt.Println("Connected to database", DbName)
 	}
 
-	DB.AutoMigrate(&User{})
+	DB.AutoMigrate(&User{}, &CatalogCard{}, &CatalogPrintings{}, &Trade{}, &TradeItem{}, &TradeTransition{}, &TradeRevision{}, &TradeRevisionItem{}, &TradeMessage{}, &Webhook{}, &WebhookDelivery{}, &NotificationPreferences{}, &PendingNotification{}, &WantlistItem{}, &TradeCycle{}, &TradeCycleMember{}, &CardPrice{}, &PriceSnapshot{}, &CollectionValueSnapshot{}, &PriceAlert{}, &PriceAlertHit{})
+
+	// Move the trades stored before trades had their own ID
+	if err := MigrateLegacyTrades(); err != nil {
+		log.Println("legacy trades migration error:", err)
+	}
+	if err := MigrateTradeRevisions(); err != nil {
+		log.Println("trade revisions migration error:", err)
+	}
 
 }
@@ -6,31 +6,149 @@ It also has implicid functions for the Trade object.
 
 package models
 
-// Trade DB object.
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Trade states
+const (
+	TradeProposed  = "proposed"  // Started by a user, the other user has not changed it
+	TradeCountered = "countered" // The cards were changed after the proposal
+	TradeAccepted  = "accepted"  // Both users agreed. The cards are removed from the collections.
+	TradeShipped   = "shipped"   // The cards were sent
+	TradeReceived  = "received"  // The cards arrived
+	TradeCompleted = "completed" // The trade is finished
+	TradeCancelled = "cancelled" // One of the users cancelled the trade
+	TradeExpired   = "expired"   // Nobody changed the trade while it was negotiated
+)
+
+// Valid transitions of the trades: state -> states it can go to
+var tradeTransitions = map[string][]string{
+	TradeProposed:  {TradeCountered, TradeAccepted, TradeCancelled, TradeExpired},
+	TradeCountered: {TradeCountered, TradeAccepted, TradeCancelled, TradeExpired},
+	TradeAccepted:  {TradeShipped, TradeCompleted},
+	TradeShipped:   {TradeReceived},
+	TradeReceived:  {TradeCompleted},
+}
+
+// Trade DB object. Header of a negotiation between two users; the traded cards are its TradeItems.
 type Trade struct {
+	TradeID       uint       `gorm:"primary_key;auto_increment;not_null;" json:"trade_id"`
+	UserIdOrigin  uint       `gorm:"not_null;index;" json:"user_id_origin"` // The user that started the trade
+	UserIdOwner   uint       `gorm:"not_null;index;" json:"user_id_owner"`  // The other user
+	State         string     `gorm:"not_null;size:20;" json:"state"`
+	OriginChecked bool       `json:"origin_checked"`                            // True if the origin user accepts the trade
+	OwnerChecked  bool       `json:"owner_checked"`                             // True if the other user accepts the trade
+	Revision      uint       `gorm:"not_null;default:0;" json:"revision"`       // Number of the current TradeRevision
+	CycleID       uint       `gorm:"not_null;default:0;index;" json:"cycle_id"` // TradeCycle of the trade, 0 if it is not in one
+	ShippedBy     uint       `gorm:"not_null;default:0;" json:"shipped_by"`     // User that shipped the cards, 0 if they were not shipped
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ClosedAt      *time.Time `json:"closed_at"`
+}
+
+// Card of a trade. The card is given by its owner (UserID) to the other user of the trade.
+type TradeItem struct {
+	ItemID     uint `gorm:"primary_key;auto_increment;not_null;" json:"item_id"`
+	TradeID    uint `gorm:"not_null;index;" json:"trade_id"`
+	CardID     uint `gorm:"not_null;index;" json:"card_id"` // CardOwnership
+	UserID     uint `gorm:"not_null;" json:"user_id"`       // Owner of the card
+	CardSelect uint `gorm:"not_null;" json:"card_select"`   // Number of copies
+}
+
+// Proposal of the cards of a trade. Saved every time the cards change and never modified.
+type TradeRevision struct {
+	RevisionID uint      `gorm:"primary_key;auto_increment;not_null;" json:"-"`
+	TradeID    uint      `gorm:"not_null;index;" json:"-"`
+	Number     uint      `gorm:"not_null;" json:"revision"` // 1 for the first proposal of the trade
+	UserID     uint      `gorm:"not_null;" json:"user_id"`  // User that made the proposal
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Card of a TradeRevision
+type TradeRevisionItem struct {
+	ItemID     uint `gorm:"primary_key;auto_increment;not_null;" json:"-"`
+	RevisionID uint `gorm:"not_null;index;" json:"-"`
+	CardID     uint `gorm:"not_null;" json:"card_id"`
+	UserID     uint `gorm:"not_null;" json:"user_id"` // Owner of the card
+	CardSelect uint `gorm:"not_null;" json:"card_select"`
+}
+
+// Object that represents a revision of a trade seen by one of its users, with the changes from the previous one.
+type TradeRevisionView struct {
+	Revision     uint          `json:"revision"`
+	Username     string        `json:"username"` // User that made the proposal
+	CreatedAt    time.Time     `json:"created_at"`
+	WhatHeTrade  []CardSelect  `json:"whatHeTrade"`
+	WhatYouTrade []CardSelect  `json:"whatYouTrade"`
+	Changes      []TradeChange `json:"changes"`
+}
+
+// Change of the copies of a card between two revisions of a trade
+type TradeChange struct {
+	Card   Card   `json:"card"`
+	Yours  bool   `json:"yours"`  // True if the card is given by the user asking
+	Change string `json:"change"` // added, removed or changed
+	From   uint   `json:"from"`   // Copies in the previous revision
+	To     uint   `json:"to"`     // Copies in this revision
+}
+
+// Acceptance of a revision of a trade
+type TradeAcceptance struct {
+	Revision uint `json:"revision" binding:"required"`
+}
+
+// Change of the state of a trade: who did it and when. UserID is 0 if it was done by the API (expired trades).
+type TradeTransition struct {
+	TransitionID uint      `gorm:"primary_key;auto_increment;not_null;" json:"-"`
+	TradeID      uint      `gorm:"not_null;index;" json:"-"`
+	FromState    string    `gorm:"size:20;" json:"from"` // Empty when the trade was created
+	ToState      string    `gorm:"not_null;size:20;" json:"to"`
+	UserID       uint      `json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Manual change of the state of a trade
+type TradeStateChange struct {
+	State string `json:"state" binding:"required,oneof=shipped received completed cancelled"`
+}
+
+// Trade DB object before trades had their own ID. Every row was a traded card; the rows between two users were a trade.
+// Only used to migrate the old trades.
+type LegacyTrade struct {
 	TradeID      uint `gorm:"primary_key;auto_increment;not_null;" json:"trade_id"`
 	UserIdOrigin uint `gorm:"not_null;" json:"user_id_origin"`
 	UserIdOwner  uint `gorm:"not_null;" json:"user_id_owner"`
 	CardID       uint `gorm:"not_null;foreignKey;" json:"card_id"`
-	//VersionID    string `gorm:"not_null;" json:"version_id"`
-	//Extras       string `json:"extras"`
-	//Condi        string `json:"condi"`
-	CardSelect uint `json:"card_select"`
-	Status     int  `json:"status"`
+	CardSelect   uint `json:"card_select"`
+	Status       int  `json:"status"`
 	// -1 if both users dont want to finish
 	// 0 if both users want to finish
 	// UserIdOrigin if the user asking the card/s wants to finish
 	// UserIdowner if the user owning the card/s wants to finish
 }
 
-// Object that represents all the trades a user has with another user.
+// Object that represents a trade of a user with another user, seen by the user.
 type HoleTrade struct {
-	Username     string       `json:"username"`     // The other user username
-	Email        string       `json:"email"`        // The other user email
-	WhatHeTrade  []CardSelect `json:"whatHeTrade"`  // The cards that the other user gives
-	WhatYouTrade []CardSelect `json:"whatYouTrade"` // The cards that the other user gives
-	YouChecked   bool         `json:"youChecked"`   // True if the user wants to finish the trade
-	HeChecked    bool         `json:"heChecked"`    // True if the other user wants to finish the trade
+	TradeID      uint              `json:"trade_id"`
+	State        string            `json:"state"`
+	Username     string            `json:"username"`     // The other user username
+	Email        string            `json:"email"`        // The other user email
+	WhatHeTrade  []CardSelect      `json:"whatHeTrade"`  // The cards that the other user gives
+	WhatYouTrade []CardSelect      `json:"whatYouTrade"` // The cards that the other user gives
+	YouChecked   bool              `json:"youChecked"`   // True if the user accepts the trade
+	HeChecked    bool              `json:"heChecked"`    // True if the other user accepts the trade
+	YouShipped   bool              `json:"youShipped"`   // True if the user shipped the cards (the other user receives them)
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	ClosedAt     *time.Time        `json:"closed_at"`
+	Revision     uint              `json:"revision"`           // Revision of the cards. Sent back to accept or change them.
+	Unread       int               `json:"unread"`             // Messages of the other user not read
+	CycleID      uint              `json:"cycle_id,omitempty"` // TradeCycle of the trade, answered with the cycle
+	History      []TradeTransition `json:"history,omitempty"`  // Only sent for a single trade
 }
 
 // Object that represents the number of selections of a traded card.
@@ -39,36 +157,358 @@ type CardSelect struct {
 	Select uint `json:"select"`
 }
 
+func (Trade) TableName() string {
+	return "trade_headers"
+}
+
+func (LegacyTrade) TableName() string {
+	return "trades"
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 /*
-Function	: Create Trade
-Description	: Store a new trade to the DB.
+Function	: Is participant
+Description	: Check if a user is one of the users of the trade.
 Self		: Trade
-Parameters 	:
-Return     	: *Trade, error
+Parameters 	: UserID
+Return     	: bool
 */
-func (trade *Trade) CreateTrade() (*Trade, error) {
-	if err := DB.Create(&trade).Error; err != nil {
-		return &Trade{}, err
+func (trade *Trade) IsParticipant(userID uint) bool {
+	return trade.UserIdOrigin == userID || trade.UserIdOwner == userID
+}
+
+/*
+Function	: Other user
+Description	: Get the user of the trade that is not the given one.
+Self		: Trade
+Parameters 	: UserID
+Return     	: UserID
+*/
+func (trade *Trade) OtherUser(userID uint) uint {
+	if trade.UserIdOrigin == userID {
+		return trade.UserIdOwner
+	}
+	return trade.UserIdOrigin
+}
+
+/*
+Function	: Checks
+Description	: Get the checks of the trade seen by one of its users.
+Self		: Trade
+Parameters 	: UserID
+Return     	: check of the user, check of the other user
+*/
+func (trade *Trade) Checks(userID uint) (bool, bool) {
+	if trade.UserIdOrigin == userID {
+		return trade.OriginChecked, trade.OwnerChecked
+	}
+	return trade.OwnerChecked, trade.OriginChecked
+}
+
+/*
+Function	: Set checks
+Description	: Set the checks of the trade seen by one of its users.
+Self		: Trade
+Parameters 	: UserID, check of the user, check of the other user
+Return     	:
+*/
+func (trade *Trade) SetChecks(userID uint, youChecked bool, heChecked bool) {
+	if trade.UserIdOrigin == userID {
+		trade.OriginChecked, trade.OwnerChecked = youChecked, heChecked
+	} else {
+		trade.OwnerChecked, trade.OriginChecked = youChecked, heChecked
 	}
-	return trade, nil
 }
 
 /*
-Function	: Save Trade
-Description	: Modify a trade from the DB and if not found, create a new one.
+Function	: Is negotiated
+Description	: Check if the cards of the trade can still be changed.
 Self		: Trade
 Parameters 	:
-Return     	: *Trade, error
-*/
-func (trade *Trade) SaveTrade() (*Trade, error) {
-	// Find
-	if DB.Model(&trade).Where("user_id_origin = ? AND user_id_owner = ? AND card_id = ? ",
-		trade.UserIdOrigin, trade.UserIdOwner, trade.CardID).Updates(&trade).RowsAffected == 0 {
-		if err := DB.Create(&trade).Error; err != nil {
-			return &Trade{}, err
+Return     	: bool
+*/
+func (trade *Trade) IsNegotiated() bool {
+	return trade.State == TradeProposed || trade.State == TradeCountered
+}
+
+/*
+Function	: Is agreed
+Description	: Check if both users agreed the trade, so the other user can see the email.
+Self		: Trade
+Parameters 	:
+Return     	: bool
+*/
+func (trade *Trade) IsAgreed() bool {
+	switch trade.State {
+	case TradeAccepted, TradeShipped, TradeReceived, TradeCompleted:
+		return true
+	}
+	return false
+}
+
+/*
+Function	: Can transition
+Description	: Check if a trade can go from a state to another.
+Parameters 	: state, new state
+Return     	: bool
+*/
+func CanTransition(from string, to string) bool {
+	for _, state := range tradeTransitions[from] {
+		if state == to {
+			return true
 		}
 	}
-	return trade, nil
+	return false
+}
+
+/*
+Function	: Is final state
+Description	: Check if a trade in a state can't change anymore.
+Parameters 	: state
+Return     	: bool
+*/
+func IsFinalState(state string) bool {
+	return len(tradeTransitions[state]) == 0
+}
+
+/*
+Function	: Get trade transitions
+Description	: Get the changes of state of a trade, the oldest first.
+Parameters 	: DB transaction, TradeID
+Return     	: TradeTransition list, error
+*/
+func GetTradeTransitions(tx *gorm.DB, tradeID uint) ([]TradeTransition, error) {
+	transitions := []TradeTransition{}
+	err := tx.Where("trade_id = ?", tradeID).Order("transition_id").Find(&transitions).Error
+	return transitions, err
+}
+
+/*
+Function	: Get trade revisions
+Description	: Get the revisions of a trade with their cards, the oldest first.
+Parameters 	: DB transaction, TradeID
+Return     	: TradeRevision list, RevisionID -> TradeRevisionItem list map, error
+*/
+func GetTradeRevisions(tx *gorm.DB, tradeID uint) ([]TradeRevision, map[uint][]TradeRevisionItem, error) {
+	revisions := []TradeRevision{}
+	items := make(map[uint][]TradeRevisionItem)
+	if err := tx.Where("trade_id = ?", tradeID).Order("number").Find(&revisions).Error; err != nil {
+		return revisions, items, err
+	}
+	if len(revisions) == 0 {
+		return revisions, items, nil
+	}
+	var revisionIDs []uint
+	for _, revision := range revisions {
+		revisionIDs = append(revisionIDs, revision.RevisionID)
+	}
+	var found []TradeRevisionItem
+	if err := tx.Where("revision_id IN ?", revisionIDs).Order("item_id").Find(&found).Error; err != nil {
+		return revisions, items, err
+	}
+	for _, item := range found {
+		items[item.RevisionID] = append(items[item.RevisionID], item)
+	}
+	return revisions, items, nil
+}
+
+/*
+Function	: Migrate trade revisions
+Description	: Save the cards of the trades made before the revisions as their first revision.
+Parameters 	:
+Return     	: error
+*/
+func MigrateTradeRevisions() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var trades []Trade
+		if err := tx.Where("revision = ?", 0).Find(&trades).Error; err != nil {
+			return err
+		}
+		var tradeIDs []uint
+		for _, trade := range trades {
+			tradeIDs = append(tradeIDs, trade.TradeID)
+		}
+		items, err := GetTradeItems(tx, tradeIDs)
+		if err != nil {
+			return err
+		}
+		for _, trade := range trades {
+			if len(items[trade.TradeID]) == 0 {
+				continue
+			}
+			revision := TradeRevision{TradeID: trade.TradeID, Number: 1, UserID: trade.UserIdOrigin}
+			if err := tx.Create(&revision).Error; err != nil {
+				return err
+			}
+			for _, item := range items[trade.TradeID] {
+				revisionItem := TradeRevisionItem{RevisionID: revision.RevisionID, CardID: item.CardID, UserID: item.UserID, CardSelect: item.CardSelect}
+				if err := tx.Create(&revisionItem).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&trade).UpdateColumn("revision", 1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Function	: Get trade
+Description	: Get a trade from the DB, without locking it.
+Parameters 	: DB transaction, TradeID
+Return     	: Trade, error
+*/
+func GetTrade(tx *gorm.DB, tradeID uint) (Trade, error) {
+	var trade Trade
+	err := tx.First(&trade, tradeID).Error
+	return trade, err
+}
+
+/*
+Function	: Get trade for update
+Description	: Get a trade from the DB, locking it until the end of the transaction.
+Parameters 	: DB transaction, TradeID
+Return     	: Trade, error
+*/
+func GetTradeForUpdate(tx *gorm.DB, tradeID uint) (Trade, error) {
+	var trade Trade
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&trade, tradeID).Error
+	return trade, err
+}
+
+/*
+Function	: Get trade items
+Description	: Get the items of a list of trades.
+Parameters 	: DB transaction, TradeID list
+Return     	: TradeID -> TradeItem list map, error
+*/
+func GetTradeItems(tx *gorm.DB, tradeIDs []uint) (map[uint][]TradeItem, error) {
+	items := make(map[uint][]TradeItem)
+	if len(tradeIDs) == 0 {
+		return items, nil
+	}
+	var found []TradeItem
+	if err := tx.Where("trade_id IN ?", tradeIDs).Order("item_id").Find(&found).Error; err != nil {
+		return items, err
+	}
+	for _, item := range found {
+		items[item.TradeID] = append(items[item.TradeID], item)
+	}
+	return items, nil
+}
+
+/*
+Function	: Get reserved counts
+Description	: Get the copies of a list of cardOwnerships that are reserved by the negotiated trades.
+Parameters 	: DB transaction, CardID list, TradeID whose items are not counted (0 to count all)
+Return     	: CardID -> reserved copies map (only the reserved cards), error
+*/
+func GetReservedCounts(tx *gorm.DB, cardIDs []uint, exceptTradeID uint) (map[uint]uint, error) {
+	if len(cardIDs) == 0 {
+		return map[uint]uint{}, nil
+	}
+	return reservedCounts(tx.Where("trade_items.card_id IN ? AND trade_items.trade_id != ?", cardIDs, exceptTradeID))
+}
+
+/*
+Function	: Get user reserved counts
+Description	: Get the copies of the cards of a user that are reserved by the negotiated trades.
+Parameters 	: DB transaction, UserID
+Return     	: CardID -> reserved copies map (only the reserved cards), error
+*/
+func GetUserReservedCounts(tx *gorm.DB, userID uint) (map[uint]uint, error) {
+	return reservedCounts(tx.Where("trade_items.user_id = ?", userID))
+}
+
+/*
+Function	: Reserved counts
+Description	: Sum the copies of the items of the negotiated trades, by card.
+Parameters 	: DB query with the conditions of the items
+Return     	: CardID -> reserved copies map, error
+Private
+*/
+func reservedCounts(query *gorm.DB) (map[uint]uint, error) {
+	reserved := make(map[uint]uint)
+	var rows []struct {
+		CardID   uint
+		Reserved uint
+	}
+	err := query.Model(&TradeItem{}).Select("trade_items.card_id, SUM(trade_items.card_select) AS reserved").
+		Joins("JOIN trade_headers ON trade_headers.trade_id = trade_items.trade_id").
+		Where("trade_headers.state IN ?", []string{TradeProposed, TradeCountered}).
+		Group("trade_items.card_id").Scan(&rows).Error
+	for _, row := range rows {
+		reserved[row.CardID] = row.Reserved
+	}
+	return reserved, err
+}
+
+/*
+Function	: Migrate legacy trades
+Description	: Convert the trades stored before trades had their own ID. The rows between two users that are not finished
+become an open trade, and the finished ones a completed trade. It is only done while there are no trades of the new
+kind, so it runs once.
+
+Parameters 	:
+Return     	: error
+*/
+func MigrateLegacyTrades() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var trades int64
+		if err := tx.Model(&Trade{}).Count(&trades).Error; err != nil || trades > 0 {
+			return err
+		}
+		if !tx.Migrator().HasTable(&LegacyTrade{}) {
+			return nil
+		}
+		var legacyTrades []LegacyTrade
+		if err := tx.Order("trade_id").Find(&legacyTrades).Error; err != nil {
+			return err
+		}
+
+		// Group the rows by pair of users and finished or not
+		type tradeKey struct {
+			user1, user2 uint
+			finished     bool
+		}
+		headers := make(map[tradeKey]*Trade)
+		now := time.Now()
+		for _, legacyTrade := range legacyTrades {
+			key := tradeKey{legacyTrade.UserIdOrigin, legacyTrade.UserIdOwner, legacyTrade.Status == 0}
+			if key.user1 > key.user2 {
+				key.user1, key.user2 = key.user2, key.user1
+			}
+			trade, ok := headers[key]
+			if !ok {
+				trade = &Trade{
+					UserIdOrigin:  legacyTrade.UserIdOrigin,
+					UserIdOwner:   legacyTrade.UserIdOwner,
+					State:         TradeProposed,
+					OriginChecked: legacyTrade.Status == 0 || legacyTrade.Status == int(legacyTrade.UserIdOrigin),
+					OwnerChecked:  legacyTrade.Status == 0 || legacyTrade.Status == int(legacyTrade.UserIdOwner),
+				}
+				if key.finished {
+					trade.State = TradeCompleted
+					trade.ClosedAt = &now
+				}
+				if err := tx.Create(trade).Error; err != nil {
+					return err
+				}
+				headers[key] = trade
+			}
+			item := TradeItem{
+				TradeID:    trade.TradeID,
+				CardID:     legacyTrade.CardID,
+				UserID:     legacyTrade.UserIdOwner,
+				CardSelect: legacyTrade.CardSelect,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
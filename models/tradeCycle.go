@@ -0,0 +1,103 @@
+/*
+File		: tradeCycle.go
+Description	: Model file to represent the trade cycles: rings of trades between three or more users, where every user
+gives cards to the next one. The trades of a cycle are normal Trades linked by their CycleID.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Trade cycle states
+const (
+	CycleProposed = "proposed" // Found by the matching job, waiting for the answers of the users
+	CycleAccepted = "accepted" // All the users accepted. The trades of the cycle are accepted.
+	CycleDeclined = "declined" // A user declined. The trades of the cycle are cancelled.
+	CycleExpired  = "expired"  // Not all the users answered in time. The trades of the cycle are expired.
+)
+
+// Answers of the users of a trade cycle
+const (
+	CycleAnswerAccepted = "accepted"
+	CycleAnswerDeclined = "declined"
+)
+
+// Trade cycle DB object. Every member gives cards to the next member of the ring, and the last one to the first one.
+type TradeCycle struct {
+	CycleID   uint       `gorm:"primary_key;auto_increment;not_null;" json:"cycle_id"`
+	State     string     `gorm:"not_null;size:20;" json:"state"`
+	Ring      string     `gorm:"not_null;size:255;index;" json:"-"` // UserIDs in the order of the ring, from the lowest
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at"` // When all the users accepted, or the cycle was declined or expired
+}
+
+// User of a trade cycle and his answer
+type TradeCycleMember struct {
+	CycleID    uint   `gorm:"primary_key;auto_increment:false;not_null;"`
+	UserID     uint   `gorm:"primary_key;auto_increment:false;not_null;index;"`
+	Position   int    `gorm:"not_null;"`         // Position in the ring
+	Answer     string `gorm:"not_null;size:20;"` // Empty until the user answers
+	AnsweredAt *time.Time
+}
+
+// Object that represents a trade cycle seen by one of its users
+type HoleTradeCycle struct {
+	CycleID   uint              `json:"cycle_id"`
+	State     string            `json:"state"`
+	Members   []CycleMemberView `json:"members"` // In the order of the ring
+	Trades    []HoleTrade       `json:"trades"`  // The trades of the user: what he gets and what he gives
+	CreatedAt time.Time         `json:"created_at"`
+	ClosedAt  *time.Time        `json:"closed_at"`
+}
+
+// User of a trade cycle and his answer, seen by another user
+type CycleMemberView struct {
+	Username string `json:"username"`
+	Answer   string `json:"answer"`
+}
+
+// Answer of a user to a trade cycle
+type CycleAnswer struct {
+	Answer string `json:"answer" binding:"required,oneof=accepted declined"`
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Get trade cycle for update
+Description	: Get a trade cycle locking it until the end of the transaction.
+Parameters 	: DB transaction, CycleID
+Return     	: TradeCycle, error
+*/
+func GetTradeCycleForUpdate(tx *gorm.DB, cycleID uint) (TradeCycle, error) {
+	var cycle TradeCycle
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cycle, cycleID).Error
+	return cycle, err
+}
+
+/*
+Function	: Get cycle members
+Description	: Get the users of a list of trade cycles, in the order of their rings.
+Parameters 	: DB transaction, CycleID list
+Return     	: CycleID -> TradeCycleMember list map, error
+*/
+func GetCycleMembers(tx *gorm.DB, cycleIDs []uint) (map[uint][]TradeCycleMember, error) {
+	members := make(map[uint][]TradeCycleMember)
+	if len(cycleIDs) == 0 {
+		return members, nil
+	}
+	var cycleMembers []TradeCycleMember
+	if err := tx.Where("cycle_id IN ?", cycleIDs).Order("cycle_id, position").Find(&cycleMembers).Error; err != nil {
+		return members, err
+	}
+	for _, member := range cycleMembers {
+		members[member.CycleID] = append(members[member.CycleID], member)
+	}
+	return members, nil
+}
@@ -0,0 +1,96 @@
+/*
+File		: trade_test.go
+Description	: Tests of the state machine of the trades.
+*/
+
+package models
+
+import "testing"
+
+// All the trade states
+var tradeStates = []string{TradeProposed, TradeCountered, TradeAccepted, TradeShipped, TradeReceived, TradeCompleted,
+	TradeCancelled, TradeExpired}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{TradeProposed, TradeCountered, true},
+		{TradeProposed, TradeAccepted, true},
+		{TradeProposed, TradeCancelled, true},
+		{TradeProposed, TradeExpired, true},
+		{TradeProposed, TradeShipped, false},
+		{TradeProposed, TradeCompleted, false},
+		{TradeCountered, TradeCountered, true},
+		{TradeCountered, TradeProposed, false},
+		{TradeAccepted, TradeShipped, true},
+		{TradeAccepted, TradeCompleted, true},
+		{TradeAccepted, TradeCancelled, false},
+		{TradeShipped, TradeReceived, true},
+		{TradeShipped, TradeCompleted, false},
+		{TradeReceived, TradeCompleted, true},
+		{TradeCompleted, TradeProposed, false},
+		{TradeCancelled, TradeProposed, false},
+		{TradeExpired, TradeCountered, false},
+		{"open", TradeAccepted, false},
+	}
+	for _, test := range tests {
+		if got := CanTransition(test.from, test.to); got != test.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestTradeTransitions(t *testing.T) {
+	known := map[string]bool{}
+	for _, state := range tradeStates {
+		known[state] = true
+	}
+	for from, targets := range tradeTransitions {
+		if !known[from] {
+			t.Errorf("transitions from unknown state %q", from)
+		}
+		for _, to := range targets {
+			if !known[to] {
+				t.Errorf("transition %q -> unknown state %q", from, to)
+			}
+		}
+	}
+	// Every state can reach a final state
+	for _, state := range tradeStates {
+		if !reachesFinal(state, map[string]bool{}) {
+			t.Errorf("state %q can't reach a final state", state)
+		}
+	}
+}
+
+func TestIsFinalState(t *testing.T) {
+	final := map[string]bool{TradeCompleted: true, TradeCancelled: true, TradeExpired: true}
+	for _, state := range tradeStates {
+		if got := IsFinalState(state); got != final[state] {
+			t.Errorf("IsFinalState(%q) = %v, want %v", state, got, final[state])
+		}
+	}
+}
+
+/*
+Function	: Reaches final
+Description	: Check if a final state can be reached from a state.
+Parameters 	: state, visited states
+Return     	: bool
+Private
+*/
+func reachesFinal(state string, visited map[string]bool) bool {
+	if IsFinalState(state) {
+		return true
+	}
+	visited[state] = true
+	for _, next := range tradeTransitions[state] {
+		if !visited[next] && reachesFinal(next, visited) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,10 +7,13 @@ It also has implicid functions for the User object.
 package models
 
 import (
+	"context"
 	"html"
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User DB object
@@ -19,6 +22,8 @@ type User struct {
 	Username string `gorm:"not_null;unique;" json:"username"`
 	Email    string `gorm:"not_null;unique;" json:"email"`
 	Password string `gorm:"not_null;" json:"password"`
+	// Changes on every write of the user's collection. Sent as the ETag of the collection.
+	CollectionVersion uint `gorm:"not_null;default:0;" json:"collection_version"`
 }
 
 // Used to get the inputs in the frontend
@@ -30,7 +35,7 @@ type UserLoginInput struct {
 // Used to get the inputs in the frontend
 type UserRegisterInput struct {
 	Username string `json:"username" binding:"required"`
-	Email    string `json:"email" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
@@ -164,3 +169,40 @@ func GetUserIDByUsername(username string) (uint, error) {
 	}
 	return user.User_id, nil
 }
+
+/*
+Function	: Get collection version
+Description	: Get the version of the collection of a user.
+Parameters 	: context, UserID
+Return     	: version, error
+*/
+func GetCollectionVersion(ctx context.Context, userID uint) (uint, error) {
+	var user User
+	err := DB.WithContext(ctx).Select("collection_version").Where("user_id = ?", userID).First(&user).Error
+	return user.CollectionVersion, err
+}
+
+/*
+Function	: Lock collection version
+Description	: Get the version of the collection of a user, locking the user until the end of the transaction.
+All the writes of the collection lock it first, so they are done one after the other.
+
+Parameters 	: DB transaction, UserID
+Return     	: version, error
+*/
+func LockCollectionVersion(tx *gorm.DB, userID uint) (uint, error) {
+	var user User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("collection_version").
+		Where("user_id = ?", userID).First(&user).Error
+	return user.CollectionVersion, err
+}
+
+/*
+Function	: Set collection version
+Description	: Change the version of the collection of a user.
+Parameters 	: DB transaction, UserID, version
+Return     	: error
+*/
+func SetCollectionVersion(tx *gorm.DB, userID uint, version uint) error {
+	return tx.Model(&User{}).Where("user_id = ?", userID).Update("collection_version", version).Error
+}
@@ -0,0 +1,80 @@
+/*
+File		: wantlist.go
+Description	: Model file to represent the wantlists of the users (the cards they are looking for) and the matches of a
+wantlist with the collections of the other users.
+*/
+
+package models
+
+import (
+	"time"
+)
+
+// Foil preferences of a wanted card
+const (
+	FoilAny     = "any"
+	FoilOnly    = "foil"
+	FoilNonFoil = "nonfoil"
+)
+
+// Card wanted by a user: any printing of a card (only OracleID) or a specific printing (VersionID).
+type WantlistItem struct {
+	WantID    uint      `gorm:"primary_key;auto_increment;not_null;" json:"want_id"`
+	UserID    uint      `gorm:"not_null;index;" json:"-"`
+	OracleID  string    `gorm:"not_null;size:50;index;" json:"oracle_id"`
+	VersionID string    `gorm:"not_null;size:50;" json:"version_id"` // Empty for any printing
+	Count     uint      `gorm:"not_null;" json:"count"`
+	MinCondi  string    `gorm:"not_null;size:50;" json:"min_condi"` // Worst condition accepted, empty for any condition
+	Foil      string    `gorm:"not_null;size:50;" json:"foil"`      // FoilAny, FoilOnly or FoilNonFoil
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// New card of a wantlist
+type WantRequest struct {
+	OracleID  string `json:"oracle_id"`
+	VersionID string `json:"version_id"`
+	Count     uint   `json:"count" binding:"required,min=1"`
+	MinCondi  string `json:"min_condi"`
+	Foil      string `json:"foil" binding:"omitempty,oneof=any foil nonfoil"`
+}
+
+// Changes of a card of a wantlist. Only the fields sent are changed.
+type WantUpdate struct {
+	Count    *uint   `json:"count" binding:"omitempty,min=1"`
+	MinCondi *string `json:"min_condi"`
+	Foil     *string `json:"foil" binding:"omitempty,oneof=any foil nonfoil"`
+}
+
+// Other user that owns cards of a wantlist
+type UserMatch struct {
+	Username string `json:"username"`
+	Matches  uint   `json:"matches"` // Wanted copies that the user can offer
+	Cards    []Card `json:"cards"`   // Cards of the user that are in the wantlist
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Accepts
+Description	: Check if a card of a collection is what the user wants: the same card (or printing), in the minimum
+condition or better, and with the foil preference.
+
+Self		: WantlistItem
+Parameters 	: CardOwnership
+Return     	: bool
+*/
+func (want *WantlistItem) Accepts(card CardOwnership) bool {
+	if card.OracleID != want.OracleID || (want.VersionID != "" && card.VersionID != want.VersionID) {
+		return false
+	}
+	if want.MinCondi != "" && ConditionRank(card.Condi) > ConditionRank(want.MinCondi) {
+		return false
+	}
+	switch want.Foil {
+	case FoilOnly:
+		return IsFoil(card.Extras)
+	case FoilNonFoil:
+		return !IsFoil(card.Extras)
+	}
+	return true
+}
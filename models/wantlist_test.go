@@ -0,0 +1,37 @@
+/*
+File		: wantlist_test.go
+Description	: Tests of the matching of a wanted card with the cards of a collection.
+*/
+
+package models
+
+import "testing"
+
+func TestWantlistItemAccepts(t *testing.T) {
+	card := CardOwnership{OracleID: "bolt", VersionID: "bolt-m10", Condi: "EX"}
+	foil := CardOwnership{OracleID: "bolt", VersionID: "bolt-m10", Condi: "NM", Extras: "foil"}
+	tests := []struct {
+		name string
+		want WantlistItem
+		card CardOwnership
+		ok   bool
+	}{
+		{"any printing", WantlistItem{OracleID: "bolt"}, card, true},
+		{"other card", WantlistItem{OracleID: "counterspell"}, card, false},
+		{"same printing", WantlistItem{OracleID: "bolt", VersionID: "bolt-m10"}, card, true},
+		{"other printing", WantlistItem{OracleID: "bolt", VersionID: "bolt-lea"}, card, false},
+		{"condition equal", WantlistItem{OracleID: "bolt", MinCondi: "EX"}, card, true},
+		{"condition better", WantlistItem{OracleID: "bolt", MinCondi: "GD"}, card, true},
+		{"condition worse", WantlistItem{OracleID: "bolt", MinCondi: "NM"}, card, false},
+		{"foil only, not foil", WantlistItem{OracleID: "bolt", Foil: FoilOnly}, card, false},
+		{"foil only, foil", WantlistItem{OracleID: "bolt", Foil: FoilOnly}, foil, true},
+		{"non foil, foil", WantlistItem{OracleID: "bolt", Foil: FoilNonFoil}, foil, false},
+		{"non foil, not foil", WantlistItem{OracleID: "bolt", Foil: FoilNonFoil}, card, true},
+		{"any foil", WantlistItem{OracleID: "bolt", Foil: FoilAny}, foil, true},
+	}
+	for _, test := range tests {
+		if got := test.want.Accepts(test.card); got != test.ok {
+			t.Errorf("%s: Accepts = %v, want %v", test.name, got, test.ok)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+File		: webhook.go
+Description	: Model file to represent the webhooks of the users and their deliveries.
+*/
+
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook DB object. URL where the events of a user are sent. The secret is stored in plaintext, because every delivery
+// is signed with it: anyone who can read the table (or a backup of it) can sign fake deliveries.
+type Webhook struct {
+	WebhookID uint      `gorm:"primary_key;auto_increment;not_null;" json:"webhook_id"`
+	UserID    uint      `gorm:"not_null;index;" json:"-"`
+	URL       string    `gorm:"not_null;size:500;" json:"url"`
+	Secret    string    `gorm:"not_null;size:64;" json:"-"` // Key of the signatures. Only sent when the webhook is created.
+	EventList string    `gorm:"column:events;not_null;size:500;" json:"-"`
+	Events    []string  `gorm:"-" json:"events"` // Event types sent to the webhook
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery of an event to a webhook
+type WebhookDelivery struct {
+	DeliveryID    uint       `gorm:"primary_key;auto_increment;not_null;" json:"delivery_id"`
+	WebhookID     uint       `gorm:"not_null;index;" json:"webhook_id"`
+	EventType     string     `gorm:"not_null;size:50;" json:"event_type"`
+	Payload       string     `gorm:"not_null;type:text;" json:"payload"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"status_code"` // Of the last attempt, 0 if there was no response
+	LatencyMs     int64      `json:"latency_ms"`  // Of the last attempt
+	Error         string     `gorm:"size:500;" json:"error"`
+	Success       bool       `json:"success"`
+	Pending       bool       `gorm:"not_null;index;" json:"pending"` // There are attempts left. Resumed when the API starts.
+	NextAttemptAt *time.Time `json:"next_attempt_at"`                // Nil when the delivery is not pending
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// New webhook
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,max=500"`
+	Events []string `json:"events" binding:"required,min=1,dive,required"`
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+Function	: Before save
+Description	: Store the event types of the webhook as a list separated by commas.
+Self		: Webhook
+Parameters 	: DB transaction
+Return     	: error
+*/
+func (webhook *Webhook) BeforeSave(tx *gorm.DB) error {
+	webhook.EventList = strings.Join(webhook.Events, ",")
+	return nil
+}
+
+/*
+Function	: After find
+Description	: Read the event types of the webhook.
+Self		: Webhook
+Parameters 	: DB transaction
+Return     	: error
+*/
+func (webhook *Webhook) AfterFind(tx *gorm.DB) error {
+	webhook.Events = strings.Split(webhook.EventList, ",")
+	return nil
+}
+
+/*
+Function	: Wants event
+Description	: Check if an event type is sent to the webhook.
+Self		: Webhook
+Parameters 	: event type
+Return     	: bool
+*/
+func (webhook *Webhook) WantsEvent(eventType string) bool {
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
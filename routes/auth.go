@@ -8,12 +8,24 @@ package routes
 import (
 	"CardaliaAPI/connections"
 	"CardaliaAPI/models"
+	"CardaliaAPI/utils/events"
 	"CardaliaAPI/utils/token"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Maximum size of an imported file
+const maxImportSize = 10 << 20
+
+// Time between the heartbeats of the event streams, so the proxies don't close them
+const eventsHeartbeat = 25 * time.Second
+
 /*
 Function	: Change password
 Description	: Changes user's password
@@ -73,11 +85,18 @@ func SaveCollection(c *gin.Context) {
 		return
 	}
 
-	// Save the recived collection to the DB
-	if err := connections.SaveUserCollectionDB(ownershipList, user_id); err != nil {
+	// Save the recived collection to the DB, if it was not changed since the version the client has
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	version, err := connections.SaveUserCollectionDB(c.Request.Context(), ownershipList, user_id, ifMatch)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, version)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Collection saved"})
 }
@@ -96,16 +115,336 @@ func GetCollection(c *gin.Context) {
 		return
 	}
 
+	// Get the version before the collection, so a write in between makes the version old instead of too new
+	version, err := models.GetCollectionVersion(c.Request.Context(), user_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 	// Get the user's collection from the DB
-	collection, err := connections.GetCollectionByUserIdDB(user_id)
+	collection, err := connections.GetCollectionByUserIdDB(c.Request.Context(), user_id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
+	setCollectionETag(c, version)
 
 	c.JSON(http.StatusOK, gin.H{"collection": collection})
 }
 
+/*
+Function	: Import collection (POST /user/collection/import)
+Description	: Add to the collection of the user the cards of a file exported by another collection manager, or of a
+pasted text list.
+
+Parameters 	: gin context -> request auth {token}
+
+	-> multipart form {file or list, format (text, csv, moxfield, deckbox, manabox, delverlens, tcgplayer)}
+
+Return     	: ImportReport
+*/
+func ImportCollection(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the uploaded file or the pasted list
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	format := c.DefaultPostForm("format", c.Query("format"))
+	var input io.Reader
+	if list, ok := c.GetPostForm("list"); ok {
+		input = strings.NewReader(list)
+	} else {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		input = file
+	}
+
+	// Add the cards to the user's collection
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report, err := connections.ImportCollection(c.Request.Context(), user_id, ifMatch, input, format)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, report.Version)
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+/*
+Function	: Export collection (GET /user/collection/export)
+Description	: Download the collection of the user in a format that other collection managers can import. If the export
+fails after the file started, the connection is closed, so the client never gets a cut file as a complete one.
+Parameters 	: gin context -> request auth {token}
+
+	-> request query {format (csv, json, txt, moxfield, deckbox)}
+
+Return     	: collection file
+*/
+func ExportCollection(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format, err := connections.GetExportFormat(c.DefaultQuery("format", "csv"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Stream the collection. The headers are sent when the first cards are resolved.
+	err = connections.ExportCollection(c.Request.Context(), user_id, format, c.Writer, func() {
+		c.Header("Content-Type", format.ContentType)
+		c.Header("Content-Disposition", `attachment; filename="collection.`+format.Extension+`"`)
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+	})
+	if err != nil {
+		if !c.Writer.Written() {
+			abortWithError(c, err)
+			return
+		}
+		// The status is already sent: abort the connection, so the client sees a failed download
+		c.Error(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+/*
+Function	: Get collection value (GET /user/collection/value)
+Description	: Value the collection of the user with the last known prices, by card, by set and by foil or not.
+Parameters 	: gin context -> request auth {token}
+
+	-> request query {currency} (usd, eur or tix, default usd)
+
+Return     	: CollectionValue
+*/
+func GetCollectionValue(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	value, err := connections.GetCollectionValueDB(c.Request.Context(), user_id, c.DefaultQuery("currency", models.CurrencyUSD))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"value": value})
+}
+
+/*
+Function	: Get collection value history (GET /user/collection/value/history)
+Description	: Get the daily value of the collection of the user, by default of the last 90 days.
+Parameters 	: gin context -> request auth {token}
+
+	-> request query {currency, from, to} (usd, eur or tix, default usd; YYYY-MM-DD)
+
+Return     	: ValuePoint list
+*/
+func GetCollectionValueHistory(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := dateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := connections.GetCollectionValueHistoryDB(c.Request.Context(), userID, c.DefaultQuery("currency", models.CurrencyUSD), from, to)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"history": history})
+}
+
+/*
+Function	: Add collection item (POST /user/collection/items)
+Description	: Add copies of a card to the collection of the user.
+Parameters 	: gin context -> request auth {token}
+
+	-> request body {version_id, extras, condi, count}
+
+Return     	: CardOwnership
+*/
+func AddCollectionItem(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cardOwnership models.CardOwnership
+	if err := c.ShouldBindJSON(&cardOwnership); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cardOwnership, version, err := connections.AddCollectionItemDB(c.Request.Context(), user_id, ifMatch, cardOwnership)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, version)
+
+	c.JSON(http.StatusOK, gin.H{"card": cardOwnership})
+}
+
+/*
+Function	: Modify collection item (PATCH /user/collection/items/:card_id)
+Description	: Change the count, extras or condition of a card of the collection of the user. A count of 0 removes it.
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {card_id}
+	-> request body {count, extras, condi} (all optional)
+
+Return     	: CardOwnership
+*/
+func ModifyCollectionItem(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	card_id, err := idParam(c, "card_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var update models.CardOwnershipUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cardOwnership, version, err := connections.ModifyCollectionItemDB(c.Request.Context(), user_id, card_id, ifMatch, update)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, version)
+
+	c.JSON(http.StatusOK, gin.H{"card": cardOwnership})
+}
+
+/*
+Function	: Delete collection item (DELETE /user/collection/items/:card_id)
+Description	: Remove a card from the collection of the user.
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {card_id}
+
+Return     	: message
+*/
+func DeleteCollectionItem(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	card_id, err := idParam(c, "card_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := connections.DeleteCollectionItemDB(c.Request.Context(), user_id, card_id, ifMatch)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, version)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Card deleted"})
+}
+
+/*
+Function	: Patch collection (PATCH /user/collection/items)
+Description	: Apply a list of add, remove and adjust operations to the collection of the user. Either all the operations
+are applied or none.
+
+Parameters 	: gin context -> request auth {token}
+
+	-> request body [{op, card_id, version_id, extras, condi, count}]
+
+Return     	: CardOwnership list
+*/
+func PatchCollection(c *gin.Context) {
+	// Get ths userID that sends the request
+	user_id, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var operations []models.CollectionOperation
+	if err := c.ShouldBindJSON(&operations); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ifMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cards, version, err := connections.PatchCollectionDB(c.Request.Context(), user_id, ifMatch, operations)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	setCollectionETag(c, version)
+
+	c.JSON(http.StatusOK, gin.H{"cards": cards})
+}
+
 /*
 Function	: Get all users collections by cardID(GET /users/collections/:cardname)
 Description	: Get all the users collections that have a specific card.
@@ -118,9 +457,9 @@ func GetAllUserCollectionsByCardId(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	userCollections, err := connections.GetAllUserCollectionsByCardIdDB(userIDAvoid, c.Params.ByName("card_id"))
+	userCollections, err := connections.GetAllUserCollectionsByCardIdDB(c.Request.Context(), userIDAvoid, c.Params.ByName("card_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
@@ -153,20 +492,23 @@ func NewTrade(c *gin.Context) {
 	}
 
 	// Create a new trade
-	if err = connections.NewTradeDB(user_id_origin, holeTrade); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	trade_id, err := connections.NewTradeDB(c.Request.Context(), user_id_origin, holeTrade)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "New trade offer made"})
+	c.JSON(http.StatusOK, gin.H{"message": "New trade offer made", "trade_id": trade_id})
 }
 
 /*
 Function	: Modify Trade (PUT /user/trade)
-Description	: Modify a parameter o a trade (username, whatHeTrade, whatYouTrade, heChecked, youChecked)
+Description	: Modify a parameter o a trade (username, whatHeTrade, whatYouTrade, heChecked, youChecked). Without
+trade_id, the last negotiated trade with the user is modified.
+
 Parameters 	: gin context -> request auth {token}
 
-	-> request param {username, whatHeTrade, whatYouTrade, heChecked, youChecked}
+	-> request param {trade_id, username, whatHeTrade, whatYouTrade, heChecked, youChecked}
 
 Return     	: message
 */
@@ -186,8 +528,8 @@ func ModifyTrade(c *gin.Context) {
 	}
 
 	// Modify the trade
-	if err = connections.ModifyTradeDB(user_id_origin, holeTrade); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err = connections.ModifyTradeDB(c.Request.Context(), user_id_origin, holeTrade); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
@@ -195,8 +537,8 @@ func ModifyTrade(c *gin.Context) {
 }
 
 /*
-Function	: Delete Trade (DELETE /user/trade)
-Description	: Delete a trade between two users
+Function	: Delete Trade (DELETE /user/trade/:username)
+Description	: Cancel all the negotiated trades between two users
 Parameters 	: gin context -> request auth {token}
 Return     	: message
 */
@@ -210,11 +552,15 @@ func DeleteTrade(c *gin.Context) {
 
 	// Get the target userID
 	user_id2, err := models.GetUserIDByUsername(c.Params.ByName("username"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Delete all trades that are not finished between users
-	err = connections.DeleteAllTradesBetweenUsersDB(user_id1, user_id2)
+	err = connections.DeleteAllTradesBetweenUsersDB(c.Request.Context(), user_id1, user_id2)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
@@ -236,12 +582,925 @@ func GetTrades(c *gin.Context) {
 	}
 
 	// Get all trades that user participates in, including the finished ones.
-	trades, err := connections.GetTradesDB(user_id_origin)
+	trades, err := connections.GetTradesDB(c.Request.Context(), user_id_origin)
 
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"trades": trades})
 }
+
+/*
+Function	: Create Trade (POST /trades)
+Description	: Start a new trade and get it.
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {username, whatHeTrade, whatYouTrade, youChecked}
+
+Return     	: Trade
+*/
+func CreateTrade(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var holeTrade models.HoleTrade
+	if err = c.ShouldBindJSON(&holeTrade); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trade_id, err := connections.NewTradeDB(c.Request.Context(), userID, holeTrade)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	trade, err := connections.GetTradeDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"trade": trade})
+}
+
+/*
+Function	: Get Trade (GET /trades/:id)
+Description	: Get a trade of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: Trade
+*/
+func GetTrade(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trade, err := connections.GetTradeDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trade": trade})
+}
+
+/*
+Function	: Update Trade (PUT /trades/:id)
+Description	: Change the cards of a negotiated trade and the acceptance of the user. If the revision is sent, the
+trade is only changed if it is still the current one.
+
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {revision, whatHeTrade, whatYouTrade, youChecked}
+
+Return     	: Trade
+*/
+func UpdateTrade(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var holeTrade models.HoleTrade
+	if err = c.ShouldBindJSON(&holeTrade); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.UpdateTradeDB(c.Request.Context(), userID, trade_id, holeTrade); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	trade, err := connections.GetTradeDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trade": trade})
+}
+
+/*
+Function	: Cancel Trade (DELETE /trades/:id)
+Description	: Cancel a negotiated trade of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: message
+*/
+func CancelTrade(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.CancelTradeDB(c.Request.Context(), userID, trade_id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trade cancelled"})
+}
+
+/*
+Function	: Change Trade State (POST /trades/:id/state)
+Description	: Move a trade of the user to another state (shipped, received, completed or cancelled).
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {state}
+
+Return     	: Trade
+*/
+func ChangeTradeState(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var stateChange models.TradeStateChange
+	if err = c.ShouldBindJSON(&stateChange); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.ChangeTradeStateDB(c.Request.Context(), userID, trade_id, stateChange.State); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	trade, err := connections.GetTradeDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trade": trade})
+}
+
+/*
+Function	: Accept Trade (POST /trades/:id/accept)
+Description	: Accept a revision of a trade of the user.
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {revision}
+
+Return     	: Trade
+*/
+func AcceptTrade(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var acceptance models.TradeAcceptance
+	if err = c.ShouldBindJSON(&acceptance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.AcceptTradeDB(c.Request.Context(), userID, trade_id, acceptance.Revision); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	trade, err := connections.GetTradeDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trade": trade})
+}
+
+/*
+Function	: Get Trade Revisions (GET /trades/:id/revisions)
+Description	: Get the revisions of a trade of the user, with the changes of each of them.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: Revision list
+*/
+func GetTradeRevisions(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	revisions, err := connections.GetTradeRevisionsDB(c.Request.Context(), userID, trade_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+/*
+Function	: Send Trade Message (POST /trades/:id/messages)
+Description	: Send a message to the other user of a trade.
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {body}
+
+Return     	: Message
+*/
+func SendTradeMessage(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var messageRequest models.MessageRequest
+	if err = c.ShouldBindJSON(&messageRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := connections.SendTradeMessageDB(c.Request.Context(), userID, trade_id, messageRequest.Body)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": message})
+}
+
+/*
+Function	: Get Trade Messages (GET /trades/:id/messages)
+Description	: Get a page of the messages of a trade, the newest first. The messages of the other user are marked as read.
+Parameters 	: gin context -> request auth {token}	:id	?cursor	?limit
+Return     	: Message list, cursor of the next page
+*/
+func GetTradeMessages(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trade_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := 0
+	if c.Query("limit") != "" {
+		if limit, err = strconv.Atoi(c.Query("limit")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit %q", c.Query("limit"))})
+			return
+		}
+	}
+
+	messages, nextCursor, err := connections.GetTradeMessagesDB(c.Request.Context(), userID, trade_id, c.Query("cursor"), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages, "next_cursor": nextCursor})
+}
+
+/*
+Function	: Get Trade Cycles (GET /trade-cycles)
+Description	: Get the trade cycles of the user, with his trades in them.
+Parameters 	: gin context -> request auth {token}
+Return     	: HoleTradeCycle list
+*/
+func GetTradeCycles(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cycles, err := connections.GetTradeCyclesDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cycles": cycles})
+}
+
+/*
+Function	: Get Trade Cycle (GET /trade-cycles/:id)
+Description	: Get a trade cycle of the user, with his trades in it.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: HoleTradeCycle
+*/
+func GetTradeCycle(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cycle_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cycle, err := connections.GetTradeCycleDB(c.Request.Context(), userID, cycle_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cycle": cycle})
+}
+
+/*
+Function	: Answer Trade Cycle (POST /trade-cycles/:id/answer)
+Description	: Accept or decline a trade cycle of the user. Its trades are accepted when all the users accept it.
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {answer} (accepted or declined)
+
+Return     	: HoleTradeCycle
+*/
+func AnswerTradeCycle(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cycle_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var cycleAnswer models.CycleAnswer
+	if err = c.ShouldBindJSON(&cycleAnswer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.AnswerTradeCycleDB(c.Request.Context(), userID, cycle_id, cycleAnswer.Answer); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	cycle, err := connections.GetTradeCycleDB(c.Request.Context(), userID, cycle_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cycle": cycle})
+}
+
+/*
+Function	: Get Events (GET /events)
+Description	: Stream the events of the user (trades and messages) with Server-Sent Events. The token can be sent in the
+token query param, because the browsers can't send headers with an EventSource.
+
+Parameters 	: gin context -> request auth {token}
+Return     	: Event stream
+*/
+func GetEvents(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription, unsubscribe := events.Events.Subscribe(userID)
+	defer unsubscribe()
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-subscription:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+/*
+Function	: Get Wantlist (GET /user/wantlist)
+Description	: Get the cards that the user wants.
+Parameters 	: gin context -> request auth {token}
+Return     	: WantlistItem list
+*/
+func GetWantlist(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wantlist, err := connections.GetWantlistDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wantlist": wantlist})
+}
+
+/*
+Function	: Add Want (POST /user/wantlist)
+Description	: Add a card to the wantlist of the user, in any printing (oracle_id) or in one printing (version_id).
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {oracle_id, version_id, count, min_condi, foil}
+
+Return     	: WantlistItem
+*/
+func AddWant(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var wantRequest models.WantRequest
+	if err = c.ShouldBindJSON(&wantRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	want, err := connections.AddWantDB(c.Request.Context(), userID, wantRequest)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"want": want})
+}
+
+/*
+Function	: Modify Want (PUT /user/wantlist/:id)
+Description	: Change the copies, minimum condition or foil preference of a card of the wantlist of the user.
+Parameters 	: gin context -> request auth {token}	:id
+
+	-> request param {count, min_condi, foil} (only the fields to change)
+
+Return     	: WantlistItem
+*/
+func ModifyWant(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	want_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var update models.WantUpdate
+	if err = c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	want, err := connections.ModifyWantDB(c.Request.Context(), userID, want_id, update)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"want": want})
+}
+
+/*
+Function	: Delete Want (DELETE /user/wantlist/:id)
+Description	: Remove a card from the wantlist of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: message
+*/
+func DeleteWant(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	want_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.DeleteWantDB(c.Request.Context(), userID, want_id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Card removed from the wantlist"})
+}
+
+/*
+Function	: Get Matches (GET /user/matches)
+Description	: Get the other users that own cards of the wantlist of the user, ranked by the wanted copies they have.
+Parameters 	: gin context -> request auth {token}
+Return     	: UserMatch list
+*/
+func GetMatches(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	matches, err := connections.GetMatchesDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"matches": matches})
+}
+
+/*
+Function	: Get Trade Suggestions (GET /user/matches/trades)
+Description	: Get the trades where the user and another user both get cards of their wantlists. Every trade can be sent
+as it is to POST /user/trade.
+
+Parameters 	: gin context -> request auth {token}
+Return     	: HoleTrade list {username, whatHeTrade, whatYouTrade}
+*/
+func GetTradeSuggestions(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	suggestions, err := connections.GetTradeSuggestionsDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trades": suggestions})
+}
+
+/*
+Function	: Get Price Alerts (GET /user/price-alerts)
+Description	: Get the price alerts of the user.
+Parameters 	: gin context -> request auth {token}
+Return     	: PriceAlert list
+*/
+func GetPriceAlerts(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alerts, err := connections.GetPriceAlertsDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"price_alerts": alerts})
+}
+
+/*
+Function	: Add Price Alert (POST /user/price-alerts)
+Description	: Add a price alert for a card version, or for all the cards of the collection of the user (without
+version_id). The user is notified when the price rises or drops a percentage compared with the price of some days ago.
+
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {version_id, foil, currency, direction, percent, days}
+
+Return     	: PriceAlert
+*/
+func AddPriceAlert(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var alertRequest models.PriceAlertRequest
+	if err = c.ShouldBindJSON(&alertRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alert, err := connections.AddPriceAlertDB(c.Request.Context(), userID, alertRequest)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"price_alert": alert})
+}
+
+/*
+Function	: Delete Price Alert (DELETE /user/price-alerts/:id)
+Description	: Remove a price alert of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: message
+*/
+func DeletePriceAlert(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alert_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.DeletePriceAlertDB(c.Request.Context(), userID, alert_id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price alert removed"})
+}
+
+/*
+Function	: Get Notification Preferences (GET /user/notifications)
+Description	: Get the email notifications that the user wants.
+Parameters 	: gin context -> request auth {token}
+Return     	: NotificationPreferences
+*/
+func GetNotificationPreferences(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preferences, err := connections.GetNotificationPreferencesDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": preferences})
+}
+
+/*
+Function	: Save Notification Preferences (PUT /user/notifications)
+Description	: Choose the email notifications that the user wants, and if he wants them in a daily digest.
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {proposals, acceptances, completions, messages, digest}
+
+Return     	: NotificationPreferences
+*/
+func SaveNotificationPreferences(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var preferences models.NotificationPreferences
+	if err = c.ShouldBindJSON(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preferences, err = connections.SaveNotificationPreferencesDB(c.Request.Context(), userID, preferences)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": preferences})
+}
+
+/*
+Function	: Create Webhook (POST /user/webhooks)
+Description	: Register a webhook of the user. The secret to check the signatures of the deliveries is only sent here.
+Parameters 	: gin context -> request auth {token}
+
+	-> request param {url, events}
+
+Return     	: Webhook, secret
+*/
+func CreateWebhook(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var webhookRequest models.WebhookRequest
+	if err = c.ShouldBindJSON(&webhookRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, secret, err := connections.CreateWebhookDB(c.Request.Context(), userID, webhookRequest)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": webhook, "secret": secret})
+}
+
+/*
+Function	: Get Webhooks (GET /user/webhooks)
+Description	: Get the webhooks of the user.
+Parameters 	: gin context -> request auth {token}
+Return     	: Webhook list
+*/
+func GetWebhooks(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhooks, err := connections.GetWebhooksDB(c.Request.Context(), userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+/*
+Function	: Delete Webhook (DELETE /user/webhooks/:id)
+Description	: Delete a webhook of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: message
+*/
+func DeleteWebhook(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = connections.DeleteWebhookDB(c.Request.Context(), userID, webhook_id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+/*
+Function	: Get Webhook Deliveries (GET /user/webhooks/:id/deliveries)
+Description	: Get the last deliveries of a webhook of the user.
+Parameters 	: gin context -> request auth {token}	:id
+Return     	: Delivery list
+*/
+func GetWebhookDeliveries(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deliveries, err := connections.GetWebhookDeliveriesDB(c.Request.Context(), userID, webhook_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+/*
+Function	: Redeliver (POST /user/webhooks/:id/deliveries/:delivery_id/redeliver)
+Description	: Send again a delivery of a webhook of the user.
+Parameters 	: gin context -> request auth {token}	:id	:delivery_id
+Return     	: new Delivery
+*/
+func Redeliver(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook_id, err := idParam(c, "id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	delivery_id, err := idParam(c, "delivery_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	delivery, err := connections.RedeliverDB(c.Request.Context(), userID, webhook_id, delivery_id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"delivery": delivery})
+}
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+/*
+Function	: ID param
+Description	: Read a numeric ID param of the request.
+Parameters 	: gin context, param name
+Return     	: ID, error
+Private
+*/
+func idParam(c *gin.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Params.ByName(name), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, c.Params.ByName(name))
+	}
+	return uint(id), nil
+}
+
+/*
+Function	: If-Match version
+Description	: Read the collection version of the If-Match header of the request. A missing header or "*" means that
+the write doesn't depend on the version.
+
+Parameters 	: gin context
+Return     	: version (nil if there is no check), error
+Private
+*/
+func ifMatchVersion(c *gin.Context) (*uint, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	value := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid If-Match header %q", header)
+	}
+	ifMatch := uint(version)
+	return &ifMatch, nil
+}
+
+/*
+Function	: Set collection ETag
+Description	: Send the version of the collection as the ETag of the response.
+Parameters 	: gin context, version
+Return     	:
+Private
+*/
+func setCollectionETag(c *gin.Context, version uint) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, version))
+}
@@ -0,0 +1,59 @@
+/*
+File		: errors.go
+Description	: File that maps the errors of the API to HTTP status codes.
+*/
+
+package routes
+
+import (
+	"CardaliaAPI/connections"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Function	: Error status
+Description	: Get the HTTP status code for an error. Errors that are not from the card provider are bad requests.
+Parameters 	: error
+Return     	: HTTP status code
+*/
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, connections.ErrCardNotFound), errors.Is(err, connections.ErrTradeNotFound),
+		errors.Is(err, connections.ErrWebhookNotFound), errors.Is(err, connections.ErrWantNotFound),
+		errors.Is(err, connections.ErrCycleNotFound), errors.Is(err, connections.ErrPriceAlertNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, connections.ErrUpstreamUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, connections.ErrStaleCollection):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, connections.ErrIllegalTransition), errors.Is(err, connections.ErrStaleTrade),
+		errors.Is(err, connections.ErrNotAvailable):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+/*
+Function	: Abort with error
+Description	: Send an error response with the status code of the error. If the collection was changed by another
+request, the current version is also sent (body and ETag), so the client can reload it.
+
+Parameters 	: gin context, error
+Return     	:
+*/
+func abortWithError(c *gin.Context, err error) {
+	var conflict connections.CollectionConflictError
+	if errors.As(err, &conflict) {
+		setCollectionETag(c, conflict.Current)
+		c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error(), "version": conflict.Current})
+		return
+	}
+	c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error()})
+}
@@ -7,7 +7,10 @@ package routes
 
 import (
 	"CardaliaAPI/connections"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"CardaliaAPI/models"
 
@@ -80,25 +83,27 @@ func Login(c *gin.Context) {
 
 /*
 Function	: Get cards by uncompleted cardname (GET /cards/:autocomplete)
-Description	: Given an uncompleted card name, the function calls GetCardUncompleted and builds a list of card
+Description	: Given an uncompleted card name, the function calls the card provider autocomplete and builds a list of card
 structs with the first 8 cards matches.
 
 Parameters 	: gin context 	:autocomplete
 Return     	: Card list
 */
 func GetCardsByName(c *gin.Context) {
-	var cards = []string{}
-
 	// Get the list of cards (strings) that match the search
-	cards = connections.GetCardUncompletedScryfall(c.Params.ByName("autocomplete"))
+	cards, err := connections.Cards.Autocomplete(c.Request.Context(), c.Params.ByName("autocomplete"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 	var showncards = []models.Card{}
 
-	// For each card, get the card in Scryfall
+	// For each card, get the card from the card provider
 	for index, card := range cards {
-		var newCard models.Card
-		newCard, err := connections.GetCardByNameScryfall(card)
+		newCard, err := connections.Cards.CardByName(c.Request.Context(), card)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			abortWithError(c, err)
+			return
 		}
 		showncards = append(showncards, newCard)
 		// Change this value to get more or less Cards
@@ -116,13 +121,39 @@ Parameters 	: gin context	:cardname
 Return     	: CardVersion list
 */
 func GetCardVersions(c *gin.Context) {
-	cardVersionsList, err := connections.GetCardVersionsScryfall(c.Params.ByName("cardname"))
+	cardVersionsList, err := connections.Cards.Printings(c.Request.Context(), c.Params.ByName("cardname"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
+		return
 	}
 	c.IndentedJSON(http.StatusOK, cardVersionsList)
 }
 
+/*
+Function	: Get price history (GET /cards/:id/prices)
+Description	: Get the daily prices of a card version (the snapshots of its prices), by default of the last 90 days.
+Parameters 	: gin context	:id (version ID)
+
+	-> request query {from, to} (YYYY-MM-DD)
+
+Return     	: PriceSnapshot list
+*/
+func GetPriceHistory(c *gin.Context) {
+	from, to, err := dateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prices, err := connections.GetPriceHistoryDB(c.Request.Context(), c.Params.ByName("autocomplete"), from, to)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prices": prices})
+}
+
 /*
 Function	: Get a user collection by username (GET /user/collection/:username)
 Description	: Get the collection of a user.
@@ -130,10 +161,48 @@ Parameters 	: gin context	:username
 Return     	: Collection
 */
 func GetUserCollectionByName(c *gin.Context) {
-	collection, err := connections.GetUserCollectionByNameDB(c.Params.ByName("username"))
+	collection, err := connections.GetUserCollectionByNameDB(c.Request.Context(), c.Params.ByName("username"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"user_collection": collection})
 }
+
+/////////////////////////////////////////////////// SUPORT FUNCTIONS ///////////////////////////////////////////////////
+
+// Days of a history without from
+const defaultHistoryDays = 90
+
+/*
+Function	: Date range
+Description	: Read the from and to query params (YYYY-MM-DD) of a history request. Without to it ends today, and without
+from it starts defaultHistoryDays before to.
+
+Parameters 	: gin context
+Return     	: from, to, error
+Private
+*/
+func dateRange(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	if query := c.Query("to"); query != "" {
+		day, err := time.ParseInLocation("2006-01-02", query, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to %q", query)
+		}
+		to = day
+	}
+	from := to.AddDate(0, 0, -defaultHistoryDays)
+	if query := c.Query("from"); query != "" {
+		day, err := time.ParseInLocation("2006-01-02", query, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from %q", query)
+		}
+		from = day
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from is after to")
+	}
+	return from, to, nil
+}
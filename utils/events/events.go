@@ -0,0 +1,137 @@
+/*
+File		: events.go
+Description	: File that deals with the real time events sent to the users (trades, messages and price alerts). The events are
+published to a Broker, that sends them to the subscriptions of the users. The Hub is an in-process Broker; another
+Broker (backed by a pub/sub service) can be used when the API runs in more than one process.
+*/
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TradeCreated    = "trade.created"    // A user started a trade with the user
+	TradeRevised    = "trade.revised"    // The cards of a trade changed
+	TradeAccepted   = "trade.accepted"   // Both users accepted a trade
+	TradeCompleted  = "trade.completed"  // A trade is finished
+	TradeState      = "trade.state"      // Other changes of the state of a trade (shipped, cancelled, ...)
+	MessageCreated  = "message.created"  // The other user of a trade sent a message
+	CollectionSaved = "collection.saved" // The whole collection of the user was saved
+	CycleProposed   = "cycle.proposed"   // The user is in a new trade cycle
+	CycleAnswered   = "cycle.answered"   // A user of a trade cycle accepted it
+	CycleState      = "cycle.state"      // A trade cycle was accepted by all its users, declined or expired
+	PriceAlert      = "price.alert"      // Cards of the price alerts of the user changed their price
+)
+
+// All the event types
+var Types = []string{TradeCreated, TradeRevised, TradeAccepted, TradeCompleted, TradeState, MessageCreated, CollectionSaved,
+	CycleProposed, CycleAnswered, CycleState, PriceAlert}
+
+// Number of events kept for a subscription that is not reading them. The newer events are dropped.
+const subscriptionBuffer = 32
+
+// Event sent to a user
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// Sends the published events to the subscriptions of the users
+type Broker interface {
+	Publish(event Event, userIDs ...uint)
+	Subscribe(userID uint) (<-chan Event, func())
+}
+
+// Broker used by the API
+var Events Broker = NewHub()
+
+// In-process Broker
+type Hub struct {
+	mutex         sync.RWMutex
+	subscriptions map[uint]map[chan Event]struct{}
+}
+
+/*
+Function	: New Hub
+Description	: Build an in-process Broker without subscriptions.
+Parameters 	:
+Return     	: Hub
+*/
+func NewHub() *Hub {
+	return &Hub{subscriptions: make(map[uint]map[chan Event]struct{})}
+}
+
+/*
+Function	: Publish
+Description	: Send an event to all the subscriptions of some users. It never blocks: if a subscription is not reading
+its events and its buffer is full, the event is dropped for it.
+
+Self		: Hub
+Parameters 	: Event, userID list
+Return     	:
+*/
+func (hub *Hub) Publish(event Event, userIDs ...uint) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for _, userID := range userIDs {
+		for subscription := range hub.subscriptions[userID] {
+			select {
+			case subscription <- event:
+			default:
+			}
+		}
+	}
+}
+
+/*
+Function	: Subscribe
+Description	: Start receiving the events of a user. The returned function ends the subscription and closes the channel.
+Self		: Hub
+Parameters 	: userID
+Return     	: Event channel, unsubscribe function
+*/
+func (hub *Hub) Subscribe(userID uint) (<-chan Event, func()) {
+	subscription := make(chan Event, subscriptionBuffer)
+	hub.mutex.Lock()
+	if hub.subscriptions[userID] == nil {
+		hub.subscriptions[userID] = make(map[chan Event]struct{})
+	}
+	hub.subscriptions[userID][subscription] = struct{}{}
+	hub.mutex.Unlock()
+
+	var once sync.Once
+	return subscription, func() {
+		once.Do(func() {
+			hub.mutex.Lock()
+			delete(hub.subscriptions[userID], subscription)
+			if len(hub.subscriptions[userID]) == 0 {
+				delete(hub.subscriptions, userID)
+			}
+			hub.mutex.Unlock()
+			close(subscription)
+		})
+	}
+}
+
+/*
+Function	: Is type
+Description	: Check if a string is an event type.
+Parameters 	: event type
+Return     	: bool
+*/
+func IsType(eventType string) bool {
+	for _, t := range Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+File		: mailer.go
+Description	: File that deals with the emails sent by the API. The emails are sent with a Mailer: SMTP in production,
+or a directory where every email is written as a file (a local sink for development). The Mailer is chosen with
+MAIL_BACKEND (smtp, file or none).
+*/
+
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Email sent to a user
+type Mail struct {
+	To      string
+	Subject string
+	Body    string // Plain text
+}
+
+// Sends emails
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// Mailer that sends the emails to a SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Mailer that writes every email to a file of a directory (maildir "new" layout), for development
+type FileMailer struct {
+	Dir string
+}
+
+// Mailer that doesn't send the emails
+type NoMailer struct{}
+
+// Sequence of the file names of the FileMailer
+var fileSequence uint64
+
+/*
+Function	: New from env
+Description	: Build the Mailer chosen with the environment.
+
+	MAIL_BACKEND	: smtp, file or none (default none)
+	MAIL_FROM	: sender of the emails
+	SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD	: SMTP server
+	MAIL_DIR	: directory of the file sink (default mail)
+
+Parameters 	:
+Return     	: Mailer, error
+*/
+func NewFromEnv() (Mailer, error) {
+	switch os.Getenv("MAIL_BACKEND") {
+	case "", "none":
+		return NoMailer{}, nil
+	case "smtp":
+		mailer := SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if mailer.Host == "" || mailer.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and MAIL_FROM are required by the smtp mail backend")
+		}
+		if mailer.Port == "" {
+			mailer.Port = "587"
+		}
+		return mailer, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "new"), 0o755); err != nil {
+			return nil, err
+		}
+		return FileMailer{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", os.Getenv("MAIL_BACKEND"))
+	}
+}
+
+/*
+Function	: Send
+Description	: Send an email with the SMTP server. The authentication is only used if there is a username.
+Self		: SMTPMailer
+Parameters 	: Mail
+Return     	: error
+*/
+func (mailer SMTPMailer) Send(mail Mail) error {
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+	return smtp.SendMail(mailer.Host+":"+mailer.Port, auth, mailer.From, []string{mail.To}, message(mailer.From, mail))
+}
+
+/*
+Function	: Send
+Description	: Write an email to a new file of the directory.
+Self		: FileMailer
+Parameters 	: Mail
+Return     	: error
+*/
+func (mailer FileMailer) Send(mail Mail) error {
+	name := fmt.Sprintf("%d.%d.cardalia.eml", time.Now().UnixNano(), atomic.AddUint64(&fileSequence, 1))
+	return os.WriteFile(filepath.Join(mailer.Dir, "new", name), message(os.Getenv("MAIL_FROM"), mail), 0o644)
+}
+
+/*
+Function	: Send
+Description	: Don't send the email.
+Self		: NoMailer
+Parameters 	: Mail
+Return     	: error
+*/
+func (NoMailer) Send(mail Mail) error {
+	return nil
+}
+
+/*
+Function	: Message
+Description	: Build the RFC 5322 message of an email.
+Parameters 	: sender, Mail
+Return     	: message
+Private
+*/
+func message(from string, mail Mail) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&builder, "To: %s\r\n", headerValue(mail.To))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", headerValue(mail.Subject))
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
+
+/*
+Function	: Header value
+Description	: Remove the line breaks of a header value, so it can't add other headers.
+Parameters 	: value
+Return     	: value in one line
+Private
+*/
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,38 @@
+/*
+File		: mailer_test.go
+Description	: Tests of the messages built for the emails.
+*/
+
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		mail Mail
+	}{
+		{"plain", Mail{To: "user@example.com", Subject: "New trade", Body: "Hi"}},
+		{"line break in the address", Mail{To: "user@example.com\r\nBcc: other@example.com", Subject: "New trade"}},
+		{"line break in the subject", Mail{To: "user@example.com", Subject: "New trade\nBcc: other@example.com"}},
+	}
+	for _, test := range tests {
+		msg := string(message("cardalia@example.com", test.mail))
+		headers, _, found := strings.Cut(msg, "\r\n\r\n")
+		if !found {
+			t.Fatalf("%s: no end of the headers in %q", test.name, msg)
+		}
+		var names []string
+		for _, line := range strings.Split(headers, "\r\n") {
+			name, _, _ := strings.Cut(line, ":")
+			names = append(names, name)
+		}
+		want := "From,To,Subject,Date,MIME-Version,Content-Type"
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("%s: headers %s, want %s", test.name, got, want)
+		}
+	}
+}
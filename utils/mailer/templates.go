@@ -0,0 +1,102 @@
+/*
+File		: templates.go
+Description	: Templates of the emails sent to the users. Every template has a subject and a plain text body.
+*/
+
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Data of the templates
+type TemplateData struct {
+	Username string // User that receives the email
+	Other    string // Other user of the trade
+	TradeID  uint
+	State    string
+	Message  string   // Body of a message
+	Items    []string // Lines of a digest or of a price alert
+}
+
+// Subject and body of an email
+type mailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// Templates by name (the event types, and "digest")
+var templates = map[string]mailTemplate{
+	"trade.created": newTemplate(
+		"{{.Other}} proposed you a trade",
+		"Hi {{.Username}},\n\n{{.Other}} proposed you a trade (#{{.TradeID}}). Open Cardalia to see the cards and answer.\n"),
+	"trade.revised": newTemplate(
+		"{{.Other}} changed your trade #{{.TradeID}}",
+		"Hi {{.Username}},\n\n{{.Other}} made a new proposal for your trade #{{.TradeID}}. Open Cardalia to see what changed.\n"),
+	"trade.accepted": newTemplate(
+		"Your trade with {{.Other}} was accepted",
+		"Hi {{.Username}},\n\nYou and {{.Other}} accepted the trade #{{.TradeID}}. You can now send the cards.\n"),
+	"trade.completed": newTemplate(
+		"Your trade with {{.Other}} is completed",
+		"Hi {{.Username}},\n\nThe trade #{{.TradeID}} with {{.Other}} is completed. Enjoy your new cards!\n"),
+	"message.created": newTemplate(
+		"New message from {{.Other}}",
+		"Hi {{.Username}},\n\n{{.Other}} wrote in the trade #{{.TradeID}}:\n\n{{.Message}}\n"),
+	"price.alert": newTemplate(
+		"Price changes in your cards",
+		"Hi {{.Username}},\n\nThese cards of your price alerts changed their price:\n\n{{range .Items}}- {{.}}\n{{end}}"),
+	"digest": newTemplate(
+		"Your Cardalia summary",
+		"Hi {{.Username}},\n\nThis is what happened since your last summary:\n\n{{range .Items}}- {{.}}\n{{end}}"),
+}
+
+/*
+Function	: New template
+Description	: Parse the subject and body of a template. Panics if they are not valid, like template.Must.
+Parameters 	: subject, body
+Return     	: mailTemplate
+Private
+*/
+func newTemplate(subject string, body string) mailTemplate {
+	return mailTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+/*
+Function	: Has template
+Description	: Check if there is a template with a name.
+Parameters 	: template name
+Return     	: bool
+*/
+func HasTemplate(name string) bool {
+	_, ok := templates[name]
+	return ok
+}
+
+/*
+Function	: Render
+Description	: Build an email with a template.
+Parameters 	: template name, recipient address, TemplateData
+Return     	: Mail, error
+*/
+func Render(name string, to string, data TemplateData) (Mail, error) {
+	mail := Mail{To: to}
+	mailTemplate, ok := templates[name]
+	if !ok {
+		return mail, fmt.Errorf("unknown mail template %q", name)
+	}
+	var subject, body strings.Builder
+	if err := mailTemplate.subject.Execute(&subject, data); err != nil {
+		return mail, err
+	}
+	if err := mailTemplate.body.Execute(&body, data); err != nil {
+		return mail, err
+	}
+	mail.Subject = subject.String()
+	mail.Body = body.String()
+	return mail, nil
+}